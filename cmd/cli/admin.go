@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/secretstore"
+)
+
+type adminOptions struct {
+	dir          string
+	owner        string
+	force        bool
+	counterBased bool
+	label        string
+	issuer       string
+	qrMode       string
+	quiet        bool
+}
+
+var adminOpts = adminOptions{
+	dir:    secretstore.DefaultDir,
+	owner:  secretstore.DefaultOwner,
+	qrMode: "ansi",
+}
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: i18n.Resolve(i18n.MsgCmdAdminShort),
+}
+
+var adminInitCmd = &cobra.Command{
+	Use:   "init USER",
+	Short: i18n.Resolve(i18n.MsgCmdAdminInitShort),
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAdminInit(args[0], adminOpts)
+	},
+}
+
+var adminShowCmd = &cobra.Command{
+	Use:   "show USER",
+	Short: i18n.Resolve(i18n.MsgCmdAdminShowShort),
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAdminShow(args[0], adminOpts)
+	},
+}
+
+var adminListCmd = &cobra.Command{
+	Use:   "list",
+	Short: i18n.Resolve(i18n.MsgCmdAdminListShort),
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAdminList(adminOpts)
+	},
+}
+
+var adminRemoveCmd = &cobra.Command{
+	Use:   "remove USER",
+	Short: i18n.Resolve(i18n.MsgCmdAdminRemoveShort),
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAdminRemove(args[0], adminOpts)
+	},
+}
+
+var adminResetCmd = &cobra.Command{
+	Use:   "reset USER",
+	Short: i18n.Resolve(i18n.MsgCmdAdminResetShort),
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAdminReset(args[0], adminOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminInitCmd, adminShowCmd, adminListCmd, adminRemoveCmd, adminResetCmd)
+
+	adminCmd.PersistentFlags().StringVar(&adminOpts.dir, "dir", secretstore.DefaultDir, i18n.Resolve(i18n.MsgCliFlagAdminDir))
+	adminCmd.PersistentFlags().StringVar(&adminOpts.owner, "owner", secretstore.DefaultOwner, i18n.Resolve(i18n.MsgCliFlagAdminOwner))
+	adminInitCmd.Flags().BoolVarP(&adminOpts.force, "force", "f", false, i18n.Resolve(i18n.MsgCliFlagAdminForce))
+	adminInitCmd.Flags().BoolVarP(&adminOpts.counterBased, "counter-based", "c", false, i18n.Resolve(i18n.MsgCliFlagCounterBased))
+	adminInitCmd.Flags().StringVarP(&adminOpts.label, "label", "l", "", i18n.Resolve(i18n.MsgCliFlagLabel))
+	adminInitCmd.Flags().StringVarP(&adminOpts.issuer, "issuer", "i", "", i18n.Resolve(i18n.MsgCliFlagIssuer))
+	adminInitCmd.Flags().StringVarP(&adminOpts.qrMode, "qr-mode", "Q", "ansi", i18n.Resolve(i18n.MsgCliFlagQRMode))
+	adminInitCmd.Flags().BoolVarP(&adminOpts.quiet, "quiet", "q", false, i18n.Resolve(i18n.MsgCliFlagQuiet))
+}
+
+func openAdminStore(opts adminOptions) (*secretstore.Store, error) {
+	return secretstore.Open(opts.dir, opts.owner)
+}
+
+func runAdminInit(username string, opts adminOptions) error {
+	store, err := openAdminStore(opts)
+	if err != nil {
+		return err
+	}
+	enrollOpts := enroll.DefaultOptions()
+	enrollOpts.HOTP = opts.counterBased
+	cfg, err := enroll.NewConfig(enrollOpts)
+	if err != nil {
+		return err
+	}
+	if err := store.Create(username, cfg, opts.force); err != nil {
+		if errors.Is(err, secretstore.ErrExists) {
+			return fmt.Errorf("%s", msg(i18n.MsgCliAdminExists, username))
+		}
+		return err
+	}
+	path, _ := store.Path(username)
+	logEvent(logging.LevelInfo, logging.Event{Name: logging.EventEnrolled}, username, msg(i18n.MsgCliConfigWritten, path))
+	if !opts.quiet {
+		label := opts.label
+		if label == "" {
+			label = enroll.DefaultLabel(username)
+		}
+		url := enroll.OTPAuthURL(cfg, label, opts.issuer)
+		printSetupInfo(cfg, url, initOptions{qrMode: opts.qrMode})
+	}
+	fmt.Println(msg(i18n.MsgCliConfigWritten, path))
+	return nil
+}
+
+func runAdminShow(username string, opts adminOptions) error {
+	store, err := openAdminStore(opts)
+	if err != nil {
+		return err
+	}
+	cfg, err := store.Load(username)
+	if err != nil {
+		return err
+	}
+	path, _ := store.Path(username)
+	fmt.Println(msg(i18n.MsgCliAdminShowFile, path))
+	mode := "TOTP"
+	if cfg.Mode() == config.ModeHOTP {
+		mode = fmt.Sprintf("HOTP (counter %d)", cfg.Options.HOTPCounter)
+	}
+	fmt.Println(msg(i18n.MsgCliAdminShowMode, mode))
+	fmt.Println(msg(i18n.MsgCliAdminShowScratch, len(cfg.ScratchCodes)))
+	if rl := cfg.Options.RateLimit; rl != nil {
+		fmt.Println(msg(i18n.MsgCliAdminShowRateLimit, rl.Attempts, int(rl.Interval/time.Second), len(rl.Timestamps)))
+	}
+	if cfg.Options.DisallowReuse {
+		fmt.Println(msg(i18n.MsgCliAdminShowUsedCodes, len(cfg.Options.DisallowedTimestamps)))
+	}
+	records := make([]config.LoginRecord, 0, len(cfg.Options.LastLogins))
+	for _, rec := range cfg.Options.LastLogins {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].When > records[j].When })
+	for _, rec := range records {
+		host := rec.Host
+		if rec.Service != "" {
+			host += " (" + rec.Service + ")"
+		}
+		fmt.Println(msg(i18n.MsgCliAdminShowLogin, host, time.Unix(rec.When, 0).Format(time.RFC3339)))
+	}
+	return nil
+}
+
+func runAdminList(opts adminOptions) error {
+	store, err := openAdminStore(opts)
+	if err != nil {
+		return err
+	}
+	users, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		fmt.Println(u)
+	}
+	return nil
+}
+
+func runAdminRemove(username string, opts adminOptions) error {
+	store, err := openAdminStore(opts)
+	if err != nil {
+		return err
+	}
+	if err := store.Remove(username); err != nil {
+		return err
+	}
+	logEvent(logging.LevelInfo, logging.Event{Name: logging.EventSecretRemoved}, username, msg(i18n.MsgCliAdminRemoved, username))
+	fmt.Println(msg(i18n.MsgCliAdminRemoved, username))
+	return nil
+}
+
+func runAdminReset(username string, opts adminOptions) error {
+	store, err := openAdminStore(opts)
+	if err != nil {
+		return err
+	}
+	if err := store.Update(username, func(cfg *config.Config) error {
+		cfg.ResetState()
+		return nil
+	}); err != nil {
+		return err
+	}
+	logEvent(logging.LevelInfo, logging.Event{Name: logging.EventStateReset}, username, msg(i18n.MsgCliAdminReset, username))
+	fmt.Println(msg(i18n.MsgCliAdminReset, username))
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+)
+
+type auditOptions struct {
+	log string
+	key string
+}
+
+var auditOpts = auditOptions{
+	log: logging.DefaultAuditLog,
+	key: logging.DefaultAuditKey,
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: i18n.Resolve(i18n.MsgCmdAuditShort),
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: i18n.Resolve(i18n.MsgCmdAuditVerifyShort),
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAuditVerify(auditOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.PersistentFlags().StringVar(&auditOpts.log, "log", logging.DefaultAuditLog, i18n.Resolve(i18n.MsgCliFlagAuditLog))
+	auditCmd.PersistentFlags().StringVar(&auditOpts.key, "key", logging.DefaultAuditKey, i18n.Resolve(i18n.MsgCliFlagAuditKey))
+}
+
+// runAuditVerify walks the whole hash chain and fails at the first record
+// that was modified, removed or inserted.
+func runAuditVerify(opts auditOptions) error {
+	key, err := logging.LoadAuditKey(opts.key, false)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(opts.log)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum, err := logging.VerifyAudit(f, key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(i18n.Format(i18n.MsgCliAuditIntact, i18n.Args{"log": opts.log, "records": sum.Records, "seq": sum.LastSeq, "time": sum.Last}))
+	return nil
+}
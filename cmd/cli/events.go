@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os/user"
+
+	"ggpam/pkg/logging"
+)
+
+// cliService is the service field of events logged by the CLI.
+const cliService = "ggpam"
+
+// logEvent records an event about username's secret. The CLI logs only to
+// GGPAM_LOG_FILE so that events never mix with interactive output; an empty
+// username stands for the invoking user.
+func logEvent(level logging.Level, ev logging.Event, username, text string) {
+	if username == "" {
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+	}
+	ev.User, ev.Service = username, cliService
+	logging.Emit(level, ev, text)
+}
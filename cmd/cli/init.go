@@ -15,7 +15,9 @@ import (
 	"github.com/spf13/cobra"
 
 	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
 	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
 	"ggpam/pkg/otp"
 )
 
@@ -46,6 +48,7 @@ type initOptions struct {
 	allowReuse    bool
 	label         string
 	issuer        string
+	lang          string
 	quiet         bool
 	qrMode        string
 	qrInverse     bool
@@ -92,6 +95,7 @@ func init() {
 	initCmd.Flags().BoolVarP(&initOpts.allowReuse, "allow-reuse", "D", false, i18n.Resolve(i18n.MsgCliFlagAllowReuse))
 	initCmd.Flags().StringVarP(&initOpts.label, "label", "l", defaultLabel(), i18n.Resolve(i18n.MsgCliFlagLabel))
 	initCmd.Flags().StringVarP(&initOpts.issuer, "issuer", "i", "", i18n.Resolve(i18n.MsgCliFlagIssuer))
+	initCmd.Flags().StringVar(&initOpts.lang, "lang", "", i18n.Resolve(i18n.MsgCliFlagLang))
 	initCmd.Flags().BoolVarP(&initOpts.quiet, "quiet", "q", false, i18n.Resolve(i18n.MsgCliFlagQuiet))
 	initCmd.Flags().StringVarP(&initOpts.qrMode, "qr-mode", "Q", "ansi", i18n.Resolve(i18n.MsgCliFlagQRMode))
 	initCmd.Flags().BoolVar(&initOpts.qrInverse, "qr-inverse", false, i18n.Resolve(i18n.MsgCliFlagQRInverse))
@@ -147,6 +151,9 @@ func runInit(cmd *cobra.Command, opts initOptions) error {
 	if opts.scratch < 0 || opts.scratch > maxScratchCodes {
 		return fmt.Errorf("%s", msg(i18n.MsgCliScratchRange, maxScratchCodes))
 	}
+	if opts.lang != "" && !i18n.ValidLocale(opts.lang) {
+		return fmt.Errorf("%s", msg(i18n.MsgCliInvalidLang, opts.lang))
+	}
 
 	secret, err := util.RandomSecret(20)
 	if err != nil {
@@ -163,6 +170,7 @@ func runInit(cmd *cobra.Command, opts initOptions) error {
 		Options: config.Options{
 			StepSize:   opts.step,
 			WindowSize: window,
+			Lang:       opts.lang,
 			Additional: map[string]string{},
 		},
 	}
@@ -202,6 +210,7 @@ func runInit(cmd *cobra.Command, opts initOptions) error {
 	if err := cfg.Save(path, DefaultSecretFilePerm); err != nil {
 		return err
 	}
+	logEvent(logging.LevelInfo, logging.Event{Name: logging.EventEnrolled}, "", i18n.Msgf(i18n.MsgCliConfigWritten, path))
 	if !opts.quiet {
 		fmt.Println(i18n.Msgf(i18n.MsgCliConfigWritten, path))
 	}
@@ -309,25 +318,7 @@ func determineRateLimit(cmd *cobra.Command, opts initOptions) (*config.RateLimit
 }
 
 func buildOtpauthURL(cfg *config.Config, opts initOptions) string {
-	label := opts.label
-	issuer := opts.issuer
-	if issuer == "" {
-		issuer = label
-	}
-	params := map[string]string{
-		"secret":    cfg.Secret,
-		"issuer":    issuer,
-		"digits":    "6",
-		"algorithm": "SHA1",
-	}
-	switch cfg.Mode() {
-	case config.ModeHOTP:
-		params["counter"] = fmt.Sprintf("%d", cfg.Options.HOTPCounter)
-	default:
-		params["period"] = fmt.Sprintf("%d", cfg.Step())
-	}
-	builder := otp.NewOTPAuthBuilder(label, issuer, params, cfg.Mode())
-	return builder.String()
+	return enroll.OTPAuthURL(cfg, opts.label, opts.issuer)
 }
 
 func defaultLabel() string {
@@ -336,11 +327,7 @@ func defaultLabel() string {
 	if err == nil && current.Username != "" {
 		name = current.Username
 	}
-	host, err := os.Hostname()
-	if err != nil || host == "" {
-		host = "unix"
-	}
-	return fmt.Sprintf("%s@%s", name, host)
+	return enroll.DefaultLabel(name)
 }
 
 func confirmCode(cfg *config.Config) error {
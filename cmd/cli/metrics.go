@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+)
+
+type metricsOptions struct {
+	file   string
+	listen string
+}
+
+var metricsOpts = metricsOptions{file: metrics.DefaultTextfile}
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: i18n.Resolve(i18n.MsgCmdMetricsShort),
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runMetrics(metricsOpts)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.Flags().StringVar(&metricsOpts.file, "file", metricsOpts.file, i18n.Resolve(i18n.MsgCliFlagMetricsFile))
+	metricsCmd.Flags().StringVar(&metricsOpts.listen, "listen", "", i18n.Resolve(i18n.MsgCliFlagMetricsListen))
+}
+
+// runMetrics prints the counters that metrics_file= accumulates or, with
+// --listen, serves them on /metrics for hosts without node_exporter.
+func runMetrics(opts metricsOptions) error {
+	if opts.listen == "" {
+		s, err := metrics.ReadTextfile(opts.file)
+		if err != nil {
+			return err
+		}
+		return s.WriteText(os.Stdout)
+	}
+	_ = logging.ConfigureDefault("")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(opts.file))
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	ln, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	logging.Infof("ggpam metrics listening on %s", ln.Addr())
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
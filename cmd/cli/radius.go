@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/radius"
+	"ggpam/pkg/service"
+)
+
+type radiusOptions struct {
+	listen     string
+	secretFile string
+	challenge  bool
+	ignorePass bool
+}
+
+var radiusOpts = radiusOptions{listen: ":1812"}
+
+var radiusCmd = &cobra.Command{
+	Use:   "radius [module params...]",
+	Short: i18n.Resolve(i18n.MsgCmdRadiusShort),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRadius(radiusOpts, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(radiusCmd)
+	radiusCmd.Flags().StringVar(&radiusOpts.listen, "listen", radiusOpts.listen, i18n.Resolve(i18n.MsgCliFlagRadiusListen))
+	radiusCmd.Flags().StringVar(&radiusOpts.secretFile, "secret-file", "", i18n.Resolve(i18n.MsgCliFlagRadiusSecretFile))
+	radiusCmd.Flags().BoolVar(&radiusOpts.challenge, "challenge", false, i18n.Resolve(i18n.MsgCliFlagRadiusChallenge))
+	radiusCmd.Flags().BoolVar(&radiusOpts.ignorePass, "ignore-password", false, i18n.Resolve(i18n.MsgCliFlagRadiusIgnorePass))
+}
+
+// runRadius answers RADIUS Access-Requests for the secrets described by
+// module params, e.g.
+// "ggpam radius --secret-file /etc/ggpam/radius-secret secret=/var/lib/ggpam/%u".
+func runRadius(opts radiusOptions, args []string) error {
+	_ = logging.ConfigureDefault("")
+	params, err := pamcfg.ParseParams(args)
+	if err != nil {
+		return fmt.Errorf("%s", msg(i18n.MsgInvalidArgs, err))
+	}
+	if err := logging.ConfigureAudit(params.AuditLog, params.AuditKey); err != nil {
+		return fmt.Errorf("%s", msg(i18n.MsgAuditOpenFailed, err))
+	}
+	metrics.Configure(params.MetricsFile)
+	if opts.secretFile == "" {
+		return errors.New(msg(i18n.MsgCliRadiusNeedSecret))
+	}
+	secret, err := os.ReadFile(opts.secretFile)
+	if err != nil {
+		return err
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return errors.New(msg(i18n.MsgCliRadiusNeedSecret))
+	}
+	srv := &radius.Server{Service: service.New(params), Secret: secret, Challenge: opts.challenge, IgnorePassword: opts.ignorePass}
+	if params.StateStore != "" {
+		store, err := authenticator.OpenStateStore(params.StateStore)
+		if err != nil {
+			return fmt.Errorf("%s", msg(i18n.MsgStateStoreFailed, authenticator.RedactStateStore(params.StateStore), err))
+		}
+		defer store.Close()
+		srv.Service.Store = store
+	}
+
+	conn, err := net.ListenPacket("udp", opts.listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	logging.Infof("ggpam radius listening on %s", conn.LocalAddr())
+	err = srv.Serve(ctx, conn)
+	logging.Infof("ggpam radius shutting down")
+	return err
+}
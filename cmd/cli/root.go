@@ -7,11 +7,15 @@ import (
 	"github.com/spf13/cobra"
 
 	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
 	"ggpam/pkg/version"
 )
 
 var rootCmd = &cobra.Command{
 	Use: "ggpam",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		_ = logging.ConfigureQuiet()
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		fmt.Println(cmd.UsageString())
 		return nil
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/httpapi"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+const serveShutdownTimeout = 10 * time.Second
+
+type serveOptions struct {
+	listen    string
+	tokenFile string
+	tlsCert   string
+	tlsKey    string
+	clientCA  string
+}
+
+var serveOpts = serveOptions{listen: "127.0.0.1:8740"}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [module params...]",
+	Short: i18n.Resolve(i18n.MsgCmdServeShort),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runServe(serveOpts, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveOpts.listen, "listen", serveOpts.listen, i18n.Resolve(i18n.MsgCliFlagServeListen))
+	serveCmd.Flags().StringVar(&serveOpts.tokenFile, "token-file", "", i18n.Resolve(i18n.MsgCliFlagServeTokenFile))
+	serveCmd.Flags().StringVar(&serveOpts.tlsCert, "tls-cert", "", i18n.Resolve(i18n.MsgCliFlagServeTLSCert))
+	serveCmd.Flags().StringVar(&serveOpts.tlsKey, "tls-key", "", i18n.Resolve(i18n.MsgCliFlagServeTLSKey))
+	serveCmd.Flags().StringVar(&serveOpts.clientCA, "client-ca", "", i18n.Resolve(i18n.MsgCliFlagServeClientCA))
+}
+
+// runServe serves the HTTP API for the secrets described by module params,
+// e.g. "ggpam serve --token-file /etc/ggpam/tokens secret=/var/lib/ggpam/%u".
+func runServe(opts serveOptions, args []string) error {
+	_ = logging.ConfigureDefault("")
+	params, err := pamcfg.ParseParams(args)
+	if err != nil {
+		return fmt.Errorf("%s", msg(i18n.MsgInvalidArgs, err))
+	}
+	if err := logging.ConfigureAudit(params.AuditLog, params.AuditKey); err != nil {
+		return fmt.Errorf("%s", msg(i18n.MsgAuditOpenFailed, err))
+	}
+	metrics.Configure(params.MetricsFile)
+	if opts.tokenFile == "" && opts.clientCA == "" {
+		return errors.New(msg(i18n.MsgCliServeNeedAuth))
+	}
+	if (opts.tlsCert == "") != (opts.tlsKey == "") || (opts.clientCA != "" && opts.tlsCert == "") {
+		return errors.New(msg(i18n.MsgCliServeTLSArgs))
+	}
+	api := &httpapi.Server{Service: service.New(params), RequireClientCert: opts.clientCA != ""}
+	if opts.tokenFile != "" {
+		if api.Tokens, err = httpapi.LoadTokens(opts.tokenFile); err != nil {
+			return err
+		}
+	}
+	if params.StateStore != "" {
+		store, err := authenticator.OpenStateStore(params.StateStore)
+		if err != nil {
+			return fmt.Errorf("%s", msg(i18n.MsgStateStoreFailed, authenticator.RedactStateStore(params.StateStore), err))
+		}
+		defer store.Close()
+		api.Service.Store = store
+	}
+
+	srv := &http.Server{
+		Handler:           api.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	if opts.tlsCert != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.clientCA != "" {
+			pem, err := os.ReadFile(opts.clientCA)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", opts.clientCA)
+			}
+			srv.TLSConfig.ClientCAs = pool
+			// Token-only clients stay possible when both are configured.
+			srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			if opts.tokenFile == "" {
+				srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+	}
+
+	ln, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() {
+		if opts.tlsCert != "" {
+			errc <- srv.ServeTLS(ln, opts.tlsCert, opts.tlsKey)
+		} else {
+			errc <- srv.Serve(ln)
+		}
+	}()
+	logging.Infof("ggpam serve listening on %s", ln.Addr())
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	logging.Infof("ggpam serve shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -10,6 +10,8 @@ import (
 	"ggpam/pkg/authenticator"
 	"ggpam/pkg/config"
 	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/service"
 )
 
 type verifyOptions struct {
@@ -57,16 +59,21 @@ func runVerify(opts verifyOptions) error {
 	auth := &authenticator.Authenticator{
 		Responder: verifyResponder{quiet: opts.quiet},
 	}
-	_, err = auth.VerifyCode(cfg, opts.code, authenticator.VerifyOptions{
+	res, err := auth.VerifyCode(cfg, opts.code, authenticator.VerifyOptions{
 		DisableSkewAdjustment: opts.noSkew,
 		NoIncrementHOTP:       opts.noIncrement,
 	})
 	if err != nil {
+		logEvent(logging.LevelInfo, logging.Event{Name: logging.EventAuthFailure}.WithError(service.ErrorClass(err), err), "", fmt.Sprintf("verify %s failed: %v", path, err))
 		if errors.Is(err, config.ErrRateLimited) {
 			return fmt.Errorf("%s", i18n.Resolve(i18n.MsgCliVerifyRateLimited))
 		}
 		return err
 	}
+	if res.SkewReset {
+		logEvent(logging.LevelInfo, logging.Event{Name: logging.EventSkewReset}, "", fmt.Sprintf("verify %s: time skew reset", path))
+	}
+	logEvent(logging.LevelInfo, logging.Event{Name: logging.EventAuthSuccess}.WithResult(string(res.Type)), "", fmt.Sprintf("verify %s succeeded (%s)", path, res.Type))
 	if cfg.Dirty {
 		if err := cfg.Save(path, DefaultSecretFilePerm); err != nil {
 			return err
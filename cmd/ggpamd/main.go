@@ -0,0 +1,121 @@
+// Command ggpamd owns the secret files and answers verification, enrollment
+// and status requests from the PAM module (daemon=) over a Unix socket.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/daemon"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+	"ggpam/pkg/version"
+)
+
+type daemonOptions struct {
+	socket      string
+	socketMode  string
+	allowUsers  []string
+	allowGroups []string
+}
+
+var opts = daemonOptions{
+	socket:     daemon.DefaultSocket,
+	socketMode: "0666",
+}
+
+var rootCmd = &cobra.Command{
+	Use:     "ggpamd [module params...]",
+	Short:   i18n.Resolve(i18n.MsgCmdDaemonShort),
+	Version: version.Version,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(opts, args)
+	},
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&opts.socket, "socket", daemon.DefaultSocket, i18n.Resolve(i18n.MsgCliFlagDaemonSocket))
+	rootCmd.Flags().StringVar(&opts.socketMode, "socket-mode", "0666", i18n.Resolve(i18n.MsgCliFlagDaemonSocketMode))
+	rootCmd.Flags().StringSliceVar(&opts.allowUsers, "allow-user", nil, i18n.Resolve(i18n.MsgCliFlagDaemonAllowUser))
+	rootCmd.Flags().StringSliceVar(&opts.allowGroups, "allow-group", nil, i18n.Resolve(i18n.MsgCliFlagDaemonAllowGroup))
+}
+
+// run parses the module params given as arguments, e.g.
+// "ggpamd secret=/var/lib/ggpam/%u grace_period=300", and serves until
+// SIGINT or SIGTERM.
+func run(opts daemonOptions, args []string) error {
+	_ = logging.ConfigureDefault("")
+	params, err := pamcfg.ParseParams(args)
+	if err != nil {
+		return fmt.Errorf("%s", i18n.Msgf(i18n.MsgInvalidArgs, err))
+	}
+	if err := logging.ConfigureAudit(params.AuditLog, params.AuditKey); err != nil {
+		return fmt.Errorf("%s", i18n.Msgf(i18n.MsgAuditOpenFailed, err))
+	}
+	metrics.Configure(params.MetricsFile)
+	mode, err := strconv.ParseUint(opts.socketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid --socket-mode %q", opts.socketMode)
+	}
+	srv := &daemon.Server{
+		Service:   service.New(params),
+		AllowUIDs: map[uint32]bool{},
+		AllowGIDs: map[uint32]bool{},
+	}
+	for _, name := range opts.allowUsers {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		srv.AllowUIDs[uint32(uid)] = true
+	}
+	for _, name := range opts.allowGroups {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return err
+		}
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		srv.AllowGIDs[uint32(gid)] = true
+	}
+	if params.StateStore != "" {
+		store, err := authenticator.OpenStateStore(params.StateStore)
+		if err != nil {
+			return fmt.Errorf("%s", i18n.Msgf(i18n.MsgStateStoreFailed, authenticator.RedactStateStore(params.StateStore), err))
+		}
+		defer store.Close()
+		srv.Service.Store = store
+	}
+
+	ln, err := daemon.Listen(opts.socket, os.FileMode(mode))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(opts.socket)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	logging.Infof("ggpamd %s listening on %s", version.Version, opts.socket)
+	if err := srv.Serve(ctx, ln); err != nil {
+		return err
+	}
+	logging.Infof("ggpamd shutting down")
+	return nil
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%s\n", i18n.Msgf(i18n.MsgCliExecFailed, err))
+		os.Exit(1)
+	}
+}
@@ -18,6 +18,10 @@ static void error_wrapper(pam_handle_t *pamh, const char *text) {
 	pam_error(pamh, "%s", text);
 }
 
+static void info_wrapper(pam_handle_t *pamh, const char *text) {
+	pam_info(pamh, "%s", text);
+}
+
 static void syslog_wrapper(pam_handle_t *pamh, int priority, const char *text) {
 	pam_syslog(pamh, priority, "%s", text);
 }
@@ -25,31 +29,28 @@ static void syslog_wrapper(pam_handle_t *pamh, int priority, const char *text) {
 import "C"
 
 import (
-	"errors"
-	"fmt"
-	"os"
-	"os/user"
-	"strconv"
-	"strings"
-	"syscall"
-	"time"
 	"unsafe"
 
-	"golang.org/x/sys/unix"
-
-	"ggpam/pkg/authenticator"
-	"ggpam/pkg/config"
 	"ggpam/pkg/i18n"
 	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
 	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/pammodule"
 )
 
-var (
-	fallbackUser = "nobody"
-)
+var module = &pammodule.Module{}
 
-func msg(key string, args ...any) string {
-	return i18n.Msgf(key, args...)
+func init() {
+	// The host application's name would hide the module in journald and
+	// syslog; pam_syslog records carry pam_ggpam the same way.
+	logging.Ident = "pam_ggpam"
+}
+
+// RegisterHostResolver replaces the resolver used for resolve_rhost.
+func RegisterHostResolver(r pamcfg.Resolver) {
+	if r != nil {
+		module.Resolver = r
+	}
 }
 
 //export pam_sm_authenticate
@@ -62,127 +63,104 @@ func pam_sm_setcred(pamh *C.pam_handle_t, flags C.int, argc C.int, argv *C.pam_c
 	return goPamSetcred(pamh, flags, argc, (**C.char)(unsafe.Pointer(argv)))
 }
 
+//export pam_sm_acct_mgmt
+func pam_sm_acct_mgmt(pamh *C.pam_handle_t, flags C.int, argc C.int, argv *C.pam_const_char) C.int {
+	return goPamAcctMgmt(pamh, flags, argc, (**C.char)(unsafe.Pointer(argv)))
+}
+
+//export pam_sm_chauthtok
+func pam_sm_chauthtok(pamh *C.pam_handle_t, flags C.int, argc C.int, argv *C.pam_const_char) C.int {
+	return goPamChauthtok(pamh, flags, argc, (**C.char)(unsafe.Pointer(argv)))
+}
+
+//export pam_sm_open_session
+func pam_sm_open_session(pamh *C.pam_handle_t, flags C.int, argc C.int, argv *C.pam_const_char) C.int {
+	return goPamOpenSession(pamh, flags, argc, (**C.char)(unsafe.Pointer(argv)))
+}
+
+//export pam_sm_close_session
+func pam_sm_close_session(pamh *C.pam_handle_t, flags C.int, argc C.int, argv *C.pam_const_char) C.int {
+	return C.PAM_SUCCESS
+}
+
 func goPamAuthenticate(pamh *C.pam_handle_t, flags C.int, argc C.int, argv **C.char) C.int {
-	_ = logging.ConfigureDefault("")
-	args := parsePamArgs(argc, argv)
-	params, err := pamcfg.ParseParams(args)
-	if err != nil {
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgInvalidArgs, err))
+	h, params, ok := setup(pamh, argc, argv)
+	if !ok {
 		return C.PAM_SERVICE_ERR
 	}
-	return runPamAuth(pamh, params)
+	// Deferred past Authenticate, which restores privileges on return, so
+	// that the textfile is written as root.
+	defer flushMetrics()
+	return cStatus(module.Authenticate(h, params))
 }
 
 func goPamSetcred(pamh *C.pam_handle_t, flags C.int, argc C.int, argv **C.char) C.int {
 	return C.PAM_SUCCESS
 }
 
-func runPamAuth(pamh *C.pam_handle_t, params pamcfg.Params) C.int {
-	pamUser, rc := getPamUser(pamh)
-	if rc != C.PAM_SUCCESS || pamUser == "" {
-		return rc
-	}
-	targetUser := pamUser
-	if params.ForcedUser != "" {
-		targetUser = params.ForcedUser
-	}
-	pamDebugf(pamh, params, "start for user %s", targetUser)
-
-	account, err := lookupAccount(targetUser)
-	if err != nil {
-		pamSyslog(pamh, C.LOG_WARNING, msg(i18n.MsgUserLookupFailed, targetUser, err))
-		if fallback, ferr := lookupAccount(fallbackUser); ferr == nil {
-			pamSyslog(pamh, C.LOG_INFO, msg(i18n.MsgFallbackUser, fallbackUser))
-			account = fallback
-			_ = logging.UpdateHome(account.HomeDir)
-		} else {
-			return C.PAM_SERVICE_ERR
-		}
-	}
-	_ = logging.UpdateHome(account.HomeDir)
-
-	privState, err := dropPrivileges(account)
-	if err != nil {
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgDropPrivilegesFailed, account.Username, err))
+func goPamAcctMgmt(pamh *C.pam_handle_t, flags C.int, argc C.int, argv **C.char) C.int {
+	h, params, ok := setup(pamh, argc, argv)
+	if !ok {
 		return C.PAM_SERVICE_ERR
 	}
-	defer restorePrivileges(privState)
-	_ = logging.UpdateHome(account.HomeDir)
+	return cStatus(module.AcctMgmt(h, params))
+}
 
-	secretPath, err := pamcfg.ResolveSecretPath(params.SecretSpec, account)
-	if err != nil {
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgResolveSecretFailed, err))
+func goPamChauthtok(pamh *C.pam_handle_t, flags C.int, argc C.int, argv **C.char) C.int {
+	h, params, ok := setup(pamh, argc, argv)
+	if !ok {
 		return C.PAM_SERVICE_ERR
 	}
-	pamDebugf(pamh, params, "using secret file %s", secretPath)
-
-	cfg, state, err := pamcfg.LoadConfig(account, secretPath, params)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) && params.NullOK {
-			pamSyslog(pamh, C.LOG_INFO, msg(i18n.MsgUserNoSecretNullOK, targetUser))
-			return C.PAM_IGNORE
-		}
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgReadConfigFailed, secretPath, err))
-		pamError(pamh, msg(i18n.MsgReadConfigFailed, secretPath, err))
-		return C.PAM_AUTH_ERR
+	var f pammodule.ChauthtokFlags
+	if flags&C.PAM_PRELIM_CHECK != 0 {
+		f |= pammodule.PrelimCheck
 	}
-
-	rhost := getPamRhost(pamh)
-	if rhost != "" {
-		pamDebugf(pamh, params, "received PAM_RHOST=%s", rhost)
+	if flags&C.PAM_UPDATE_AUTHTOK != 0 {
+		f |= pammodule.UpdateAuthtok
 	}
-	if params.PromptTemplate != "" {
-		rendered, err := preparePromptFromTemplate(pamh, params.PromptTemplate, account, targetUser, rhost)
-		if err != nil {
-			pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgPromptTemplateFailed, err))
-			return C.PAM_SERVICE_ERR
-		}
-		params.Prompt = rendered
-		pamDebugf(pamh, params, "using prompt template %s", params.PromptTemplate)
+	if flags&C.PAM_CHANGE_EXPIRED_AUTHTOK != 0 {
+		f |= pammodule.ChangeExpiredAuthtok
 	}
-	if params.GracePeriod > 0 && cfg.WithinGracePeriod(rhost, params.GracePeriod, time.Now()) {
-		pamSyslog(pamh, C.LOG_INFO, msg(i18n.MsgGraceSkip, rhost))
-		cfg.UpdateLoginRecord(rhost, time.Now())
-		pamDebugf(pamh, params, "grace period hit for host %s", rhost)
-		if rc := persistConfig(pamh, cfg, secretPath, params, account, state); rc != C.PAM_SUCCESS {
-			return rc
-		}
+	return cStatus(module.Chauthtok(h, params, f))
+}
+
+func goPamOpenSession(pamh *C.pam_handle_t, flags C.int, argc C.int, argv **C.char) C.int {
+	h, params, ok := setup(pamh, argc, argv)
+	if !ok {
+		return C.PAM_SERVICE_ERR
+	}
+	if flags&C.PAM_SILENT != 0 {
 		return C.PAM_SUCCESS
 	}
+	return cStatus(module.OpenSession(h, params))
+}
 
-	code, remainder, rc := obtainOTP(pamh, params)
-	if rc != C.PAM_SUCCESS {
-		return rc
-	}
-	auth := &authenticator.Authenticator{}
-	res, err := auth.VerifyCode(cfg, code, authenticator.VerifyOptions{
-		DisableSkewAdjustment: params.NoSkewAdjust,
-		NoIncrementHOTP:       params.NoIncrementHOTP,
-	})
+func setup(pamh *C.pam_handle_t, argc C.int, argv **C.char) (handle, pamcfg.Params, bool) {
+	_ = logging.ConfigureDefault("")
+	h := handle{pamh}
+	params, err := pamcfg.ParseParams(parsePamArgs(argc, argv))
 	if err != nil {
-		if errors.Is(err, authenticator.ErrInvalidCode) || errors.Is(err, config.ErrRateLimited) {
-			pamError(pamh, err.Error())
-			pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgUserAuthFailed, targetUser, err))
-			return C.PAM_AUTH_ERR
-		}
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgAuthFailedGeneric, err))
-		pamError(pamh, msg(i18n.MsgInternalError))
-		return C.PAM_AUTH_ERR
-	}
-	if params.ForwardPass && remainder != "" {
-		if rc := setPamAuthtok(pamh, remainder); rc != C.PAM_SUCCESS {
-			pamSyslog(pamh, C.LOG_WARNING, msg(i18n.MsgUpdateAuthtokFailed))
-		}
-	}
-	if params.GracePeriod > 0 && rhost != "" {
-		cfg.UpdateLoginRecord(rhost, time.Now())
-	}
-	if rc := persistConfig(pamh, cfg, secretPath, params, account, state); rc != C.PAM_SUCCESS {
-		return rc
+		text := i18n.Msgf(i18n.MsgInvalidArgs, err)
+		logging.Errorf("%s", text)
+		h.Syslog(pammodule.LogErr, logging.Redact(text))
+		return h, params, false
+	}
+	// Opened before privileges are dropped so that the root-owned audit
+	// log stays writable.
+	if err := logging.ConfigureAudit(params.AuditLog, params.AuditKey); err != nil {
+		text := i18n.Msgf(i18n.MsgAuditOpenFailed, err)
+		logging.Errorf("%s", text)
+		h.Syslog(pammodule.LogErr, logging.Redact(text))
+		return h, params, false
+	}
+	metrics.Configure(params.MetricsFile)
+	return h, params, true
+}
+
+func flushMetrics() {
+	if err := metrics.Flush(); err != nil {
+		logging.Errorf("metrics: %v", err)
 	}
-	pamDebugf(pamh, params, "authentication completed for %s", targetUser)
-	pamSyslog(pamh, C.LOG_INFO, msg(i18n.MsgUserAuthSuccess, targetUser, res.Type))
-	return C.PAM_SUCCESS
 }
 
 func parsePamArgs(argc C.int, argv **C.char) []string {
@@ -198,246 +176,127 @@ func parsePamArgs(argc C.int, argv **C.char) []string {
 	return slice
 }
 
-func obtainOTP(pamh *C.pam_handle_t, params pamcfg.Params) (string, string, C.int) {
-	switch params.PassMode {
-	case pamcfg.ModeUseFirst:
-		pw, rc := getPamAuthtok(pamh)
-		if rc != C.PAM_SUCCESS {
-			return "", "", rc
-		}
-		logDummyPassword(pamh, params, pw)
-		code, rest, ok := extractOTP(pw)
-		if !ok {
-			return "", "", C.PAM_AUTH_ERR
-		}
-		return code, rest, C.PAM_SUCCESS
-	case pamcfg.ModeTryFirst:
-		if pw, rc := getPamAuthtok(pamh); rc == C.PAM_SUCCESS && pw != "" {
-			logDummyPassword(pamh, params, pw)
-			if code, rest, ok := extractOTP(pw); ok {
-				return code, rest, C.PAM_SUCCESS
-			}
-		}
-		return promptCode(pamh, params.Prompt, params.EchoCode)
-	default:
-		return promptCode(pamh, params.Prompt, params.EchoCode)
-	}
+// handle adapts a pam_handle_t to pammodule.Handle.
+type handle struct {
+	pamh *C.pam_handle_t
 }
 
-func promptCode(pamh *C.pam_handle_t, prompt string, echo bool) (string, string, C.int) {
-	var resp *C.char
-	style := C.PAM_PROMPT_ECHO_OFF
-	if echo {
-		style = C.PAM_PROMPT_ECHO_ON
-	}
-	cPrompt := C.CString(prompt)
-	defer C.free(unsafe.Pointer(cPrompt))
-	rc := C.prompt_wrapper(pamh, C.int(style), &resp, cPrompt)
-	if rc != C.PAM_SUCCESS {
-		return "", "", rc
-	}
-	defer C.free(unsafe.Pointer(resp))
-	code := strings.TrimSpace(C.GoString(resp))
-	if code == "" {
-		return "", "", C.PAM_AUTH_ERR
-	}
-	return code, "", C.PAM_SUCCESS
+var statusCodes = map[pammodule.Status]C.int{
+	pammodule.Success:     C.PAM_SUCCESS,
+	pammodule.ServiceErr:  C.PAM_SERVICE_ERR,
+	pammodule.PermDenied:  C.PAM_PERM_DENIED,
+	pammodule.AuthErr:     C.PAM_AUTH_ERR,
+	pammodule.UserUnknown: C.PAM_USER_UNKNOWN,
+	pammodule.MaxTries:    C.PAM_MAXTRIES,
+	pammodule.ConvErr:     C.PAM_CONV_ERR,
+	pammodule.AuthtokErr:  C.PAM_AUTHTOK_ERR,
+	pammodule.Ignore:      C.PAM_IGNORE,
+	pammodule.Abort:       C.PAM_ABORT,
 }
 
-func extractOTP(raw string) (string, string, bool) {
-	if raw == "" {
-		return "", "", false
-	}
-	if code, rest, ok := splitDigits(raw, 6); ok {
-		return code, rest, true
-	}
-	if code, rest, ok := splitDigits(raw, 8); ok {
-		return code, rest, true
+// cStatus and goStatus translate the codes the module uses; other libpam
+// codes, such as PAM_INCOMPLETE from a conversation, pass through unchanged.
+func cStatus(s pammodule.Status) C.int {
+	if rc, ok := statusCodes[s]; ok {
+		return rc
 	}
-	return "", "", false
+	return C.int(s)
 }
 
-func splitDigits(raw string, length int) (string, string, bool) {
-	if len(raw) < length {
-		return "", "", false
-	}
-	code := raw[len(raw)-length:]
-	if !onlyDigits(code) {
-		return "", "", false
+func goStatus(rc C.int) pammodule.Status {
+	for s, c := range statusCodes {
+		if c == rc {
+			return s
+		}
 	}
-	return code, raw[:len(raw)-length], true
+	return pammodule.Status(rc)
 }
 
-func onlyDigits(s string) bool {
-	for _, r := range s {
-		if r < '0' || r > '9' {
-			return false
-		}
-	}
-	return true
+var itemTypes = map[pammodule.Item]C.int{
+	pammodule.ItemService: C.PAM_SERVICE,
+	pammodule.ItemUser:    C.PAM_USER,
+	pammodule.ItemRhost:   C.PAM_RHOST,
+	pammodule.ItemAuthtok: C.PAM_AUTHTOK,
 }
 
-func logDummyPassword(pamh *C.pam_handle_t, params pamcfg.Params, pw string) {
-	if len(pw) > 0 && pw[0] == '\b' {
-		pamSyslog(pamh, C.LOG_INFO, msg(i18n.MsgDummyPassword))
-	}
+var priorities = map[pammodule.Priority]C.int{
+	pammodule.LogErr:     C.LOG_ERR,
+	pammodule.LogWarning: C.LOG_WARNING,
+	pammodule.LogInfo:    C.LOG_INFO,
+	pammodule.LogDebug:   C.LOG_DEBUG,
 }
 
-func getPamUser(pamh *C.pam_handle_t) (string, C.int) {
+func (h handle) User() (string, pammodule.Status) {
 	var cUser *C.char
-	rc := C.pam_get_user(pamh, &cUser, nil)
-	if rc != C.PAM_SUCCESS {
-		return "", rc
+	if rc := C.pam_get_user(h.pamh, &cUser, nil); rc != C.PAM_SUCCESS {
+		return "", goStatus(rc)
 	}
-	return C.GoString(cUser), C.PAM_SUCCESS
+	return C.GoString(cUser), pammodule.Success
 }
 
-func getPamAuthtok(pamh *C.pam_handle_t) (string, C.int) {
+func (h handle) Item(it pammodule.Item) (string, pammodule.Status) {
 	var item unsafe.Pointer
-	rc := C.pam_get_item(pamh, C.PAM_AUTHTOK, &item)
-	if rc != C.PAM_SUCCESS {
-		return "", rc
+	if rc := C.pam_get_item(h.pamh, itemTypes[it], &item); rc != C.PAM_SUCCESS {
+		return "", goStatus(rc)
 	}
 	if item == nil {
-		return "", C.PAM_AUTHTOK_ERR
+		return "", pammodule.Success
 	}
-	return C.GoString((*C.char)(item)), C.PAM_SUCCESS
+	return C.GoString((*C.char)(item)), pammodule.Success
 }
 
-func setPamAuthtok(pamh *C.pam_handle_t, value string) C.int {
+func (h handle) SetItem(it pammodule.Item, value string) pammodule.Status {
 	cValue := C.CString(value)
 	defer C.free(unsafe.Pointer(cValue))
-	return C.pam_set_item(pamh, C.PAM_AUTHTOK, unsafe.Pointer(cValue))
+	return goStatus(C.pam_set_item(h.pamh, itemTypes[it], unsafe.Pointer(cValue)))
 }
 
-func getPamRhost(pamh *C.pam_handle_t) string {
-	var item unsafe.Pointer
-	if C.pam_get_item(pamh, C.PAM_RHOST, &item) != C.PAM_SUCCESS || item == nil {
-		return ""
+func (h handle) Prompt(style pammodule.Style, text string) (string, pammodule.Status) {
+	var resp *C.char
+	cStyle := C.PAM_PROMPT_ECHO_OFF
+	if style == pammodule.PromptEchoOn {
+		cStyle = C.PAM_PROMPT_ECHO_ON
 	}
-	return C.GoString((*C.char)(item))
-}
-
-func pamError(pamh *C.pam_handle_t, text string) {
 	cText := C.CString(text)
 	defer C.free(unsafe.Pointer(cText))
-	C.error_wrapper(pamh, cText)
-}
-
-func pamSyslog(pamh *C.pam_handle_t, priority C.int, msg string) {
-	logWithPriority(priority, msg)
-	cText := C.CString(msg)
-	defer C.free(unsafe.Pointer(cText))
-	C.syslog_wrapper(pamh, priority, cText)
-}
-
-func pamDebugf(pamh *C.pam_handle_t, params pamcfg.Params, format string, args ...interface{}) {
-	if !params.Debug {
-		return
+	if rc := C.prompt_wrapper(h.pamh, C.int(cStyle), &resp, cText); rc != C.PAM_SUCCESS {
+		return "", goStatus(rc)
 	}
-	message := fmt.Sprintf("debug: "+format, args...)
-	pamSyslog(pamh, C.LOG_DEBUG, message)
-}
-
-type privilegeState struct {
-	origUID int
-	origGID int
-	dropped bool
+	if resp == nil {
+		return "", pammodule.Success
+	}
+	defer C.free(unsafe.Pointer(resp))
+	return C.GoString(resp), pammodule.Success
 }
 
-func dropPrivileges(account *user.User) (*privilegeState, error) {
-	state := &privilegeState{
-		origUID: unix.Geteuid(),
-		origGID: unix.Getegid(),
-	}
-	uid, err := strconv.Atoi(account.Uid)
-	if err != nil {
-		return nil, err
-	}
-	gid, err := strconv.Atoi(account.Gid)
-	if err != nil {
-		return nil, err
-	}
-	if state.origUID == uid && state.origGID == gid {
-		return state, nil
-	}
-	if err := unix.Setresgid(-1, gid, -1); err != nil {
-		return nil, err
-	}
-	if err := unix.Setresuid(-1, uid, -1); err != nil {
-		unix.Setresgid(-1, state.origGID, -1)
-		return nil, err
-	}
-	state.dropped = true
-	return state, nil
+func (h handle) Error(text string) {
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+	C.error_wrapper(h.pamh, cText)
 }
 
-func restorePrivileges(state *privilegeState) {
-	if state == nil || !state.dropped {
-		return
-	}
-	unix.Setresuid(-1, state.origUID, -1)
-	unix.Setresgid(-1, state.origGID, -1)
+func (h handle) Info(text string) {
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+	C.info_wrapper(h.pamh, cText)
 }
 
-func lookupAccount(name string) (*user.User, error) {
-	if name == "" {
-		return nil, fmt.Errorf("%s", msg(i18n.MsgEmptyUsername))
-	}
-	u, err := user.Lookup(name)
-	if err == nil {
-		return u, nil
-	}
-	if _, convErr := strconv.Atoi(name); convErr == nil {
-		if u, err2 := user.LookupId(name); err2 == nil {
-			return u, nil
-		}
-	}
-	return nil, err
+func (h handle) Syslog(priority pammodule.Priority, text string) {
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+	C.syslog_wrapper(h.pamh, priorities[priority], cText)
 }
 
-func persistConfig(pamh *C.pam_handle_t, cfg *config.Config, path string, params pamcfg.Params, account *user.User, state pamcfg.FileState) C.int {
-	if !cfg.Dirty {
-		return C.PAM_SUCCESS
-	}
-	data, err := cfg.Bytes()
-	if err != nil {
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgSerializeConfigFailed, err))
-		pamError(pamh, msg(i18n.MsgInternalError))
-		return C.PAM_AUTH_ERR
-	}
-	err = pamcfg.WriteConfig(account, path, data, params.AllowedPerm, state)
-	if err != nil {
-		if errors.Is(err, pamcfg.ErrSecretModified) {
-			pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgSecretChangedDuringProcess))
-			pamError(pamh, msg(i18n.MsgSecretChangedRetry))
-			return C.PAM_AUTH_ERR
-		}
-		if params.AllowReadonly && (errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EPERM)) {
-			pamSyslog(pamh, C.LOG_WARNING, msg(i18n.MsgReadonlyWriteIgnored, err))
-			return C.PAM_SUCCESS
-		}
-		pamSyslog(pamh, C.LOG_ERR, msg(i18n.MsgWriteConfigFailed, path, err))
-		pamError(pamh, msg(i18n.MsgUpdateConfigFailed))
-		return C.PAM_AUTH_ERR
-	}
-	if err := applySelinuxContext(path); err != nil {
-		pamSyslog(pamh, C.LOG_DEBUG, fmt.Sprintf("setting SELinux type \"%s\" on file \"%s\" failed. Okay if SELinux is disabled: %v", selinuxSecretType, path, err))
-	}
-	return C.PAM_SUCCESS
+func (h handle) PutEnv(name, value string) pammodule.Status {
+	cEnv := C.CString(name + "=" + value)
+	defer C.free(unsafe.Pointer(cEnv))
+	return goStatus(C.pam_putenv(h.pamh, cEnv))
 }
 
-func logWithPriority(priority C.int, msg string) {
-	switch priority {
-	case C.LOG_DEBUG:
-		logging.Debugf("%s", msg)
-	case C.LOG_WARNING:
-		logging.Warnf("%s", msg)
-	case C.LOG_ERR:
-		logging.Errorf("%s", msg)
-	default:
-		logging.Infof("%s", msg)
-	}
+func (h handle) GetEnv(name string) string {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return C.GoString(C.pam_getenv(h.pamh, cName))
 }
 
 func main() {}
@@ -50,6 +50,7 @@ func (t *totpAlgorithm) Verify(cfg *config.Config, secret []byte, code int, opts
 				Type:          ResultTOTP,
 				Timestamp:     tm + int64(skew),
 				ConfigChanged: true,
+				SkewReset:     true,
 			}, nil
 		}
 	}
@@ -1,6 +1,7 @@
 package authenticator
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -27,6 +28,9 @@ var (
 type VerifyOptions struct {
 	DisableSkewAdjustment bool
 	NoIncrementHOTP       bool
+	// StateKey identifies the user in the shared StateStore; the store is
+	// only consulted when both Authenticator.Store and StateKey are set.
+	StateKey string
 }
 
 type Result struct {
@@ -34,6 +38,9 @@ type Result struct {
 	Counter       int64
 	Timestamp     int64
 	ConfigChanged bool
+	// SkewReset reports that the code matched only after the TOTP time
+	// skew was re-learned from the last few attempts.
+	SkewReset bool
 }
 
 type ResponseHandler interface {
@@ -49,6 +56,7 @@ func (nopResponder) OnError(error)    {}
 type Authenticator struct {
 	Now        func() time.Time
 	Responder  ResponseHandler
+	Store      StateStore
 	algorithms map[config.Mode]Algorithm
 }
 
@@ -60,6 +68,10 @@ func (a *Authenticator) now() time.Time {
 }
 
 func (a *Authenticator) VerifyCode(cfg *config.Config, raw string, opts VerifyOptions) (Result, error) {
+	return a.VerifyCodeContext(context.Background(), cfg, raw, opts)
+}
+
+func (a *Authenticator) VerifyCodeContext(ctx context.Context, cfg *config.Config, raw string, opts VerifyOptions) (Result, error) {
 	responder := a.responder()
 	if cfg == nil {
 		err := errors.New("config is nil")
@@ -71,56 +83,127 @@ func (a *Authenticator) VerifyCode(cfg *config.Config, raw string, opts VerifyOp
 		return Result{}, ErrNoSecret
 	}
 	now := a.now()
-	if err := cfg.EnforceRateLimit(now); err != nil {
+	store := a.store(opts)
+	failuresOnly := cfg.FailuresOnlyRateLimit()
+	if err := a.checkRateLimit(ctx, cfg, store, opts.StateKey, failuresOnly, now); err != nil {
 		responder.OnError(err)
 		return Result{}, err
 	}
 	dirtyBefore := cfg.Dirty
+	res, err := a.verify(cfg, raw, opts, now)
+	if err == nil && store != nil && res.Type == ResultTOTP && cfg.Options.DisallowReuse {
+		err = claimCounter(ctx, store, opts.StateKey, res.Timestamp, now, reuseTTL(cfg.Step(), cfg.Window()))
+	}
+	if err != nil {
+		// Store outages and broken configurations are not the user's
+		// failures and must not use up their budget.
+		if failuresOnly && (errors.Is(err, ErrInvalidCode) || errors.Is(err, ErrCodeReused)) {
+			if rerr := a.recordFailure(ctx, cfg, store, opts.StateKey, now); rerr != nil {
+				err = errors.Join(err, rerr)
+			}
+		}
+		responder.OnError(err)
+		return Result{}, err
+	}
+	if failuresOnly && cfg.Options.RateLimitReset {
+		if err := a.clearFailures(ctx, cfg, store, opts.StateKey); err != nil {
+			responder.OnError(err)
+			return Result{}, err
+		}
+	}
+	res.ConfigChanged = cfg.Dirty != dirtyBefore || res.ConfigChanged
+	responder.OnSuccess(res)
+	return res, nil
+}
+
+func (a *Authenticator) checkRateLimit(ctx context.Context, cfg *config.Config, store StateStore, key string, failuresOnly bool, now time.Time) error {
+	rl := cfg.Options.RateLimit
+	if store == nil || rl == nil {
+		if failuresOnly {
+			return cfg.CheckRateLimit(now)
+		}
+		return cfg.EnforceRateLimit(now)
+	}
+	if failuresOnly {
+		n, err := store.CountAttempts(ctx, key, now, rl.Interval)
+		if err != nil {
+			return fmt.Errorf("state store: %w", err)
+		}
+		if n >= rl.Attempts {
+			return config.ErrRateLimited
+		}
+		return nil
+	}
+	n, err := store.RecordAttempt(ctx, key, now, rl.Interval)
+	if err != nil {
+		return fmt.Errorf("state store: %w", err)
+	}
+	if n > rl.Attempts {
+		return config.ErrRateLimited
+	}
+	return nil
+}
+
+func (a *Authenticator) recordFailure(ctx context.Context, cfg *config.Config, store StateStore, key string, now time.Time) error {
+	if store == nil || cfg.Options.RateLimit == nil {
+		cfg.RecordFailedAttempt(now)
+		return nil
+	}
+	if _, err := store.RecordAttempt(ctx, key, now, cfg.Options.RateLimit.Interval); err != nil {
+		return fmt.Errorf("state store: %w", err)
+	}
+	return nil
+}
+
+func (a *Authenticator) clearFailures(ctx context.Context, cfg *config.Config, store StateStore, key string) error {
+	if store == nil {
+		cfg.ClearRateLimit()
+		return nil
+	}
+	if err := store.ClearAttempts(ctx, key); err != nil {
+		return fmt.Errorf("state store: %w", err)
+	}
+	return nil
+}
+
+func claimCounter(ctx context.Context, store StateStore, key string, counter int64, now time.Time, ttl time.Duration) error {
+	ok, err := store.ClaimCounter(ctx, key, counter, now, ttl)
+	if err != nil {
+		return fmt.Errorf("state store: %w", err)
+	}
+	if !ok {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+func (a *Authenticator) verify(cfg *config.Config, raw string, opts VerifyOptions, now time.Time) (Result, error) {
 	token := strings.TrimSpace(raw)
 	if token == "" {
-		responder.OnError(ErrInvalidCode)
 		return Result{}, ErrInvalidCode
 	}
 	if len(token) != 6 && len(token) != 8 {
-		err := fmt.Errorf("code length must be 6 or 8 digits: %s", token)
-		responder.OnError(err)
-		return Result{}, err
+		return Result{}, invalidCode("code length must be 6 or 8 digits")
 	}
 	if strings.IndexFunc(token, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
-		responder.OnError(ErrInvalidCode)
 		return Result{}, ErrInvalidCode
 	}
 	value, _ := strconv.Atoi(token)
 	if len(token) == 8 {
 		if cfg.UseScratchCode(value) {
-			res := Result{
-				Type:          ResultScratch,
-				ConfigChanged: cfg.Dirty != dirtyBefore,
-			}
-			responder.OnSuccess(res)
-			return res, nil
+			return Result{Type: ResultScratch}, nil
 		}
-		responder.OnError(ErrInvalidCode)
 		return Result{}, ErrInvalidCode
 	}
 	secret, err := cfg.SecretBytes()
 	if err != nil {
-		responder.OnError(err)
 		return Result{}, err
 	}
 	algo := a.getAlgorithms()[cfg.Mode()]
 	if algo == nil {
-		responder.OnError(ErrModeUnknown)
 		return Result{}, ErrModeUnknown
 	}
-	res, err := algo.Verify(cfg, secret, value, opts, now)
-	if err != nil {
-		responder.OnError(err)
-		return Result{}, err
-	}
-	res.ConfigChanged = cfg.Dirty != dirtyBefore || res.ConfigChanged
-	responder.OnSuccess(res)
-	return res, nil
+	return algo.Verify(cfg, secret, value, opts, now)
 }
 
 func (a *Authenticator) getAlgorithms() map[config.Mode]Algorithm {
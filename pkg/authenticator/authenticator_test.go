@@ -3,6 +3,7 @@ package authenticator
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -99,8 +100,8 @@ func TestTOTPTimeSkewRecalibration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("expected success after skew reset: %v", err)
 	}
-	if res.Type != ResultTOTP {
-		t.Fatalf("unexpected result type: %+v", res)
+	if res.Type != ResultTOTP || !res.SkewReset {
+		t.Fatalf("unexpected result: %+v", res)
 	}
 	if cfg.Options.TimeSkew != int(skewSteps) {
 		t.Fatalf("time skew not updated, got %d", cfg.Options.TimeSkew)
@@ -112,3 +113,106 @@ func TestTOTPTimeSkewRecalibration(t *testing.T) {
 		t.Fatal("config should be marked dirty after skew update")
 	}
 }
+
+func TestRateLimitCountsEveryAttempt(t *testing.T) {
+	cfg := rateLimitedConfig(config.RateLimitAll, false)
+	now := time.Unix(1_600_000_000, 0)
+	auth := &Authenticator{Now: func() time.Time { return now }}
+	code := currentCode(t, cfg, now)
+	for i := 0; i < 2; i++ {
+		if _, err := auth.VerifyCode(cfg, code, VerifyOptions{}); err != nil {
+			t.Fatalf("attempt %d failed: %v", i+1, err)
+		}
+	}
+	if _, err := auth.VerifyCode(cfg, code, VerifyOptions{}); !errors.Is(err, config.ErrRateLimited) {
+		t.Fatalf("expected successful logins to consume the budget, got %v", err)
+	}
+}
+
+func TestRateLimitFailuresOnly(t *testing.T) {
+	cfg := rateLimitedConfig(config.RateLimitFailures, false)
+	now := time.Unix(1_600_000_000, 0)
+	auth := &Authenticator{Now: func() time.Time { return now }}
+	code := currentCode(t, cfg, now)
+	for i := 0; i < 5; i++ {
+		if _, err := auth.VerifyCode(cfg, code, VerifyOptions{}); err != nil {
+			t.Fatalf("successful attempt %d was limited: %v", i+1, err)
+		}
+	}
+	if len(cfg.Options.RateLimit.Timestamps) != 0 {
+		t.Fatalf("successes should not be recorded: %v", cfg.Options.RateLimit.Timestamps)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := auth.VerifyCode(cfg, "000000", VerifyOptions{DisableSkewAdjustment: true}); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("expected invalid code on attempt %d, got %v", i+1, err)
+		}
+	}
+	if _, err := auth.VerifyCode(cfg, code, VerifyOptions{}); !errors.Is(err, config.ErrRateLimited) {
+		t.Fatalf("expected rate limit after failures, got %v", err)
+	}
+	if !cfg.Dirty {
+		t.Fatal("config should be marked dirty after failures")
+	}
+}
+
+func TestRateLimitFailuresResetOnSuccess(t *testing.T) {
+	cfg := rateLimitedConfig(config.RateLimitFailures, true)
+	now := time.Unix(1_600_000_000, 0)
+	auth := &Authenticator{Now: func() time.Time { return now }}
+	code := currentCode(t, cfg, now)
+	if _, err := auth.VerifyCode(cfg, "000000", VerifyOptions{DisableSkewAdjustment: true}); !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("expected invalid code, got %v", err)
+	}
+	cfg.Dirty = false
+	res, err := auth.VerifyCode(cfg, code, VerifyOptions{})
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !res.ConfigChanged {
+		t.Fatal("clearing failures should report a config change")
+	}
+	if len(cfg.Options.RateLimit.Timestamps) != 0 {
+		t.Fatalf("failures not cleared after success: %v", cfg.Options.RateLimit.Timestamps)
+	}
+}
+
+func rateLimitedConfig(mode config.RateLimitMode, reset bool) *config.Config {
+	return &config.Config{
+		Secret: "JBSWY3DPEHPK3PXP",
+		Options: config.Options{
+			TOTPAuth:       true,
+			StepSize:       30,
+			WindowSize:     3,
+			RateLimit:      &config.RateLimit{Attempts: 2, Interval: 30 * time.Second},
+			RateLimitMode:  mode,
+			RateLimitReset: reset,
+			Additional:     map[string]string{},
+		},
+	}
+}
+
+func currentCode(t *testing.T, cfg *config.Config, now time.Time) string {
+	t.Helper()
+	secret, err := cfg.SecretBytes()
+	if err != nil {
+		t.Fatalf("secret decode failed: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(secret, uint64(now.Unix()/int64(cfg.Step()))))
+}
+
+func TestVerifyErrorsDoNotQuoteInput(t *testing.T) {
+	cfg := &config.Config{
+		Secret:  "JBSWY3DPEHPK3PXP",
+		Options: config.Options{TOTPAuth: true, StepSize: 30, WindowSize: 3, Additional: map[string]string{}},
+	}
+	auth := &Authenticator{Now: func() time.Time { return time.Unix(1_600_000_000, 0) }}
+	for _, input := range []string{"hunter2", "hunter2hunter2", "hunter2" + "123456"} {
+		_, err := auth.VerifyCode(cfg, input, VerifyOptions{})
+		if !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("VerifyCode(%q) = %v, want ErrInvalidCode", input, err)
+		}
+		if strings.Contains(err.Error(), "hunter2") {
+			t.Fatalf("error quotes the input: %v", err)
+		}
+	}
+}
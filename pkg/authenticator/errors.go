@@ -0,0 +1,20 @@
+package authenticator
+
+// codeError is the only error this package builds while looking at a
+// code. Verification errors end up in syslog, the structured log and the
+// PAM conversation, and the "code" may well be a mistyped password or a
+// password+code authtok, so the message describes what is wrong with the
+// input and never quotes it.
+type codeError struct {
+	reason string
+}
+
+// invalidCode returns an error matching ErrInvalidCode. reason must be a
+// constant; never format user input into it.
+func invalidCode(reason string) error {
+	return &codeError{reason: reason}
+}
+
+func (e *codeError) Error() string { return e.reason }
+
+func (e *codeError) Is(target error) bool { return target == ErrInvalidCode }
@@ -0,0 +1,88 @@
+package authenticator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrCodeReused = errors.New("verification code was already used")
+
+// StateStore keeps replay and rate-limit state outside the secret file so that
+// several hosts sharing the same secrets also share DISALLOW_REUSE and
+// RATE_LIMIT bookkeeping.
+type StateStore interface {
+	// ClaimCounter marks a TOTP counter as used for key until ttl after now.
+	// It returns false when the counter was already claimed and has not
+	// expired yet.
+	ClaimCounter(ctx context.Context, key string, counter int64, now time.Time, ttl time.Duration) (bool, error)
+	// RecordAttempt stores an attempt at the given time and returns the number
+	// of attempts, including this one, inside the window ending at at.
+	RecordAttempt(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	// CountAttempts returns the number of attempts inside the window ending at now.
+	CountAttempts(ctx context.Context, key string, now time.Time, window time.Duration) (int, error)
+	// ClearAttempts forgets all attempts recorded for key.
+	ClearAttempts(ctx context.Context, key string) error
+	Close() error
+}
+
+// OpenStateStore creates a store from a state_store specification:
+// "file:/dir" (or an absolute directory), "redis://", "rediss://" or
+// "unix://" URLs. An empty spec returns a nil store.
+func OpenStateStore(spec string) (StateStore, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "/"):
+		return NewFileStore(spec), nil
+	case strings.HasPrefix(spec, "file:"):
+		dir := strings.TrimPrefix(strings.TrimPrefix(spec, "file:"), "//")
+		if dir == "" {
+			return nil, fmt.Errorf("state store %q: missing directory", spec)
+		}
+		return NewFileStore(dir), nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parse state store: %w", errors.Unwrap(err))
+	}
+	switch u.Scheme {
+	case "redis", "rediss", "unix":
+		return NewRedisStore(u)
+	default:
+		return nil, fmt.Errorf("unsupported state store %q", RedactStateStore(spec))
+	}
+}
+
+// RedactStateStore hides credentials embedded in a state_store URL so the
+// specification can be logged.
+func RedactStateStore(spec string) string {
+	u, err := url.Parse(spec)
+	if err != nil {
+		// The parse error would quote the spec, credentials included.
+		return "<unparsable state_store>"
+	}
+	if u.Scheme == "" || u.Scheme == "file" {
+		return spec
+	}
+	if q := u.Query(); q.Has("password") {
+		q.Set("password", "xxxxx")
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+func (a *Authenticator) store(opts VerifyOptions) StateStore {
+	if a == nil || a.Store == nil || opts.StateKey == "" {
+		return nil
+	}
+	return a.Store
+}
+
+func reuseTTL(step, window int) time.Duration {
+	return time.Duration((window+1)*step) * time.Second
+}
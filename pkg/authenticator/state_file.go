@@ -0,0 +1,267 @@
+package authenticator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// FileStore keeps one flock-protected state file per key inside Dir, which is
+// typically a directory on shared storage such as NFS. Dir and the state
+// files must belong to root or to the effective user of the process, and Dir
+// must not be writable by group or others: whoever can create a file there
+// can lock another user's key or plant its state.
+//
+// A process that drops privileges, like the PAM module, calls Hold for its
+// key while still privileged; later operations reuse the open file.
+type FileStore struct {
+	Dir string
+
+	mu   sync.Mutex
+	held map[string]*os.File
+}
+
+type fileState struct {
+	used     map[int64]int64
+	attempts []int64
+}
+
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{Dir: dir}
+}
+
+func (s *FileStore) ClaimCounter(ctx context.Context, key string, counter int64, now time.Time, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.update(ctx, key, now, func(st *fileState) {
+		if expires, ok := st.used[counter]; ok && expires > now.Unix() {
+			return
+		}
+		st.used[counter] = now.Add(ttl).Unix()
+		claimed = true
+	})
+	return claimed, err
+}
+
+func (s *FileStore) RecordAttempt(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	count := 0
+	err := s.update(ctx, key, at, func(st *fileState) {
+		st.attempts = append(pruneAttempts(st.attempts, at, window), at.Unix())
+		count = len(st.attempts)
+	})
+	return count, err
+}
+
+func (s *FileStore) CountAttempts(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	count := 0
+	err := s.update(ctx, key, now, func(st *fileState) {
+		st.attempts = pruneAttempts(st.attempts, now, window)
+		count = len(st.attempts)
+	})
+	return count, err
+}
+
+func (s *FileStore) ClearAttempts(ctx context.Context, key string) error {
+	return s.update(ctx, key, time.Time{}, func(st *fileState) {
+		st.attempts = nil
+	})
+}
+
+// Hold opens the state file of key and keeps it open until Close.
+func (s *FileStore) Hold(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.held[key]; ok {
+		return nil
+	}
+	f, err := s.open(key)
+	if err != nil {
+		return err
+	}
+	if s.held == nil {
+		s.held = make(map[string]*os.File)
+	}
+	s.held[key] = f
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, f := range s.held {
+		f.Close()
+		delete(s.held, key)
+	}
+	return nil
+}
+
+// open opens the state file of key, creating Dir and the file as needed.
+func (s *FileStore) open(key string) (*os.File, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state directory %s: %w", s.Dir, err)
+	}
+	dir, err := unix.Open(s.Dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open state directory %s: %w", s.Dir, err)
+	}
+	defer unix.Close(dir)
+	if err := checkStateOwner(dir, unix.S_IFDIR); err != nil {
+		return nil, fmt.Errorf("state directory %s: %w", s.Dir, err)
+	}
+	fd, err := unix.Openat(dir, filepath.Base(path), unix.O_RDWR|unix.O_CREAT|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open state file %s: %w", path, err)
+	}
+	if err := checkStateOwner(fd, unix.S_IFREG); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("state file %s: %w", path, err)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// update runs fn on the state of key under the file lock. Claims that expired
+// by now are dropped first, unless now is zero.
+func (s *FileStore) update(ctx context.Context, key string, now time.Time, fn func(*fileState)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	f, held := s.held[key]
+	s.mu.Unlock()
+	if !held {
+		var err error
+		if f, err = s.open(key); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	path := f.Name()
+	fd := int(f.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("flock state file %s: %w", path, err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	// A held file is read again on every operation.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("read state file %s: %w", path, err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("read state file %s: %w", path, err)
+	}
+	st, err := parseFileState(data)
+	if err != nil {
+		return fmt.Errorf("parse state file %s: %w", path, err)
+	}
+	if !now.IsZero() {
+		for counter, expires := range st.used {
+			if expires <= now.Unix() {
+				delete(st.used, counter)
+			}
+		}
+	}
+	fn(st)
+	out := st.bytes()
+	if _, err := f.WriteAt(out, 0); err != nil {
+		return fmt.Errorf("write state file %s: %w", path, err)
+	}
+	if err := f.Truncate(int64(len(out))); err != nil {
+		return fmt.Errorf("truncate state file %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkStateOwner rejects anything but a file of type kind that belongs to
+// root or the effective user and that group and others cannot write.
+func checkStateOwner(fd int, kind uint32) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != kind {
+		return errors.New("unexpected file type")
+	}
+	if st.Uid != 0 && int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("owned by uid %d, want 0 or %d", st.Uid, os.Geteuid())
+	}
+	if st.Mode&0o022 != 0 {
+		return fmt.Errorf("mode %04o is writable by group or others", st.Mode&0o7777)
+	}
+	return nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, "/\x00") {
+		return "", fmt.Errorf("invalid state key %q", key)
+	}
+	return filepath.Join(s.Dir, key), nil
+}
+
+func parseFileState(data []byte) (*fileState, error) {
+	st := &fileState{used: map[int64]int64{}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "USED" && len(fields) == 3:
+			counter, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid USED counter %q", fields[1])
+			}
+			expires, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid USED expiry %q", fields[2])
+			}
+			st.used[counter] = expires
+		case fields[0] == "ATTEMPT" && len(fields) == 2:
+			ts, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ATTEMPT timestamp %q", fields[1])
+			}
+			st.attempts = append(st.attempts, ts)
+		default:
+			return nil, fmt.Errorf("unrecognized state line %q", scanner.Text())
+		}
+	}
+	return st, scanner.Err()
+}
+
+func (st *fileState) bytes() []byte {
+	var b bytes.Buffer
+	for counter, expires := range st.used {
+		fmt.Fprintf(&b, "USED %d %d\n", counter, expires)
+	}
+	for _, ts := range st.attempts {
+		fmt.Fprintf(&b, "ATTEMPT %d\n", ts)
+	}
+	return b.Bytes()
+}
+
+func pruneAttempts(attempts []int64, now time.Time, window time.Duration) []int64 {
+	start := now.Add(-window).Unix()
+	kept := attempts[:0]
+	for _, ts := range attempts {
+		if ts < start || ts > now.Unix() {
+			continue
+		}
+		kept = append(kept, ts)
+	}
+	return kept
+}
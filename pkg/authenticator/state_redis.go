@@ -0,0 +1,391 @@
+package authenticator
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisPort    = "6379"
+	defaultRedisPrefix  = "ggpam:"
+	defaultRedisTimeout = 3 * time.Second
+)
+
+// RedisStore implements StateStore on top of any server speaking the Redis
+// protocol (RESP2). Attempts live in a sorted set per key and used counters
+// in SET NX keys that expire on their own.
+type RedisStore struct {
+	network  string
+	addr     string
+	tls      *tls.Config
+	username string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// NewRedisStore builds a store from redis://[user:pass@]host[:port][/db],
+// rediss:// (TLS) or unix:///path/to/socket URLs. The query parameters
+// "db", "password", "prefix" and "timeout" are honored for every scheme.
+func NewRedisStore(u *url.URL) (*RedisStore, error) {
+	s := &RedisStore{
+		prefix:  defaultRedisPrefix,
+		timeout: defaultRedisTimeout,
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		host := u.Host
+		if host == "" {
+			return nil, fmt.Errorf("redis state store: missing host")
+		}
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), defaultRedisPort)
+		}
+		s.network, s.addr = "tcp", host
+		if u.Scheme == "rediss" {
+			s.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		}
+		if db := strings.Trim(u.Path, "/"); db != "" {
+			n, err := strconv.Atoi(db)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("redis state store: invalid database %q", db)
+			}
+			s.db = n
+		}
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("redis state store: missing socket path")
+		}
+		s.network, s.addr = "unix", u.Path
+	default:
+		return nil, fmt.Errorf("redis state store: unsupported scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		if pw, ok := u.User.Password(); ok {
+			s.password = pw
+		} else {
+			s.username, s.password = "", u.User.Username()
+		}
+	}
+	q := u.Query()
+	if v := q.Get("password"); v != "" {
+		s.password = v
+	}
+	if v := q.Get("db"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("redis state store: invalid database %q", v)
+		}
+		s.db = n
+	}
+	if v, ok := q["prefix"]; ok {
+		s.prefix = v[0]
+	}
+	if v := q.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("redis state store: invalid timeout %q", v)
+		}
+		s.timeout = d
+	}
+	return s, nil
+}
+
+// ClaimCounter lets the key expire on the server, whose clock now does not
+// affect.
+func (s *RedisStore) ClaimCounter(ctx context.Context, key string, counter int64, _ time.Time, ttl time.Duration) (bool, error) {
+	replies, err := s.do(ctx, []string{
+		"SET", s.key(key, "used:"+strconv.FormatInt(counter, 10)), "1", "NX", "PX", millis(ttl),
+	})
+	if err != nil {
+		return false, err
+	}
+	return replies[0] != nil, nil
+}
+
+func (s *RedisStore) RecordAttempt(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	member, err := randomMember(at)
+	if err != nil {
+		return 0, err
+	}
+	attempts := s.key(key, "attempts")
+	replies, err := s.do(ctx,
+		[]string{"MULTI"},
+		[]string{"ZADD", attempts, strconv.FormatInt(at.Unix(), 10), member},
+		[]string{"ZREMRANGEBYSCORE", attempts, "-inf", "(" + strconv.FormatInt(at.Add(-window).Unix(), 10)},
+		[]string{"ZCOUNT", attempts, "-inf", strconv.FormatInt(at.Unix(), 10)},
+		[]string{"PEXPIRE", attempts, millis(window)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return execInt(replies, 2)
+}
+
+func (s *RedisStore) CountAttempts(ctx context.Context, key string, now time.Time, window time.Duration) (int, error) {
+	attempts := s.key(key, "attempts")
+	replies, err := s.do(ctx,
+		[]string{"MULTI"},
+		[]string{"ZREMRANGEBYSCORE", attempts, "-inf", "(" + strconv.FormatInt(now.Add(-window).Unix(), 10)},
+		[]string{"ZCOUNT", attempts, "-inf", strconv.FormatInt(now.Unix(), 10)},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return execInt(replies, 1)
+}
+
+func (s *RedisStore) ClearAttempts(ctx context.Context, key string) error {
+	_, err := s.do(ctx, []string{"DEL", s.key(key, "attempts")})
+	return err
+}
+
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeLocked()
+}
+
+func (s *RedisStore) key(key, suffix string) string {
+	return s.prefix + key + ":" + suffix
+}
+
+// do pipelines the commands on a single connection and returns one reply
+// per command. Server-side errors inside the pipeline are returned as error.
+func (s *RedisStore) do(ctx context.Context, cmds ...[]string) ([]any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.connectLocked(ctx); err != nil {
+		return nil, err
+	}
+	replies, err := s.roundTripLocked(ctx, cmds)
+	if err != nil {
+		var rerr redisError
+		if !errors.As(err, &rerr) {
+			s.closeLocked()
+		}
+		return nil, err
+	}
+	return replies, nil
+}
+
+func (s *RedisStore) connectLocked(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: s.timeout}
+	var conn net.Conn
+	var err error
+	if s.tls != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tls}).DialContext(ctx, s.network, s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, s.network, s.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("connect redis %s: %w", s.addr, err)
+	}
+	s.conn = conn
+	s.rd = bufio.NewReader(conn)
+	var setup [][]string
+	switch {
+	case s.username != "":
+		setup = append(setup, []string{"AUTH", s.username, s.password})
+	case s.password != "":
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	if _, err := s.roundTripLocked(ctx, setup); err != nil {
+		s.closeLocked()
+		return fmt.Errorf("initialize redis connection %s: %w", s.addr, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) closeLocked() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.rd = nil, nil
+	return err
+}
+
+func (s *RedisStore) roundTripLocked(ctx context.Context, cmds [][]string) ([]any, error) {
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := s.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var buf []byte
+	for _, cmd := range cmds {
+		buf = appendCommand(buf, cmd)
+	}
+	if _, err := s.conn.Write(buf); err != nil {
+		return nil, fmt.Errorf("write redis command: %w", err)
+	}
+	replies := make([]any, len(cmds))
+	var firstErr error
+	for i := range cmds {
+		reply, err := readReply(s.rd)
+		if err != nil {
+			var rerr redisError
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return replies, nil
+}
+
+func appendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+func readReply(rd *bufio.Reader) (any, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read redis reply: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("read redis reply: empty line")
+	}
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("read redis reply: invalid integer %q", payload)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("read redis reply: invalid bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, fmt.Errorf("read redis reply: %w", err)
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("read redis reply: invalid array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		var firstErr error
+		for i := range items {
+			item, err := readReply(rd)
+			if err != nil {
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			items[i] = item
+		}
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("read redis reply: unexpected type %q", line[0])
+	}
+}
+
+// execInt extracts the integer result of the idx-th queued command from the
+// EXEC reply, which is always the last reply of a MULTI pipeline.
+func execInt(replies []any, idx int) (int, error) {
+	results, ok := replies[len(replies)-1].([]any)
+	if !ok {
+		return 0, fmt.Errorf("redis transaction aborted")
+	}
+	if idx >= len(results) {
+		return 0, fmt.Errorf("redis transaction returned %d results", len(results))
+	}
+	n, ok := results[idx].(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis transaction result %d is %T, expected integer", idx, results[idx])
+	}
+	return int(n), nil
+}
+
+func randomMember(at time.Time) (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(at.UnixNano(), 10) + "-" + hex.EncodeToString(buf[:]), nil
+}
+
+func millis(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
 	"os"
 	"sort"
 	"strconv"
@@ -22,11 +21,12 @@ const (
 )
 
 var (
-	errInvalidScratch  = errors.New("invalid scratch code line")
-	errInvalidOption   = errors.New("unrecognized config option")
-	errMissingSecret   = errors.New("missing shared secret")
-	errFileTooLarge    = errors.New("config file exceeds 64KB limit")
-	errRateLimitFormat = errors.New("RATE_LIMIT option is malformed")
+	errInvalidScratch      = errors.New("invalid scratch code line")
+	errInvalidOption       = errors.New("unrecognized config option")
+	errMissingSecret       = errors.New("missing shared secret")
+	errFileTooLarge        = errors.New("config file exceeds 64KB limit")
+	errRateLimitFormat     = errors.New("RATE_LIMIT option is malformed")
+	errRateLimitModeFormat = errors.New("RATE_LIMIT_MODE option is malformed")
 )
 
 type Mode int
@@ -37,6 +37,13 @@ const (
 	ModeHOTP
 )
 
+type RateLimitMode int
+
+const (
+	RateLimitAll RateLimitMode = iota
+	RateLimitFailures
+)
+
 type RateLimit struct {
 	Attempts   int
 	Interval   time.Duration
@@ -49,8 +56,9 @@ type SkewSample struct {
 }
 
 type LoginRecord struct {
-	Host string
-	When int64
+	Host    string `json:"host"`
+	Service string `json:"service,omitempty"`
+	When    int64  `json:"when"`
 }
 
 type Options struct {
@@ -62,9 +70,12 @@ type Options struct {
 	DisallowReuse        bool
 	DisallowedTimestamps []int64
 	RateLimit            *RateLimit
+	RateLimitMode        RateLimitMode
+	RateLimitReset       bool
 	TimeSkew             int
 	ResettingTimeSkew    []SkewSample
 	LastLogins           map[int]LoginRecord
+	Lang                 string
 	Additional           map[string]string
 }
 
@@ -199,6 +210,13 @@ func (c *Config) parseOption(payload string) error {
 			return err
 		}
 		c.Options.RateLimit = rl
+	case key == "RATE_LIMIT_MODE":
+		mode, reset, err := parseRateLimitMode(value)
+		if err != nil {
+			return err
+		}
+		c.Options.RateLimitMode = mode
+		c.Options.RateLimitReset = reset
 	case key == "DISALLOW_REUSE":
 		c.Options.DisallowReuse = true
 		if value != "" {
@@ -220,6 +238,11 @@ func (c *Config) parseOption(payload string) error {
 			return fmt.Errorf("invalid TIME_SKEW %q", value)
 		}
 		c.Options.TimeSkew = skew
+	case key == "LANG":
+		if len(strings.Fields(value)) != 1 {
+			return fmt.Errorf("invalid LANG %q", value)
+		}
+		c.Options.Lang = value
 	case key == "RESETTING_TIME_SKEW":
 		samples, err := parseSkewSamples(value)
 		if err != nil {
@@ -227,7 +250,7 @@ func (c *Config) parseOption(payload string) error {
 		}
 		c.Options.ResettingTimeSkew = samples
 	default:
-		if strings.HasPrefix(key, "LAST") && len(key) == 5 {
+		if isLastLoginKey(key) {
 			rec, idx, err := parseLastLogin(strings.TrimSpace(value), key)
 			if err != nil {
 				return err
@@ -243,12 +266,26 @@ func (c *Config) parseOption(payload string) error {
 	return nil
 }
 
+func isLastLoginKey(key string) bool {
+	if !strings.HasPrefix(key, "LAST") || len(key) < 5 || len(key) > 6 {
+		return false
+	}
+	for _, r := range key[4:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLastLogin reads "host [service=name] timestamp". The optional service
+// token is new; older readers simply fold it into the host and never match it.
 func parseLastLogin(value, key string) (LoginRecord, int, error) {
-	if len(key) != 5 || key[:4] != "LAST" {
+	if !isLastLoginKey(key) {
 		return LoginRecord{}, 0, fmt.Errorf("unknown field %s", key)
 	}
 	idx, err := strconv.Atoi(key[4:])
-	if err != nil || idx < 0 || idx > 9 {
+	if err != nil || idx < 0 || idx >= MaxLoginRecords {
 		return LoginRecord{}, 0, fmt.Errorf("invalid LAST index %s", key)
 	}
 	chunks := strings.Fields(value)
@@ -259,8 +296,14 @@ func parseLastLogin(value, key string) (LoginRecord, int, error) {
 	if err != nil {
 		return LoginRecord{}, 0, fmt.Errorf("invalid LAST timestamp: %w", err)
 	}
-	host := strings.Join(chunks[:len(chunks)-1], " ")
-	return LoginRecord{Host: host, When: when}, idx, nil
+	chunks = chunks[:len(chunks)-1]
+	service := ""
+	if last := chunks[len(chunks)-1]; len(chunks) > 1 && strings.HasPrefix(last, loginServicePrefix) {
+		service = strings.TrimPrefix(last, loginServicePrefix)
+		chunks = chunks[:len(chunks)-1]
+	}
+	host := strings.Join(chunks, " ")
+	return LoginRecord{Host: host, Service: service, When: when}, idx, nil
 }
 
 func parseSkewSamples(value string) ([]SkewSample, error) {
@@ -318,6 +361,31 @@ func parseRateLimit(value string) (*RateLimit, error) {
 	}, nil
 }
 
+// parseRateLimitMode accepts "all" or "failures", the latter optionally
+// followed by "reset" to clear recorded failures after a successful login.
+func parseRateLimitMode(value string) (RateLimitMode, bool, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return RateLimitAll, false, errRateLimitModeFormat
+	}
+	var mode RateLimitMode
+	switch fields[0] {
+	case "all":
+		mode = RateLimitAll
+	case "failures":
+		mode = RateLimitFailures
+	default:
+		return RateLimitAll, false, errRateLimitModeFormat
+	}
+	if len(fields) == 1 {
+		return mode, false, nil
+	}
+	if fields[1] != "reset" || mode != RateLimitFailures {
+		return RateLimitAll, false, errRateLimitModeFormat
+	}
+	return mode, true, nil
+}
+
 func (c *Config) Mode() Mode {
 	switch {
 	case c.Options.HOTPConfigured:
@@ -376,6 +444,12 @@ func (c *Config) Bytes() ([]byte, error) {
 		}
 		writeOpt("RATE_LIMIT", strings.Join(parts, " "))
 	}
+	switch {
+	case c.Options.RateLimitMode == RateLimitFailures && c.Options.RateLimitReset:
+		writeOpt("RATE_LIMIT_MODE", "failures reset")
+	case c.Options.RateLimitMode == RateLimitFailures:
+		writeOpt("RATE_LIMIT_MODE", "failures")
+	}
 	if c.Options.DisallowReuse {
 		var parts []string
 		for _, ts := range c.Options.DisallowedTimestamps {
@@ -393,7 +467,10 @@ func (c *Config) Bytes() ([]byte, error) {
 		}
 		writeOpt("RESETTING_TIME_SKEW", strings.Join(parts, " "))
 	}
-	for i := 0; i < 10; i++ {
+	if c.Options.Lang != "" {
+		writeOpt("LANG", c.Options.Lang)
+	}
+	for i := 0; i < MaxLoginRecords; i++ {
 		if c.Options.LastLogins == nil {
 			break
 		}
@@ -401,6 +478,10 @@ func (c *Config) Bytes() ([]byte, error) {
 		if !ok || rec.Host == "" || rec.When == 0 {
 			continue
 		}
+		if rec.Service != "" {
+			writeOpt(fmt.Sprintf("LAST%d", i), fmt.Sprintf("%s %s%s %d", rec.Host, loginServicePrefix, rec.Service, rec.When))
+			continue
+		}
 		writeOpt(fmt.Sprintf("LAST%d", i), fmt.Sprintf("%s %d", rec.Host, rec.When))
 	}
 	if len(c.Options.Additional) > 0 {
@@ -523,6 +604,80 @@ func (c *Config) EnforceRateLimit(now time.Time) error {
 	return nil
 }
 
+// FailuresOnlyRateLimit reports whether RATE_LIMIT counts failed
+// verifications only instead of every attempt.
+func (c *Config) FailuresOnlyRateLimit() bool {
+	return c.Options.RateLimit != nil && c.Options.RateLimitMode == RateLimitFailures
+}
+
+// CheckRateLimit reports ErrRateLimited when the recorded failures already
+// reach the limit, without counting the current attempt.
+func (c *Config) CheckRateLimit(now time.Time) error {
+	if c.Options.RateLimit == nil {
+		return nil
+	}
+	rl := c.Options.RateLimit
+	kept := c.recentAttempts(now)
+	if len(kept) != len(rl.Timestamps) {
+		rl.Timestamps = kept
+		c.Dirty = true
+	}
+	if len(kept) >= rl.Attempts {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// RecordFailedAttempt stores a failed verification for failures-only rate limiting.
+func (c *Config) RecordFailedAttempt(now time.Time) {
+	if c.Options.RateLimit == nil {
+		return
+	}
+	rl := c.Options.RateLimit
+	rl.Timestamps = append(rl.Timestamps, now.Unix())
+	kept := c.recentAttempts(now)
+	if len(kept) > rl.Attempts {
+		kept = kept[len(kept)-rl.Attempts:]
+	}
+	rl.Timestamps = kept
+	c.Dirty = true
+}
+
+// ClearRateLimit forgets all recorded attempts.
+func (c *Config) ClearRateLimit() {
+	if c.Options.RateLimit == nil || len(c.Options.RateLimit.Timestamps) == 0 {
+		return
+	}
+	c.Options.RateLimit.Timestamps = nil
+	c.Dirty = true
+}
+
+// ResetState forgets rate-limit attempts, used codes, skew observations and
+// login records while keeping the secret and its settings.
+func (c *Config) ResetState() {
+	if c.Options.RateLimit != nil {
+		c.Options.RateLimit.Timestamps = nil
+	}
+	c.Options.DisallowedTimestamps = nil
+	c.Options.ResettingTimeSkew = nil
+	c.Options.LastLogins = map[int]LoginRecord{}
+	c.Dirty = true
+}
+
+func (c *Config) recentAttempts(now time.Time) []int64 {
+	rl := c.Options.RateLimit
+	windowStart := now.Add(-rl.Interval).Unix()
+	sort.Slice(rl.Timestamps, func(i, j int) bool { return rl.Timestamps[i] < rl.Timestamps[j] })
+	var kept []int64
+	for _, ts := range rl.Timestamps {
+		if ts < windowStart || ts > now.Unix() {
+			continue
+		}
+		kept = append(kept, ts)
+	}
+	return kept
+}
+
 func (c *Config) CheckReuse(ts int64) error {
 	if !c.Options.DisallowReuse {
 		return nil
@@ -579,48 +734,9 @@ func (c *Config) RecordSkewObservation(ts int64, skew int) bool {
 }
 
 func (c *Config) WithinGracePeriod(host string, grace time.Duration, now time.Time) bool {
-	if grace <= 0 || host == "" || c.Options.LastLogins == nil {
-		return false
-	}
-	expire := now.Unix()
-	window := int64(grace / time.Second)
-	for _, rec := range c.Options.LastLogins {
-		if rec.Host == host && rec.When+window > expire {
-			return true
-		}
-	}
-	return false
+	return c.WithinGrace(host, GraceScope{}, grace, now)
 }
 
 func (c *Config) UpdateLoginRecord(host string, now time.Time) {
-	if host == "" {
-		return
-	}
-	if c.Options.LastLogins == nil {
-		c.Options.LastLogins = map[int]LoginRecord{}
-	}
-	for idx, rec := range c.Options.LastLogins {
-		if rec.Host == host {
-			c.Options.LastLogins[idx] = LoginRecord{Host: host, When: now.Unix()}
-			c.Dirty = true
-			return
-		}
-	}
-	for i := 0; i < 10; i++ {
-		if _, ok := c.Options.LastLogins[i]; !ok {
-			c.Options.LastLogins[i] = LoginRecord{Host: host, When: now.Unix()}
-			c.Dirty = true
-			return
-		}
-	}
-	oldestIdx := 0
-	oldestTime := int64(math.MaxInt64)
-	for idx, rec := range c.Options.LastLogins {
-		if rec.When < oldestTime {
-			oldestTime = rec.When
-			oldestIdx = idx
-		}
-	}
-	c.Options.LastLogins[oldestIdx] = LoginRecord{Host: host, When: now.Unix()}
-	c.Dirty = true
+	c.RecordLogin(host, GraceScope{}, now)
 }
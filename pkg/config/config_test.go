@@ -3,6 +3,7 @@ package config
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -121,3 +122,154 @@ func TestLoadLargeValidFile(t *testing.T) {
 		t.Fatalf("expected large file to parse, got %v", err)
 	}
 }
+
+func TestRateLimitModeRoundTrip(t *testing.T) {
+	input := sampleConfig + "\" RATE_LIMIT_MODE failures reset\n"
+	cfg, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if cfg.Options.RateLimitMode != RateLimitFailures || !cfg.Options.RateLimitReset {
+		t.Fatalf("unexpected rate limit mode: %+v", cfg.Options)
+	}
+	data, err := cfg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes error: %v", err)
+	}
+	if !strings.Contains(string(data), "\" RATE_LIMIT_MODE failures reset\n") {
+		t.Fatalf("serialized data missing rate limit mode: %s", data)
+	}
+	for _, bad := range []string{"", "sometimes", "all reset", "failures forever", "failures reset extra"} {
+		if _, err := Parse(strings.NewReader(sampleConfig + "\" RATE_LIMIT_MODE " + bad + "\n")); err == nil {
+			t.Fatalf("expected error for RATE_LIMIT_MODE %q", bad)
+		}
+	}
+}
+
+func TestLangRoundTrip(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(sampleConfig + "\" LANG de_CH.UTF-8\n"))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if cfg.Options.Lang != "de_CH.UTF-8" || len(cfg.Options.Additional) != 0 {
+		t.Fatalf("unexpected options: %+v", cfg.Options)
+	}
+	data, err := cfg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes error: %v", err)
+	}
+	if !strings.Contains(string(data), "\" LANG de_CH.UTF-8\n") {
+		t.Fatalf("serialized data missing LANG: %s", data)
+	}
+	for _, bad := range []string{"", "de fr"} {
+		if _, err := Parse(strings.NewReader(sampleConfig + "\" LANG " + bad + "\n")); err == nil {
+			t.Fatalf("expected error for LANG %q", bad)
+		}
+	}
+}
+
+func TestRateLimitFailuresOnly(t *testing.T) {
+	cfg := &Config{
+		Secret: "JBSWY3DPEHPK3PXP",
+		Options: Options{
+			RateLimit:     &RateLimit{Attempts: 2, Interval: 30 * time.Second},
+			RateLimitMode: RateLimitFailures,
+		},
+	}
+	now := time.Unix(2000, 0)
+	for i := 0; i < 5; i++ {
+		if err := cfg.CheckRateLimit(now); err != nil {
+			t.Fatalf("check without failures should pass: %v", err)
+		}
+	}
+	cfg.RecordFailedAttempt(now)
+	if err := cfg.CheckRateLimit(now); err != nil {
+		t.Fatalf("one failure should not block: %v", err)
+	}
+	cfg.RecordFailedAttempt(now.Add(time.Second))
+	if err := cfg.CheckRateLimit(now.Add(2 * time.Second)); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if err := cfg.CheckRateLimit(now.Add(40 * time.Second)); err != nil {
+		t.Fatalf("failures should expire after the interval: %v", err)
+	}
+	cfg.RecordFailedAttempt(now.Add(41 * time.Second))
+	cfg.ClearRateLimit()
+	if len(cfg.Options.RateLimit.Timestamps) != 0 {
+		t.Fatalf("failures not cleared: %v", cfg.Options.RateLimit.Timestamps)
+	}
+}
+
+func TestGraceScopeSubnetAndService(t *testing.T) {
+	cfg := &Config{Secret: "JBSWY3DPEHPK3PXP", Options: Options{Additional: map[string]string{}}}
+	now := time.Unix(2_000_000, 0)
+	ssh := GraceScope{IPv4Prefix: 24, IPv6Prefix: 64, Service: "sshd"}
+	cfg.RecordLogin("192.0.2.10", ssh, now.Add(-10*time.Second))
+	cfg.RecordLogin("2001:db8:1:2::5", ssh, now.Add(-10*time.Second))
+
+	cases := []struct {
+		host  string
+		scope GraceScope
+		want  bool
+	}{
+		{"192.0.2.10", ssh, true},
+		{"192.0.2.77", ssh, true},
+		{"::ffff:192.0.2.77", ssh, true},
+		{"192.0.3.10", ssh, false},
+		{"2001:db8:1:2::99", ssh, true},
+		{"2001:db8:1:3::5", ssh, false},
+		{"192.0.2.77", GraceScope{Service: "sshd"}, false},
+		{"192.0.2.10", GraceScope{IPv4Prefix: 24, Service: "sudo"}, false},
+		{"192.0.2.10", GraceScope{}, false},
+	}
+	for _, tc := range cases {
+		if got := cfg.WithinGrace(tc.host, tc.scope, 20*time.Second, now); got != tc.want {
+			t.Fatalf("WithinGrace(%s, %+v) = %v, want %v", tc.host, tc.scope, got, tc.want)
+		}
+	}
+
+	cfg.RecordLogin("192.0.2.99", ssh, now)
+	if len(cfg.Options.LastLogins) != 2 {
+		t.Fatalf("same subnet should refresh the existing record: %+v", cfg.Options.LastLogins)
+	}
+}
+
+func TestLoginRecordsLimitAndFormat(t *testing.T) {
+	cfg := &Config{Secret: "JBSWY3DPEHPK3PXP", Options: Options{TOTPAuth: true, StepSize: DefaultStepSize, WindowSize: DefaultWindow, Additional: map[string]string{}}}
+	now := time.Unix(2_000_000, 0)
+	scope := GraceScope{MaxRecords: 12, Service: "sshd"}
+	for i := 0; i < 15; i++ {
+		cfg.RecordLogin(fmt.Sprintf("host%d.example", i), scope, now.Add(time.Duration(i)*time.Second))
+	}
+	if len(cfg.Options.LastLogins) != 12 {
+		t.Fatalf("expected 12 records, got %d", len(cfg.Options.LastLogins))
+	}
+	if cfg.WithinGrace("host0.example", scope, time.Hour, now) {
+		t.Fatal("oldest record should have been evicted")
+	}
+	data, err := cfg.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes error: %v", err)
+	}
+	if !strings.Contains(string(data), "\" LAST11 ") || !strings.Contains(string(data), "service=sshd") {
+		t.Fatalf("unexpected serialization: %s", data)
+	}
+	parsed, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("reparse: %v", err)
+	}
+	if !parsed.WithinGrace("host14.example", scope, time.Hour, now.Add(14*time.Second)) {
+		t.Fatalf("records lost in round trip: %+v", parsed.Options.LastLogins)
+	}
+
+	legacy, err := Parse(strings.NewReader("JBSWY3DPEHPK3PXP\n\" LAST0 example.com 1999990\n\" LAST1 my host 1999990\n"))
+	if err != nil {
+		t.Fatalf("parse legacy: %v", err)
+	}
+	if !legacy.WithinGracePeriod("example.com", 20*time.Second, now) || !legacy.WithinGracePeriod("my host", 20*time.Second, now) {
+		t.Fatalf("legacy records not honored: %+v", legacy.Options.LastLogins)
+	}
+	if out, _ := legacy.Bytes(); !strings.Contains(string(out), "\" LAST0 example.com 1999990\n") {
+		t.Fatalf("legacy record format changed: %s", out)
+	}
+}
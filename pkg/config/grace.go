@@ -0,0 +1,133 @@
+package config
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLoginRecords = 10
+	MaxLoginRecords     = 100
+
+	loginServicePrefix = "service="
+)
+
+// GraceScope widens or narrows which LAST records count for grace_period.
+// The zero value keeps the original behavior: exact host match across all
+// services and at most DefaultLoginRecords records.
+type GraceScope struct {
+	// IPv4Prefix and IPv6Prefix match records within the same network of
+	// that prefix length; 0 requires the exact host.
+	IPv4Prefix int
+	IPv6Prefix int
+	// Service limits matches to records created by the same PAM service.
+	Service string
+	// MaxRecords bounds the number of LAST records kept in the file.
+	MaxRecords int
+}
+
+func (s GraceScope) maxRecords() int {
+	switch {
+	case s.MaxRecords <= 0:
+		return DefaultLoginRecords
+	case s.MaxRecords > MaxLoginRecords:
+		return MaxLoginRecords
+	default:
+		return s.MaxRecords
+	}
+}
+
+func (s GraceScope) matches(rec LoginRecord, host string) bool {
+	if rec.Service != normalizeService(s.Service) {
+		return false
+	}
+	if rec.Host == host {
+		return true
+	}
+	recAddr, err1 := netip.ParseAddr(rec.Host)
+	hostAddr, err2 := netip.ParseAddr(host)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	recAddr, hostAddr = recAddr.Unmap(), hostAddr.Unmap()
+	if recAddr.Is4() != hostAddr.Is4() {
+		return false
+	}
+	bits := s.IPv6Prefix
+	if hostAddr.Is4() {
+		bits = s.IPv4Prefix
+	}
+	if bits <= 0 || bits > hostAddr.BitLen() {
+		return false
+	}
+	prefix, err := hostAddr.WithZone("").Prefix(bits)
+	if err != nil {
+		return false
+	}
+	return prefix.Contains(recAddr.WithZone(""))
+}
+
+func (c *Config) WithinGrace(host string, scope GraceScope, grace time.Duration, now time.Time) bool {
+	if grace <= 0 || host == "" || c.Options.LastLogins == nil {
+		return false
+	}
+	expire := now.Unix()
+	window := int64(grace / time.Second)
+	for _, rec := range c.Options.LastLogins {
+		if scope.matches(rec, host) && rec.When+window > expire {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordLogin refreshes the record matching host within scope, or stores a
+// new one, evicting the oldest records beyond scope.MaxRecords.
+func (c *Config) RecordLogin(host string, scope GraceScope, now time.Time) {
+	if host == "" {
+		return
+	}
+	if c.Options.LastLogins == nil {
+		c.Options.LastLogins = map[int]LoginRecord{}
+	}
+	fresh := LoginRecord{Host: host, Service: normalizeService(scope.Service), When: now.Unix()}
+	limit := scope.maxRecords()
+	for idx, rec := range c.Options.LastLogins {
+		if scope.matches(rec, host) {
+			c.Options.LastLogins[idx] = fresh
+			c.Dirty = true
+			return
+		}
+	}
+	if len(c.Options.LastLogins) < limit {
+		for i := 0; i < limit; i++ {
+			if _, ok := c.Options.LastLogins[i]; !ok {
+				c.Options.LastLogins[i] = fresh
+				c.Dirty = true
+				return
+			}
+		}
+	}
+	records := make([]LoginRecord, 0, len(c.Options.LastLogins)+1)
+	for _, rec := range c.Options.LastLogins {
+		records = append(records, rec)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].When > records[j].When })
+	if len(records) >= limit {
+		records = records[:limit-1]
+	}
+	records = append([]LoginRecord{fresh}, records...)
+	c.Options.LastLogins = make(map[int]LoginRecord, len(records))
+	for i, rec := range records {
+		c.Options.LastLogins[i] = rec
+	}
+	c.Dirty = true
+}
+
+// normalizeService keeps service names to a single token so they survive the
+// whitespace separated LAST line format.
+func normalizeService(service string) string {
+	return strings.Join(strings.Fields(service), "_")
+}
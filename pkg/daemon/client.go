@@ -0,0 +1,99 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"ggpam/pkg/service"
+)
+
+const defaultClientTimeout = 5 * time.Second
+
+// Client sends one request per connection, which keeps it safe to use from
+// short-lived PAM conversations.
+type Client struct {
+	Socket  string
+	Timeout time.Duration
+}
+
+func NewClient(socket string) *Client {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	return &Client{Socket: socket, Timeout: defaultClientTimeout}
+}
+
+// Do sends req and returns the response. Unsuccessful responses are returned
+// as *ResponseError, which unwraps to the matching sentinel error.
+func (c *Client) Do(ctx context.Context, req Request) (Response, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = defaultClientTimeout
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return Response{}, fmt.Errorf("connect ggpamd %s: %w", c.Socket, err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return Response{}, err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return Response{}, fmt.Errorf("send ggpamd request: %w", err)
+	}
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Response{}, fmt.Errorf("read ggpamd response: %w", err)
+	}
+	if !resp.OK {
+		return resp, &ResponseError{Code: resp.Error, Message: resp.Message}
+	}
+	return resp, nil
+}
+
+// Verify checks code for username; rhost and service feed grace_period.
+func (c *Client) Verify(ctx context.Context, username, code, rhost, svc string) (string, error) {
+	resp, err := c.Do(ctx, Request{Op: OpVerify, User: username, Code: code, Rhost: rhost, Service: svc})
+	return resp.Result, err
+}
+
+// Grace reports whether the login may skip the code under grace_period.
+func (c *Client) Grace(ctx context.Context, username, rhost, svc string) (bool, error) {
+	resp, err := c.Do(ctx, Request{Op: OpGrace, User: username, Rhost: rhost, Service: svc})
+	return resp.Grace, err
+}
+
+func (c *Client) Status(ctx context.Context, username string) (service.Status, error) {
+	resp, err := c.Do(ctx, Request{Op: OpStatus, User: username})
+	if err != nil {
+		return service.Status{}, err
+	}
+	if resp.Status == nil {
+		return service.Status{}, fmt.Errorf("ggpamd: status response without status")
+	}
+	return *resp.Status, nil
+}
+
+func (c *Client) Enroll(ctx context.Context, username, issuer string, force bool) (service.Enrollment, error) {
+	resp, err := c.Do(ctx, Request{Op: OpEnroll, User: username, Issuer: issuer, Force: force})
+	if err != nil {
+		return service.Enrollment{}, err
+	}
+	if resp.Enrollment == nil {
+		return service.Enrollment{}, fmt.Errorf("ggpamd: enroll response without enrollment")
+	}
+	return *resp.Enrollment, nil
+}
+
+func (c *Client) ConfirmEnroll(ctx context.Context, username, code string) error {
+	_, err := c.Do(ctx, Request{Op: OpEnrollConfirm, User: username, Code: code})
+	return err
+}
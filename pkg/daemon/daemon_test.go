@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/otp"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+func startDaemon(t *testing.T) (*Client, *Server) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	dir := t.TempDir()
+	params, err := pamcfg.ParseParams([]string{"secret=" + filepath.Join(dir, "%u")})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	lookup := func(name string) (*user.User, error) {
+		u := *current
+		u.Username = name
+		return &u, nil
+	}
+	svc := service.New(params)
+	svc.Lookup = lookup
+	srv := &Server{Service: svc, Lookup: lookup}
+	sock := filepath.Join(dir, "ggpam.sock")
+	ln, err := Listen(sock, 0o666)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	})
+	return NewClient(sock), srv
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := (&config.Config{Secret: secret}).SecretBytes()
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(time.Now().Unix()/config.DefaultStepSize)))
+}
+
+func TestDaemonRoundTrip(t *testing.T) {
+	client, _ := startDaemon(t)
+	ctx := context.Background()
+
+	st, err := client.Status(ctx, "alice")
+	if err != nil || st.Enrolled {
+		t.Fatalf("status = %+v, err=%v", st, err)
+	}
+	if _, err := client.Verify(ctx, "alice", "123456", "", ""); !errors.Is(err, service.ErrNotEnrolled) {
+		t.Fatalf("expected ErrNotEnrolled, got %v", err)
+	}
+	enr, err := client.Enroll(ctx, "alice", "Example", false)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := client.ConfirmEnroll(ctx, "alice", currentCode(t, enr.Secret)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, err := client.Verify(ctx, "alice", "000000", "", ""); !errors.Is(err, authenticator.ErrInvalidCode) {
+		t.Fatalf("expected ErrInvalidCode, got %v", err)
+	}
+	if ok, err := client.Grace(ctx, "alice", "192.0.2.1", "sshd"); err != nil || ok {
+		t.Fatalf("grace without grace_period: ok=%v err=%v", ok, err)
+	}
+	if _, err := client.Do(ctx, Request{Op: "reboot", User: "alice"}); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected ErrBadRequest, got %v", err)
+	}
+}
+
+func TestAuthorizePeers(t *testing.T) {
+	srv := &Server{
+		AllowGIDs: map[uint32]bool{42: true},
+		Lookup: func(name string) (*user.User, error) {
+			if name == "alice" {
+				return &user.User{Username: name, Uid: "1000"}, nil
+			}
+			return nil, user.UnknownUserError(name)
+		},
+	}
+	cases := []struct {
+		cred unix.Ucred
+		user string
+		ok   bool
+	}{
+		{unix.Ucred{Uid: 0, Gid: 0}, "alice", true},
+		{unix.Ucred{Uid: 1000, Gid: 1000}, "alice", true},
+		{unix.Ucred{Uid: 1001, Gid: 1001}, "alice", false},
+		{unix.Ucred{Uid: 1001, Gid: 42}, "alice", true},
+		{unix.Ucred{Uid: 1000, Gid: 1000}, "bob", false},
+		{unix.Ucred{Uid: 0, Gid: 0}, "", false},
+	}
+	for _, tc := range cases {
+		err := srv.authorize(&tc.cred, tc.user)
+		if (err == nil) != tc.ok {
+			t.Fatalf("uid=%d gid=%d user=%q: err=%v", tc.cred.Uid, tc.cred.Gid, tc.user, err)
+		}
+	}
+}
+
+func TestForceEnrollRequiresPrivilege(t *testing.T) {
+	_, daemon := startDaemon(t)
+	srv := &Server{
+		Service: daemon.Service,
+		Lookup: func(name string) (*user.User, error) {
+			return &user.User{Username: name, Uid: "1000"}, nil
+		},
+	}
+	ctx := context.Background()
+	own := &unix.Ucred{Uid: 1000, Gid: 1000}
+
+	resp := srv.dispatch(ctx, own, Request{Op: OpEnroll, User: "alice", Force: true})
+	if resp.OK || resp.Error != CodeForbidden {
+		t.Fatalf("own-UID force enroll = %+v, want %s", resp, CodeForbidden)
+	}
+	if resp := srv.dispatch(ctx, own, Request{Op: OpEnroll, User: "alice"}); !resp.OK {
+		t.Fatalf("own-UID enroll = %+v", resp)
+	}
+	if resp := srv.dispatch(ctx, &unix.Ucred{Uid: 0, Gid: 0}, Request{Op: OpEnroll, User: "alice", Force: true}); !resp.OK {
+		t.Fatalf("root force enroll = %+v", resp)
+	}
+}
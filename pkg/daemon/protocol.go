@@ -0,0 +1,113 @@
+// Package daemon implements the ggpamd protocol: newline-delimited JSON
+// requests and responses over a Unix stream socket. Peers are identified with
+// SO_PEERCRED; privileged peers may act on any user, everyone else only on
+// the account matching their own UID.
+package daemon
+
+import (
+	"errors"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+const DefaultSocket = pamcfg.DefaultDaemonSocket
+
+// Operations understood by the daemon.
+const (
+	OpVerify        = "verify"
+	OpGrace         = "grace"
+	OpStatus        = "status"
+	OpEnroll        = "enroll"
+	OpEnrollConfirm = "enroll_confirm"
+)
+
+// Error codes carried in Response.Error.
+const (
+	CodeInvalidCode  = "invalid_code"
+	CodeReused       = "code_reused"
+	CodeRateLimited  = "rate_limited"
+	CodeNotEnrolled  = "not_enrolled"
+	CodeEnrolled     = "already_enrolled"
+	CodeNoPending    = "no_pending_enrollment"
+	CodeUnknownUser  = "unknown_user"
+	CodeForbidden    = "forbidden"
+	CodeBadRequest   = "bad_request"
+	CodeInternal     = "internal"
+	maxRequestLength = 64 << 10
+)
+
+var (
+	ErrForbidden  = errors.New("operation not permitted for this peer")
+	ErrBadRequest = errors.New("malformed request")
+)
+
+type Request struct {
+	Op      string `json:"op"`
+	User    string `json:"user"`
+	Code    string `json:"code,omitempty"`
+	Rhost   string `json:"rhost,omitempty"`
+	Service string `json:"service,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	Force   bool   `json:"force,omitempty"`
+}
+
+type Response struct {
+	OK         bool                `json:"ok"`
+	Error      string              `json:"error,omitempty"`
+	Message    string              `json:"message,omitempty"`
+	Result     string              `json:"result,omitempty"`
+	Grace      bool                `json:"grace,omitempty"`
+	Status     *service.Status     `json:"status,omitempty"`
+	Enrollment *service.Enrollment `json:"enrollment,omitempty"`
+}
+
+// errorTable maps sentinel errors to wire codes; the client maps them back
+// so callers can keep using errors.Is.
+var errorTable = []struct {
+	err  error
+	code string
+}{
+	{authenticator.ErrInvalidCode, CodeInvalidCode},
+	{authenticator.ErrCodeReused, CodeReused},
+	{config.ErrRateLimited, CodeRateLimited},
+	{service.ErrNotEnrolled, CodeNotEnrolled},
+	{service.ErrAlreadyEnrolled, CodeEnrolled},
+	{service.ErrNoPendingEnrollment, CodeNoPending},
+	{service.ErrUnknownUser, CodeUnknownUser},
+	{ErrForbidden, CodeForbidden},
+	{ErrBadRequest, CodeBadRequest},
+}
+
+func errorCode(err error) string {
+	for _, e := range errorTable {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return CodeInternal
+}
+
+// ResponseError is returned by the client for unsuccessful responses.
+type ResponseError struct {
+	Code    string
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Message == "" {
+		return "ggpamd: " + e.Code
+	}
+	return "ggpamd: " + e.Message
+}
+
+func (e *ResponseError) Unwrap() error {
+	for _, entry := range errorTable {
+		if entry.code == e.Code {
+			return entry.err
+		}
+	}
+	return nil
+}
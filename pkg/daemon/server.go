@@ -0,0 +1,227 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ggpam/pkg/logging"
+	"ggpam/pkg/service"
+)
+
+const idleTimeout = 30 * time.Second
+
+// Server answers requests with Service. Peers running as root, or with a UID
+// in AllowUIDs or a primary GID in AllowGIDs, may act on any user.
+type Server struct {
+	Service   *service.Service
+	AllowUIDs map[uint32]bool
+	AllowGIDs map[uint32]bool
+	Lookup    func(name string) (*user.User, error)
+
+	wg sync.WaitGroup
+}
+
+// Listen creates the socket at path with the given mode, replacing a stale
+// socket left behind by a previous instance.
+func Listen(path string, mode os.FileMode) (*net.UnixListener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another daemon", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket %s: %w", path, err)
+		}
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// Serve accepts connections until ctx is cancelled, then waits for the
+// requests in flight.
+func (s *Server) Serve(ctx context.Context, ln *net.UnixListener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	defer s.wg.Wait()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	cred, err := peerCred(conn)
+	if err != nil {
+		logging.Warnf("ggpamd: reject connection: %v", err)
+		return
+	}
+	// Wake up idle readers on shutdown; a request being processed still
+	// gets its response.
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestLength)
+	enc := json.NewEncoder(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp = failure(fmt.Errorf("%w: %v", ErrBadRequest, err))
+		} else {
+			resp = s.dispatch(ctx, cred, req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, cred *unix.Ucred, req Request) Response {
+	ev := logging.Event{User: req.User, Rhost: req.Rhost, Service: req.Service}
+	deny := func(err error) Response {
+		logging.Emit(logging.LevelWarn, ev.With(logging.EventAccessDenied).WithError(service.ErrorClass(err), err),
+			fmt.Sprintf("ggpamd: uid %d denied %s for %s: %v", cred.Uid, req.Op, req.User, err))
+		return failure(err)
+	}
+	if err := s.authorize(cred, req.User); err != nil {
+		return deny(err)
+	}
+	sreq := service.Request{User: req.User, Code: req.Code, Rhost: req.Rhost, Service: req.Service}
+	switch req.Op {
+	case OpVerify:
+		res, err := s.Service.Verify(ctx, sreq)
+		if err != nil {
+			logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthFailure).WithError(service.ErrorClass(err), err),
+				fmt.Sprintf("ggpamd: verify %s from uid %d failed: %v", req.User, cred.Uid, err))
+			return failure(err)
+		}
+		if res.SkewReset {
+			logging.Emit(logging.LevelInfo, ev.With(logging.EventSkewReset), fmt.Sprintf("ggpamd: time skew of %s reset", req.User))
+		}
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthSuccess).WithResult(string(res.Type)),
+			fmt.Sprintf("ggpamd: verify %s from uid %d succeeded (%s)", req.User, cred.Uid, res.Type))
+		return Response{OK: true, Result: string(res.Type)}
+	case OpGrace:
+		ok, err := s.Service.Grace(ctx, sreq)
+		if err != nil {
+			return failure(err)
+		}
+		return Response{OK: true, Grace: ok}
+	case OpStatus:
+		st, err := s.Service.Status(ctx, req.User)
+		if err != nil {
+			return failure(err)
+		}
+		return Response{OK: true, Status: &st}
+	case OpEnroll:
+		// Replacing a secret without proof of the current one would let
+		// anything running as the user take over the second factor.
+		if req.Force && !s.privileged(cred) {
+			return deny(fmt.Errorf("%w: force requires a privileged peer", ErrForbidden))
+		}
+		enr, err := s.Service.Enroll(ctx, req.User, req.Issuer, req.Force)
+		if err != nil {
+			return failure(err)
+		}
+		return Response{OK: true, Enrollment: &enr}
+	case OpEnrollConfirm:
+		if err := s.Service.ConfirmEnroll(ctx, req.User, req.Code); err != nil {
+			return failure(err)
+		}
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventEnrolled), fmt.Sprintf("ggpamd: %s enrolled by uid %d", req.User, cred.Uid))
+		return Response{OK: true}
+	default:
+		return failure(fmt.Errorf("%w: unknown op %q", ErrBadRequest, req.Op))
+	}
+}
+
+// authorize lets privileged peers act on anyone and other peers only on the
+// account with their own UID.
+func (s *Server) authorize(cred *unix.Ucred, username string) error {
+	if username == "" {
+		return fmt.Errorf("%w: missing user", ErrBadRequest)
+	}
+	if s.privileged(cred) {
+		return nil
+	}
+	lookup := s.Lookup
+	if lookup == nil {
+		lookup = user.Lookup
+	}
+	account, err := lookup(username)
+	if err != nil || account.Uid != strconv.FormatUint(uint64(cred.Uid), 10) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// privileged reports whether the peer is root or listed in AllowUIDs or
+// AllowGIDs.
+func (s *Server) privileged(cred *unix.Ucred) bool {
+	return cred.Uid == 0 || s.AllowUIDs[cred.Uid] || s.AllowGIDs[cred.Gid]
+}
+
+func failure(err error) Response {
+	return Response{Error: errorCode(err), Message: err.Error()}
+}
+
+func peerCred(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("SO_PEERCRED: %w", credErr)
+	}
+	return cred, nil
+}
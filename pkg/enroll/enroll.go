@@ -0,0 +1,132 @@
+package enroll
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/otp"
+	"ggpam/pkg/util"
+)
+
+const secretBytes = 20
+
+// Options controls the secret generated for a new enrollment.
+type Options struct {
+	HOTP          bool
+	StepSize      int
+	WindowSize    int
+	ScratchCodes  int
+	DisallowReuse bool
+	RateLimit     *config.RateLimit
+	Lang          string
+}
+
+// DefaultOptions mirrors the answers recommended by "ggpam init": TOTP with
+// the default window, reuse disallowed, 3 attempts per 30s and 5 scratch codes.
+func DefaultOptions() Options {
+	return Options{
+		StepSize:      config.DefaultStepSize,
+		WindowSize:    config.DefaultWindow,
+		ScratchCodes:  5,
+		DisallowReuse: true,
+		RateLimit:     &config.RateLimit{Attempts: 3, Interval: 30 * time.Second},
+	}
+}
+
+// OptionsFrom returns the options cfg was created with, so that a rotated
+// secret keeps the mode, window and limits of the one it replaces.
+func OptionsFrom(cfg *config.Config) Options {
+	opts := Options{
+		HOTP:          cfg.Mode() == config.ModeHOTP,
+		StepSize:      cfg.Step(),
+		WindowSize:    cfg.Window(),
+		ScratchCodes:  len(cfg.ScratchCodes),
+		DisallowReuse: cfg.Options.DisallowReuse,
+		Lang:          cfg.Options.Lang,
+	}
+	if cfg.Options.RateLimit != nil {
+		rl := *cfg.Options.RateLimit
+		opts.RateLimit = &rl
+	}
+	return opts
+}
+
+// NewConfig generates a fresh secret and scratch codes.
+func NewConfig(opts Options) (*config.Config, error) {
+	secret, err := util.RandomSecret(secretBytes)
+	if err != nil {
+		return nil, err
+	}
+	scratch, err := otp.GenerateScratchCodesDefault(opts.ScratchCodes)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config.Config{
+		Secret:       secret,
+		ScratchCodes: scratch,
+		Options: config.Options{
+			StepSize:   opts.StepSize,
+			WindowSize: opts.WindowSize,
+			Lang:       opts.Lang,
+			Additional: map[string]string{},
+			LastLogins: map[int]config.LoginRecord{},
+		},
+		Dirty: true,
+	}
+	if opts.HOTP {
+		cfg.Options.HOTPConfigured = true
+		cfg.Options.HOTPCounter = 1
+	} else {
+		cfg.Options.TOTPAuth = true
+		cfg.Options.DisallowReuse = opts.DisallowReuse
+	}
+	if opts.RateLimit != nil {
+		rl := *opts.RateLimit
+		rl.Timestamps = nil
+		cfg.Options.RateLimit = &rl
+	}
+	return cfg, nil
+}
+
+// OTPAuthURL builds the otpauth:// URL understood by authenticator apps.
+func OTPAuthURL(cfg *config.Config, label, issuer string) string {
+	if issuer == "" {
+		issuer = label
+	}
+	params := map[string]string{
+		"secret":    cfg.Secret,
+		"issuer":    issuer,
+		"digits":    "6",
+		"algorithm": "SHA1",
+	}
+	switch cfg.Mode() {
+	case config.ModeHOTP:
+		params["counter"] = fmt.Sprintf("%d", cfg.Options.HOTPCounter)
+	default:
+		params["period"] = fmt.Sprintf("%d", cfg.Step())
+	}
+	return otp.NewOTPAuthBuilder(label, issuer, params, cfg.Mode()).String()
+}
+
+// QRCodeUTF8 renders url as a block-character QR code suitable for terminals
+// that cannot be assumed to understand ANSI colors, such as PAM conversations.
+func QRCodeUTF8(url string, inverse bool) (string, error) {
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return util.QRCodeToUTF8(qr.Bitmap(), inverse), nil
+}
+
+// DefaultLabel returns "user@host" for the otpauth label.
+func DefaultLabel(username string) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unix"
+	}
+	return fmt.Sprintf("%s@%s", username, host)
+}
@@ -0,0 +1,211 @@
+// Package httpapi exposes verification, status and enrollment over a small
+// JSON REST API so that web applications can share the secrets used for SSH.
+package httpapi
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/service"
+)
+
+const maxBodyBytes = 64 << 10
+
+// Server routes API requests to Service. A request is accepted when it
+// carries one of Tokens as bearer token or, with RequireClientCert, a client
+// certificate verified by the TLS layer.
+type Server struct {
+	Service           *service.Service
+	Tokens            []string
+	RequireClientCert bool
+}
+
+type verifyRequest struct {
+	User    string `json:"user"`
+	Code    string `json:"code"`
+	Rhost   string `json:"rhost,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+type enrollRequest struct {
+	Issuer string `json:"issuer,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+	// Code confirms a pending enrollment when set.
+	Code string `json:"code,omitempty"`
+}
+
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// errorTable maps errors to HTTP status codes and stable error codes.
+var errorTable = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{authenticator.ErrInvalidCode, http.StatusUnauthorized, "invalid_code"},
+	{authenticator.ErrCodeReused, http.StatusUnauthorized, "code_reused"},
+	{config.ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+	{service.ErrUnknownUser, http.StatusNotFound, "unknown_user"},
+	{service.ErrNotEnrolled, http.StatusNotFound, "not_enrolled"},
+	{service.ErrAlreadyEnrolled, http.StatusConflict, "already_enrolled"},
+	{service.ErrNoPendingEnrollment, http.StatusConflict, "no_pending_enrollment"},
+}
+
+// Handler returns the API routes wrapped in client authentication.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/verify", s.handleVerify)
+	mux.HandleFunc("GET /v1/users/{user}/status", s.handleStatus)
+	mux.HandleFunc("POST /v1/users/{user}/enroll", s.handleEnroll)
+	return s.authenticate(mux)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ggpam"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized", Message: "missing or invalid client credentials"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.RequireClientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, want := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req verifyRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.User == "" || req.Code == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bad_request", Message: "user and code are required"})
+		return
+	}
+	ev := logging.Event{User: req.User, Rhost: req.Rhost, Service: req.Service}
+	res, err := s.Service.Verify(r.Context(), service.Request{User: req.User, Code: req.Code, Rhost: req.Rhost, Service: req.Service})
+	if err != nil {
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthFailure).WithError(service.ErrorClass(err), err),
+			fmt.Sprintf("http: verify %s from %s failed: %v", req.User, r.RemoteAddr, err))
+		writeError(w, err)
+		return
+	}
+	if res.SkewReset {
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventSkewReset), fmt.Sprintf("http: time skew of %s reset", req.User))
+	}
+	logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthSuccess).WithResult(string(res.Type)),
+		fmt.Sprintf("http: verify %s from %s succeeded (%s)", req.User, r.RemoteAddr, res.Type))
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "result": res.Type})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := s.Service.Status(r.Context(), r.PathValue("user"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("user")
+	var req enrollRequest
+	if r.ContentLength != 0 && !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Code != "" {
+		if err := s.Service.ConfirmEnroll(r.Context(), username, req.Code); err != nil {
+			writeError(w, err)
+			return
+		}
+		logging.Emit(logging.LevelInfo, logging.Event{Name: logging.EventEnrolled, User: username, Rhost: r.RemoteAddr},
+			fmt.Sprintf("http: %s enrolled via %s", username, r.RemoteAddr))
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
+		return
+	}
+	enr, err := s.Service.Enroll(r.Context(), username, req.Issuer, req.Force)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, enr)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "bad_request", Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	for _, e := range errorTable {
+		if errors.Is(err, e.err) {
+			writeJSON(w, e.status, errorResponse{Error: e.code, Message: e.err.Error()})
+			return
+		}
+	}
+	logging.Errorf("http: internal error: %v", err)
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal", Message: "internal error"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// LoadTokens reads bearer tokens from path, one per line; blank lines and
+// lines starting with # are ignored.
+func LoadTokens(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open token file %s: %w", path, err)
+	}
+	defer f.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read token file %s: %w", path, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token file %s contains no tokens", path)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/otp"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+const testToken = "s3cret-token"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	params, err := pamcfg.ParseParams([]string{"secret=" + filepath.Join(t.TempDir(), "%u")})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	svc := service.New(params)
+	svc.Lookup = func(name string) (*user.User, error) {
+		if name == "ghost" {
+			return nil, user.UnknownUserError(name)
+		}
+		u := *current
+		u.Username = name
+		return &u, nil
+	}
+	srv := httptest.NewServer((&Server{Service: svc, Tokens: []string{testToken}}).Handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func call(t *testing.T, srv *httptest.Server, method, path, token string, body any, out any) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, srv.URL+path, &payload)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decode %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+	key, err := (&config.Config{Secret: secret}).SecretBytes()
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(time.Now().Unix()/config.DefaultStepSize)))
+}
+
+func TestAPIRequiresToken(t *testing.T) {
+	srv := newTestServer(t)
+	for _, token := range []string{"", "wrong"} {
+		var e errorResponse
+		if code := call(t, srv, http.MethodGet, "/v1/users/alice/status", token, nil, &e); code != http.StatusUnauthorized || e.Error != "unauthorized" {
+			t.Fatalf("token %q: status %d, body %+v", token, code, e)
+		}
+	}
+}
+
+func TestAPIEnrollVerifyStatus(t *testing.T) {
+	srv := newTestServer(t)
+
+	var st service.Status
+	if code := call(t, srv, http.MethodGet, "/v1/users/alice/status", testToken, nil, &st); code != http.StatusOK || st.Enrolled {
+		t.Fatalf("status before enroll: %d %+v", code, st)
+	}
+	var e errorResponse
+	if code := call(t, srv, http.MethodPost, "/v1/verify", testToken, verifyRequest{User: "alice", Code: "123456"}, &e); code != http.StatusNotFound || e.Error != "not_enrolled" {
+		t.Fatalf("verify before enroll: %d %+v", code, e)
+	}
+	var enr service.Enrollment
+	if code := call(t, srv, http.MethodPost, "/v1/users/alice/enroll", testToken, enrollRequest{Issuer: "Example"}, &enr); code != http.StatusCreated || enr.Secret == "" {
+		t.Fatalf("enroll: %d %+v", code, enr)
+	}
+	if code := call(t, srv, http.MethodPost, "/v1/users/alice/enroll", testToken, enrollRequest{Code: currentCode(t, enr.Secret)}, nil); code != http.StatusOK {
+		t.Fatalf("confirm enroll: %d", code)
+	}
+	if code := call(t, srv, http.MethodPost, "/v1/users/alice/enroll", testToken, nil, &e); code != http.StatusConflict || e.Error != "already_enrolled" {
+		t.Fatalf("second enroll: %d %+v", code, e)
+	}
+
+	if code := call(t, srv, http.MethodPost, "/v1/verify", testToken, verifyRequest{User: "alice", Code: "000000"}, &e); code != http.StatusUnauthorized || e.Error != "invalid_code" {
+		t.Fatalf("verify wrong code: %d %+v", code, e)
+	}
+	var ok map[string]any
+	code := call(t, srv, http.MethodPost, "/v1/verify", testToken, verifyRequest{User: "alice", Code: fmt.Sprintf("%08d", enr.ScratchCodes[0])}, &ok)
+	if code != http.StatusOK || !reflect.DeepEqual(ok, map[string]any{"ok": true, "result": "scratch"}) {
+		t.Fatalf("verify scratch code: %d %+v", code, ok)
+	}
+	if code := call(t, srv, http.MethodGet, "/v1/users/alice/status", testToken, nil, &st); code != http.StatusOK || !st.Enrolled || st.ScratchCodes != 4 {
+		t.Fatalf("status after verify: %d %+v", code, st)
+	}
+	if code := call(t, srv, http.MethodGet, "/v1/users/ghost/status", testToken, nil, &e); code != http.StatusNotFound || e.Error != "unknown_user" {
+		t.Fatalf("unknown user: %d %+v", code, e)
+	}
+	if code := call(t, srv, http.MethodPost, "/v1/verify", testToken, map[string]string{"usr": "alice"}, &e); code != http.StatusBadRequest {
+		t.Fatalf("malformed body: %d %+v", code, e)
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# web apps\nabc\n\n  def  \n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	tokens, err := LoadTokens(path)
+	if err != nil || !reflect.DeepEqual(tokens, []string{"abc", "def"}) {
+		t.Fatalf("tokens = %v, err=%v", tokens, err)
+	}
+	if err := os.WriteFile(path, []byte("# none\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := LoadTokens(path); err == nil {
+		t.Fatal("expected error for empty token file")
+	}
+}
@@ -0,0 +1,262 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CatalogDir holds site catalogs that override or extend the built-in
+// translations; it can be changed with -ldflags -X.
+var CatalogDir = "/usr/share/ggpam/locale"
+
+// LocaleDir overrides CatalogDir.
+const LocaleDir = "GGPAM_LOCALE_DIR"
+
+// Catalogs shipped with ggpam beyond the en and zh texts in translations.
+//
+//go:embed locale/*.json
+var bundled embed.FS
+
+var (
+	catalogMu     sync.Mutex
+	catalogLoaded bool
+	catalogs      map[string]map[string]string
+)
+
+// catalog returns the merged catalogs by locale tag, loading them on first
+// use. Errors in site catalogs are ignored here; LoadCatalogs reports them.
+func catalog() map[string]map[string]string {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	if !catalogLoaded {
+		dir := os.Getenv(LocaleDir)
+		if dir == "" {
+			dir = CatalogDir
+		}
+		catalogs, _ = loadCatalogs(dir)
+		catalogLoaded = true
+	}
+	return catalogs
+}
+
+// LoadCatalogs replaces the catalogs with the built-in translations, the
+// bundled catalogs and the *.json files in dir, in increasing precedence.
+// Each file is named after its locale (de.json, zh_TW.json, pt-BR.json)
+// and holds an object mapping message keys to texts. Files that cannot be
+// read or parsed, files writable by group or others, and texts whose
+// placeholders differ from the English ones are skipped and reported in
+// the returned error.
+func LoadCatalogs(dir string) error {
+	c, err := loadCatalogs(dir)
+	catalogMu.Lock()
+	catalogs, catalogLoaded = c, true
+	catalogMu.Unlock()
+	return err
+}
+
+func loadCatalogs(dir string) (map[string]map[string]string, error) {
+	c := make(map[string]map[string]string)
+	for key, texts := range translations {
+		for lang, text := range texts {
+			addText(c, lang, key, text)
+		}
+	}
+	var errs []error
+	bundledFiles, _ := fs.Glob(bundled, "locale/*.json")
+	for _, name := range bundledFiles {
+		data, err := bundled.ReadFile(name)
+		if err == nil {
+			err = mergeCatalog(c, name, data)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if dir == "" {
+		return c, errors.Join(errs...)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return c, err
+	}
+	for _, name := range files {
+		data, err := readCatalog(name)
+		if err == nil {
+			err = mergeCatalog(c, name, data)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return c, errors.Join(errs...)
+}
+
+// readCatalog refuses files that other users could have planted texts in;
+// the PAM module shows them to every user logging in.
+func readCatalog(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0o022 != 0 {
+		return nil, fmt.Errorf("%s: not a regular file or writable by others", name)
+	}
+	return io.ReadAll(f)
+}
+
+func mergeCatalog(c map[string]map[string]string, name string, data []byte) error {
+	tag := canonicalLocale(strings.TrimSuffix(filepath.Base(name), ".json"))
+	if tag == "" {
+		return fmt.Errorf("%s: file name is not a locale", name)
+	}
+	var texts map[string]string
+	if err := json.Unmarshal(data, &texts); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	var errs []error
+	for key, text := range texts {
+		if en, ok := translations[key]["en"]; ok {
+			if err := checkPlaceholders(en, text); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", name, key, err))
+				continue
+			}
+		}
+		addText(c, tag, key, text)
+	}
+	return errors.Join(errs...)
+}
+
+func addText(c map[string]map[string]string, tag, key, text string) {
+	if text == "" {
+		return
+	}
+	if c[tag] == nil {
+		c[tag] = make(map[string]string)
+	}
+	c[tag][key] = text
+}
+
+// lookup returns the text of key in the first locale of the fallback
+// chain of locale that has one, and that locale.
+func lookup(locale, key string) (text, tag string, ok bool) {
+	c := catalog()
+	for _, tag := range fallbackChain(locale) {
+		if text, ok := c[tag][key]; ok {
+			return text, tag, true
+		}
+	}
+	return "", "", false
+}
+
+// fallbackChain lists the catalogs consulted for locale, most specific
+// first and always ending in en, e.g. zh_TW → zh_Hant → zh → en.
+func fallbackChain(locale string) []string {
+	lang, script, region := parseLocale(locale)
+	if lang == "" {
+		return []string{"en"}
+	}
+	if script == "" {
+		script = likelyScript(lang, region)
+	}
+	var chain []string
+	add := func(parts ...string) {
+		for _, p := range parts {
+			if p == "" {
+				return
+			}
+		}
+		tag := strings.Join(parts, "_")
+		for _, t := range chain {
+			if t == tag {
+				return
+			}
+		}
+		chain = append(chain, tag)
+	}
+	add(lang, script, region)
+	add(lang, region)
+	add(lang, script)
+	add(lang)
+	add("en")
+	return chain
+}
+
+// canonicalLocale returns locale as lang[_Script][_REGION], or "" for the
+// C and POSIX locales and unparsable input.
+func canonicalLocale(locale string) string {
+	lang, script, region := parseLocale(locale)
+	if lang == "" {
+		return ""
+	}
+	tag := lang
+	for _, p := range []string{script, region} {
+		if p != "" {
+			tag += "_" + p
+		}
+	}
+	return tag
+}
+
+// parseLocale splits a POSIX locale (zh_TW.UTF-8, sr_RS@latin) or a BCP 47
+// tag (zh-Hant-TW) into language, script and region.
+func parseLocale(locale string) (lang, script, region string) {
+	locale, modifier, _ := strings.Cut(strings.TrimSpace(locale), "@")
+	locale, _, _ = strings.Cut(locale, ".")
+	parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '_' || r == '-' })
+	if len(parts) == 0 || !isAlpha(parts[0]) || len(parts[0]) < 2 || len(parts[0]) > 3 {
+		return "", "", ""
+	}
+	lang = strings.ToLower(parts[0])
+	for _, p := range parts[1:] {
+		switch {
+		case len(p) == 4 && isAlpha(p) && script == "":
+			script = strings.ToUpper(p[:1]) + strings.ToLower(p[1:])
+		case (len(p) == 2 && isAlpha(p) || len(p) == 3 && isDigits(p)) && region == "":
+			region = strings.ToUpper(p)
+		}
+	}
+	if script == "" {
+		script = modifierScripts[strings.ToLower(modifier)]
+	}
+	return lang, script, region
+}
+
+var modifierScripts = map[string]string{
+	"latin":    "Latn",
+	"cyrillic": "Cyrl",
+}
+
+// likelyScript fills in the script where a region implies it.
+func likelyScript(lang, region string) string {
+	if lang != "zh" {
+		return ""
+	}
+	switch region {
+	case "TW", "HK", "MO":
+		return "Hant"
+	case "CN", "SG":
+		return "Hans"
+	}
+	return ""
+}
+
+func isAlpha(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') }) < 0
+}
+
+func isDigits(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) < 0
+}
@@ -0,0 +1,152 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFallbackChain(t *testing.T) {
+	cases := map[string][]string{
+		"zh_TW.UTF-8": {"zh_Hant_TW", "zh_TW", "zh_Hant", "zh", "en"},
+		"zh-Hant":     {"zh_Hant", "zh", "en"},
+		"zh_CN.UTF-8": {"zh_Hans_CN", "zh_CN", "zh_Hans", "zh", "en"},
+		"de_AT@euro":  {"de_AT", "de", "en"},
+		"sr_RS@latin": {"sr_Latn_RS", "sr_RS", "sr_Latn", "sr", "en"},
+		"es-419":      {"es_419", "es", "en"},
+		"en_US":       {"en_US", "en"},
+		"ja":          {"ja", "en"},
+		"C.UTF-8":     {"en"},
+		"POSIX":       {"en"},
+		"":            {"en"},
+	}
+	for locale, want := range cases {
+		if got := fallbackChain(locale); !slices.Equal(got, want) {
+			t.Errorf("fallbackChain(%q) = %v, want %v", locale, got, want)
+		}
+	}
+}
+
+func TestDetectLang(t *testing.T) {
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "fr_CA.UTF-8")
+	t.Setenv("LANG", "de_DE.UTF-8")
+	if got := DetectLang(); got != "fr_CA" {
+		t.Fatalf("DetectLang = %q, want fr_CA", got)
+	}
+	t.Setenv("LC_ALL", "C")
+	if got := DetectLang(); got != "en" {
+		t.Fatalf("DetectLang with LC_ALL=C = %q, want en", got)
+	}
+}
+
+func TestLocalizerIgnoresEnvironment(t *testing.T) {
+	t.Setenv("LC_ALL", "zh_CN.UTF-8")
+	loc := NewLocalizer("de_CH.UTF-8")
+	if loc.Locale() != "de_CH" || loc.Resolve(MsgInternalError) != "Interner Fehler" {
+		t.Fatalf("de_CH localizer: %q resolves to %q", loc.Locale(), loc.Resolve(MsgInternalError))
+	}
+	if got := NewLocalizer("C").Msgf(MsgCliScratchRange, 10); got != "emergency-codes must be in 0..10" {
+		t.Fatalf("C localizer: %q", got)
+	}
+	env := map[string]string{"LC_MESSAGES": "fr_FR", "LANG": "de_DE"}
+	if got, ok := EnvLocale(func(k string) string { return env[k] }); !ok || got != "fr_FR" {
+		t.Fatalf("EnvLocale = %q, %v", got, ok)
+	}
+	if _, ok := EnvLocale(func(string) string { return "" }); ok {
+		t.Fatal("EnvLocale of an empty environment is set")
+	}
+	for locale, want := range map[string]bool{"ja": true, "pt-BR": true, "C": false, "POSIX": false, "": false, "1234": false} {
+		if ValidLocale(locale) != want {
+			t.Errorf("ValidLocale(%q) = %v", locale, !want)
+		}
+	}
+}
+
+func TestResolveFallsBack(t *testing.T) {
+	t.Setenv("LC_ALL", "zh_TW.UTF-8")
+	if got := Resolve(MsgInternalError); got != translations[MsgInternalError]["zh"] {
+		t.Fatalf("zh_TW resolved to %q", got)
+	}
+	t.Setenv("LC_ALL", "de_CH.UTF-8")
+	if got := Resolve(MsgInternalError); got != "Interner Fehler" {
+		t.Fatalf("de_CH resolved to %q", got)
+	}
+	// Keys without a German text fall back to English.
+	if got := Resolve(MsgCmdAuditShort); got != translations[MsgCmdAuditShort]["en"] {
+		t.Fatalf("untranslated key resolved to %q", got)
+	}
+	if got := Resolve("noSuchKey"); got != "noSuchKey" {
+		t.Fatalf("unknown key resolved to %q", got)
+	}
+}
+
+func TestSiteCatalogs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, mode os.FileMode) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("zh-Hant.json", `{"internalError": "內部錯誤"}`, 0o644)
+	write("de.json", `{"internalError": "Interner Fehler (Site)"}`, 0o644)
+	write("fr.json", `{"internalError": "planted"}`, 0o666)
+	write("it.json", `{"internalError": `, 0o644)
+	write("ja.json", `{"internalError": "内部エラー (Site)", "scratchLow": "残り %d 個"}`, 0o644)
+	write("README.txt", "ignored", 0o644)
+	t.Cleanup(func() { LoadCatalogs("") })
+
+	err := LoadCatalogs(dir)
+	if err == nil || !strings.Contains(err.Error(), "fr.json") || !strings.Contains(err.Error(), "it.json") || !strings.Contains(err.Error(), "ja.json: scratchLow") {
+		t.Fatalf("LoadCatalogs error = %v, want fr.json, it.json and ja.json reported", err)
+	}
+	// The mismatched text is dropped, the rest of the file is used.
+	ja := NewLocalizer("ja")
+	if ja.Resolve(MsgInternalError) != "内部エラー (Site)" || strings.Contains(ja.Resolve(MsgScratchLow), "%d") {
+		t.Errorf("ja site catalog: %q, %q", ja.Resolve(MsgInternalError), ja.Resolve(MsgScratchLow))
+	}
+	for locale, want := range map[string]string{
+		"zh_TW": "內部錯誤",
+		"zh_HK": "內部錯誤",
+		"zh_CN": translations[MsgInternalError]["zh"],
+		"de_DE": "Interner Fehler (Site)",
+		"fr_FR": "Erreur interne",
+		"it_IT": translations[MsgInternalError]["en"],
+	} {
+		t.Setenv("LC_ALL", locale)
+		if got := Resolve(MsgInternalError); got != want {
+			t.Errorf("%s: %q, want %q", locale, got, want)
+		}
+	}
+}
+
+func TestBundledCatalogs(t *testing.T) {
+	files, err := bundled.ReadDir("locale")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no bundled catalogs: %v", err)
+	}
+	c, err := loadCatalogs("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		tag := strings.TrimSuffix(f.Name(), ".json")
+		for key, text := range c[tag] {
+			en, ok := translations[key]["en"]
+			if !ok {
+				t.Errorf("%s: unknown key %q", f.Name(), key)
+				continue
+			}
+			if err := checkPlaceholders(en, text); err != nil {
+				t.Errorf("%s: %s: %v", f.Name(), key, err)
+			}
+		}
+	}
+}
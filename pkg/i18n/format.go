@@ -0,0 +1,372 @@
+package i18n
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Args are the parameters of a message in the named format.
+//
+// Besides the fmt verbs of Msgf, a text may use a subset of ICU
+// MessageFormat: {name} inserts a parameter and
+//
+//	{count, plural, =0 {no codes} one {# code} other {# codes}}
+//
+// picks a branch by an exact value or by the CLDR plural category of count
+// in the language of the text, with # standing for the number. There is no
+// quoting: a text with braces is always in the named format.
+type Args map[string]any
+
+type nodeKind int
+
+const (
+	nodeText nodeKind = iota
+	nodeArg
+	nodePlural
+	nodeNumber
+)
+
+type node struct {
+	kind     nodeKind
+	text     string
+	name     string
+	branches map[string][]node
+}
+
+var pluralCategories = []string{"zero", "one", "two", "few", "many", "other"}
+
+// parseMessage parses a text in the named format.
+func parseMessage(text string) ([]node, error) {
+	p := &messageParser{src: text}
+	nodes, err := p.message(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected }")
+	}
+	return nodes, nil
+}
+
+type messageParser struct {
+	src string
+	pos int
+}
+
+func (p *messageParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// message reads up to an unmatched } or the end of the text; inPlural makes
+// # the number of the enclosing plural.
+func (p *messageParser) message(inPlural bool) ([]node, error) {
+	var nodes []node
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, node{kind: nodeText, text: text.String()})
+			text.Reset()
+		}
+	}
+	for p.pos < len(p.src) {
+		switch c := p.src[p.pos]; {
+		case c == '}':
+			flush()
+			return nodes, nil
+		case c == '{':
+			flush()
+			p.pos++
+			n, err := p.argument()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, n)
+		case c == '#' && inPlural:
+			flush()
+			p.pos++
+			nodes = append(nodes, node{kind: nodeNumber})
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	return nodes, nil
+}
+
+// argument reads what follows a {.
+func (p *messageParser) argument() (node, error) {
+	name := p.word()
+	if name == "" {
+		return node{}, p.errorf("missing parameter name")
+	}
+	p.space()
+	if p.consume('}') {
+		return node{kind: nodeArg, name: name}, nil
+	}
+	if !p.consume(',') {
+		return node{}, p.errorf("expected , or } after %s", name)
+	}
+	p.space()
+	if kind := p.word(); kind != "plural" {
+		return node{}, p.errorf("unsupported argument type %q", kind)
+	}
+	p.space()
+	if !p.consume(',') {
+		return node{}, p.errorf("expected , after plural")
+	}
+	n := node{kind: nodePlural, name: name, branches: make(map[string][]node)}
+	for {
+		p.space()
+		if p.consume('}') {
+			break
+		}
+		selector := p.word()
+		if !slices.Contains(pluralCategories, selector) {
+			if _, err := strconv.ParseUint(strings.TrimPrefix(selector, "="), 10, 64); err != nil || !strings.HasPrefix(selector, "=") {
+				return node{}, p.errorf("invalid plural selector %q", selector)
+			}
+		}
+		if _, dup := n.branches[selector]; dup {
+			return node{}, p.errorf("duplicate plural selector %q", selector)
+		}
+		p.space()
+		if !p.consume('{') {
+			return node{}, p.errorf("expected { after %s", selector)
+		}
+		branch, err := p.message(true)
+		if err != nil {
+			return node{}, err
+		}
+		if !p.consume('}') {
+			return node{}, p.errorf("unterminated %s branch", selector)
+		}
+		n.branches[selector] = branch
+	}
+	if _, ok := n.branches["other"]; !ok {
+		return node{}, p.errorf("plural %s lacks an other branch", name)
+	}
+	return n, nil
+}
+
+func (p *messageParser) word() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c != '_' && c != '=' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *messageParser) space() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *messageParser) consume(c byte) bool {
+	if p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// render writes nodes with args, choosing plural branches by the rules of
+// the language of tag. number is what # stands for.
+func render(b *strings.Builder, nodes []node, tag string, args Args, number string) {
+	for _, n := range nodes {
+		switch n.kind {
+		case nodeText:
+			b.WriteString(n.text)
+		case nodeNumber:
+			b.WriteString(number)
+		case nodeArg:
+			if v, ok := args[n.name]; ok {
+				fmt.Fprint(b, v)
+			} else {
+				b.WriteString("{" + n.name + "}")
+			}
+		case nodePlural:
+			v, ok := args[n.name]
+			if !ok {
+				b.WriteString("{" + n.name + "}")
+				continue
+			}
+			branch := n.branches["other"]
+			if i, isInt := integer(v); isInt {
+				if exact, ok := n.branches["="+strconv.FormatUint(i, 10)]; ok {
+					branch = exact
+				} else if cat, ok := n.branches[pluralCategory(tag, i)]; ok {
+					branch = cat
+				}
+			}
+			render(b, branch, tag, args, fmt.Sprint(v))
+		}
+	}
+}
+
+// integer returns the absolute value of an integer argument.
+func integer(v any) (uint64, bool) {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int8:
+		n = int64(v)
+	case int16:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	default:
+		return 0, false
+	}
+	if n < 0 {
+		return uint64(-n), true
+	}
+	return uint64(n), true
+}
+
+// pluralCategory returns the CLDR cardinal plural category of the integer
+// n in the language of tag. Languages without a rule of their own here
+// follow English.
+func pluralCategory(tag string, n uint64) string {
+	lang, _, region := parseLocale(tag)
+	mod10, mod100 := n%10, n%100
+	switch lang {
+	case "ja", "zh", "ko", "vi", "th", "id", "ms", "lo", "km", "my":
+		return "other"
+	case "fr", "pt", "es", "it", "ca":
+		switch {
+		case n == 1, n == 0 && (lang == "fr" || lang == "pt" && region != "PT"):
+			return "one"
+		case n != 0 && n%1000000 == 0:
+			return "many"
+		}
+		return "other"
+	case "ru", "uk", "be":
+		switch {
+		case mod10 == 1 && mod100 != 11:
+			return "one"
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return "few"
+		}
+		return "many"
+	case "pl":
+		switch {
+		case n == 1:
+			return "one"
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return "few"
+		}
+		return "many"
+	case "cs", "sk":
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+		return "other"
+	case "he":
+		switch n {
+		case 1:
+			return "one"
+		case 2:
+			return "two"
+		}
+		return "other"
+	case "ar":
+		switch {
+		case n <= 2:
+			return []string{"zero", "one", "two"}[n]
+		case mod100 >= 3 && mod100 <= 10:
+			return "few"
+		case mod100 >= 11:
+			return "many"
+		}
+		return "other"
+	}
+	if n == 1 {
+		return "one"
+	}
+	return "other"
+}
+
+var verbPattern = regexp.MustCompile(`%[-+# 0]*(?:\[([0-9]+)\])?[0-9]*(?:\.[0-9]+)?([a-zA-Z%])`)
+
+// signature lists the parameters text expects: the sorted names of a text
+// in the named format, or the fmt verbs of any other text by argument
+// index, so that "%[2]d %[1]s" matches "%s %d".
+// Translations must have the signature of the English text.
+func signature(text string) ([]string, error) {
+	if !strings.ContainsAny(text, "{}") {
+		var verbs []string
+		next := 1
+		for _, m := range verbPattern.FindAllStringSubmatch(text, -1) {
+			if m[2] == "%" {
+				continue
+			}
+			if m[1] != "" {
+				next, _ = strconv.Atoi(m[1])
+			}
+			verbs = append(verbs, fmt.Sprintf("%%[%d]%s", next, m[2]))
+			next++
+		}
+		slices.Sort(verbs)
+		return slices.Compact(verbs), nil
+	}
+	nodes, err := parseMessage(text)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var walk func([]node)
+	walk = func(nodes []node) {
+		for _, n := range nodes {
+			if n.kind == nodeArg || n.kind == nodePlural {
+				names = append(names, "{"+n.name+"}")
+			}
+			for _, branch := range n.branches {
+				walk(branch)
+			}
+		}
+	}
+	walk(nodes)
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// checkPlaceholders reports whether text can stand in for the English en.
+func checkPlaceholders(en, text string) error {
+	want, err := signature(en)
+	if err != nil {
+		return err
+	}
+	got, err := signature(text)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(got, want) {
+		return fmt.Errorf("placeholders %v, English has %v", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package i18n
+
+import (
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, tag, text string, args Args) string {
+	t.Helper()
+	nodes, err := parseMessage(text)
+	if err != nil {
+		t.Fatalf("parse %q: %v", text, err)
+	}
+	var b strings.Builder
+	render(&b, nodes, tag, args, "#")
+	return b.String()
+}
+
+func TestFormatPlural(t *testing.T) {
+	const codes = "{n, plural, =0 {no codes} one {# code} other {# codes}} for {user}, #1"
+	for _, tc := range []struct {
+		tag  string
+		n    any
+		want string
+	}{
+		{"en", 0, "no codes for alice, #1"},
+		{"en", 1, "1 code for alice, #1"},
+		{"en", 2, "2 codes for alice, #1"},
+		{"en", int64(-1), "-1 code for alice, #1"},
+		{"en", 1.5, "1.5 codes for alice, #1"},
+		{"ja", 1, "1 codes for alice, #1"},
+	} {
+		if got := format(t, tc.tag, codes, Args{"n": tc.n, "user": "alice"}); got != tc.want {
+			t.Errorf("%s %v: %q, want %q", tc.tag, tc.n, got, tc.want)
+		}
+	}
+
+	const files = "{n, plural, one {# fichier} many {# de fichiers} other {# fichiers}}"
+	for n, want := range map[int]string{0: "0 fichier", 1: "1 fichier", 2: "2 fichiers", 1000000: "1000000 de fichiers"} {
+		if got := format(t, "fr_CA", files, Args{"n": n}); got != want {
+			t.Errorf("fr %d: %q, want %q", n, got, want)
+		}
+	}
+
+	if got := format(t, "en", "{user} has {n, plural, other {# codes}}", nil); got != "{user} has {n}" {
+		t.Errorf("missing arguments: %q", got)
+	}
+}
+
+func TestPluralCategory(t *testing.T) {
+	for _, tc := range []struct {
+		tag  string
+		want map[uint64]string
+	}{
+		{"en", map[uint64]string{0: "other", 1: "one", 2: "other", 11: "other"}},
+		{"de_CH", map[uint64]string{1: "one", 21: "other"}},
+		{"zh_Hant_TW", map[uint64]string{1: "other"}},
+		{"fr", map[uint64]string{0: "one", 1: "one", 2: "other", 2000000: "many"}},
+		{"pt_BR", map[uint64]string{0: "one", 1: "one"}},
+		{"pt_PT", map[uint64]string{0: "other", 1: "one"}},
+		{"ru", map[uint64]string{1: "one", 21: "one", 11: "many", 3: "few", 13: "many", 24: "few", 5: "many"}},
+		{"pl", map[uint64]string{1: "one", 21: "many", 22: "few", 12: "many"}},
+		{"cs", map[uint64]string{1: "one", 3: "few", 5: "other"}},
+		{"ar", map[uint64]string{0: "zero", 1: "one", 2: "two", 3: "few", 11: "many", 100: "other", 102: "other", 103: "few"}},
+	} {
+		for n, want := range tc.want {
+			if got := pluralCategory(tc.tag, n); got != want {
+				t.Errorf("%s %d: %s, want %s", tc.tag, n, got, want)
+			}
+		}
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	for _, text := range []string{
+		"{",
+		"}",
+		"{}",
+		"{n",
+		"{n, select, a {x}}",
+		"{n, plural, one {x}}",
+		"{n, plural, other {x} other {y}}",
+		"{n, plural, several {x} other {y}}",
+		"{n, plural, =x {x} other {y}}",
+		"{n, plural, other {x}",
+		"{n, plural, other x}",
+	} {
+		if _, err := parseMessage(text); err == nil {
+			t.Errorf("parseMessage(%q) succeeded", text)
+		}
+	}
+}
+
+func TestCheckPlaceholders(t *testing.T) {
+	for _, tc := range []struct {
+		en, text string
+		ok       bool
+	}{
+		{"%s has %d", "%s hat %d", true},
+		{"%s has %d", "%d hat %s", false},
+		{"%s has %d", "%s hat", false},
+		{"%d per %ds", "每 %[2]d 秒 %[1]d 次", true},
+		{"%s has %d", "%[2]s hat %[1]d", false},
+		{"100%% of %s", "%s à 100 %%", true},
+		{"{user} has {n, plural, one {# code} other {# codes}}", "{n} 个码属于 {user}", true},
+		{"{user} has {n}", "{user} hat {m}", false},
+		{"{user} has {n}", "%s hat %d", false},
+		{"{user} has {n}", "{user} hat {n", false},
+	} {
+		if err := checkPlaceholders(tc.en, tc.text); (err == nil) != tc.ok {
+			t.Errorf("checkPlaceholders(%q, %q) = %v", tc.en, tc.text, err)
+		}
+	}
+}
+
+func TestFormatUsesLanguageOfText(t *testing.T) {
+	// Czech falls back to English, whose rules pick the branch.
+	if got := NewLocalizer("cs").Format(MsgMaxTries, Args{"user": "alice", "count": 3}); got != "User alice failed verification 3 times, giving up" {
+		t.Fatalf("cs fallback: %q", got)
+	}
+	if got := NewLocalizer("en").Format(MsgMaxTries, Args{"user": "alice", "count": 1}); got != "User alice failed verification once, giving up" {
+		t.Fatalf("en: %q", got)
+	}
+	if got := NewLocalizer("fr").Format(MsgScratchLow, Args{"count": 0}); !strings.HasPrefix(got, "Attention : plus aucun code") {
+		t.Fatalf("fr: %q", got)
+	}
+}
@@ -0,0 +1,81 @@
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Localizer resolves messages for one locale. Unlike Resolve and Msgf it
+// does not consult the environment of the process, so a PAM module can
+// answer each user in their own language.
+type Localizer struct {
+	tag string
+}
+
+// NewLocalizer returns a Localizer for a POSIX locale (de_DE.UTF-8) or a
+// BCP 47 tag (zh-Hant-TW). Empty, C/POSIX and unparsable locales resolve
+// to English.
+func NewLocalizer(locale string) Localizer {
+	return Localizer{tag: canonicalLocale(locale)}
+}
+
+// Locale returns the canonical tag of l, such as "zh_TW", or "en".
+func (l Localizer) Locale() string {
+	if l.tag == "" {
+		return "en"
+	}
+	return l.tag
+}
+
+// Resolve returns the translation of key, walking the locale fallback chain
+// down to en, or key itself.
+func (l Localizer) Resolve(key string) string {
+	if text, _, ok := lookup(l.tag, key); ok {
+		return text
+	}
+	return key
+}
+
+// Msgf returns the translation of key formatted with fmt.Sprintf.
+func (l Localizer) Msgf(key string, args ...any) string {
+	format := l.Resolve(key)
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Format returns the translation of key, a text in the named format, with
+// its parameters taken from args. Plural branches follow the rules of the
+// language the text was found in, which is English when the locale of l
+// lacks the key.
+func (l Localizer) Format(key string, args Args) string {
+	text, tag, ok := lookup(l.tag, key)
+	if !ok {
+		return key
+	}
+	nodes, err := parseMessage(text)
+	if err != nil {
+		return text
+	}
+	var b strings.Builder
+	render(&b, nodes, tag, args, "#")
+	return b.String()
+}
+
+// EnvLocale returns the first of LC_ALL, LC_MESSAGES and LANG that getenv
+// reports as set, the order in which setlocale consults them for messages.
+func EnvLocale(getenv func(string) string) (string, bool) {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := getenv(env); v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// ValidLocale reports whether locale names a language, as opposed to the
+// C/POSIX locales and input that is not a locale at all.
+func ValidLocale(locale string) bool {
+	return canonicalLocale(locale) != ""
+}
@@ -1,10 +1,6 @@
 package i18n
 
-import (
-	"fmt"
-	"os"
-	"strings"
-)
+import "os"
 
 const (
 	// PAM 相关
@@ -22,6 +18,8 @@ const (
 	MsgInternalError              = "internalError"
 	MsgUpdateAuthtokFailed        = "updateAuthtokFailed"
 	MsgUserAuthSuccess            = "userAuthSuccess"
+	MsgSkewReset                  = "skewReset"
+	MsgAuditOpenFailed            = "auditOpenFailed"
 	MsgEmptyUsername              = "emptyUsername"
 	MsgSerializeConfigFailed      = "serializeConfigFailed"
 	MsgSecretChangedDuringProcess = "secretChangedDuringProcess"
@@ -31,6 +29,32 @@ const (
 	MsgUpdateConfigFailed         = "updateConfigFailed"
 	MsgPromptTooLarge             = "promptTooLarge"
 	MsgDummyPassword              = "dummyPassword"
+	MsgStateStoreFailed           = "stateStoreFailed"
+	MsgUserNoSecretEnroll         = "userNoSecretEnroll"
+	MsgEnrollStateFailed          = "enrollStateFailed"
+	MsgEnrollPending              = "enrollPending"
+	MsgEnrollExpired              = "enrollExpired"
+	MsgEnrollReminder             = "enrollReminder"
+	MsgEnrollDeadlinePassed       = "enrollDeadlinePassed"
+	MsgEnrollOnLoginIntro         = "enrollOnLoginIntro"
+	MsgEnrollConfirmPrompt        = "enrollConfirmPrompt"
+	MsgEnrollCodeIncorrect        = "enrollCodeIncorrect"
+	MsgEnrollCompleted            = "enrollCompleted"
+	MsgEnrollAborted              = "enrollAborted"
+	MsgUserExempt                 = "userExempt"
+	MsgExemptionCheckFailed       = "exemptionCheckFailed"
+	MsgTrustedNetworkSkip         = "trustedNetworkSkip"
+	MsgNetworkCheckFailed         = "networkCheckFailed"
+	MsgDaemonFailed               = "daemonFailed"
+	MsgDaemonEnrollUnsupported    = "daemonEnrollUnsupported"
+	MsgChauthtokIntro             = "chauthtokIntro"
+	MsgChauthtokCurrentPrompt     = "chauthtokCurrentPrompt"
+	MsgChauthtokNoSecret          = "chauthtokNoSecret"
+	MsgChauthtokCompleted         = "chauthtokCompleted"
+	MsgChauthtokAborted           = "chauthtokAborted"
+	MsgPutEnvFailed               = "putEnvFailed"
+	MsgScratchLow                 = "scratchLow"
+	MsgMaxTries                   = "maxTries"
 
 	// CLI 相关
 	MsgCliDisallowReusePrompt   = "cliDisallowReusePrompt"
@@ -44,6 +68,7 @@ const (
 	MsgCliStepRange             = "cliStepRange"
 	MsgCliWindowRange           = "cliWindowRange"
 	MsgCliScratchRange          = "cliScratchRange"
+	MsgCliInvalidLang           = "cliInvalidLang"
 	MsgCliRateArgsMismatch      = "cliRateArgsMismatch"
 	MsgCliRateLimitRange        = "cliRateLimitRange"
 	MsgCliRateTimePositive      = "cliRateTimePositive"
@@ -91,6 +116,7 @@ const (
 	MsgCliFlagAllowReuse        = "cliFlagAllowReuse"
 	MsgCliFlagLabel             = "cliFlagLabel"
 	MsgCliFlagIssuer            = "cliFlagIssuer"
+	MsgCliFlagLang              = "cliFlagLang"
 	MsgCliFlagQuiet             = "cliFlagQuiet"
 	MsgCliFlagQRMode            = "cliFlagQRMode"
 	MsgCliFlagQRInverse         = "cliFlagQRInverse"
@@ -106,6 +132,51 @@ const (
 	MsgCliVerifyScratchUsed     = "cliVerifyScratchUsed"
 	MsgCliVerifyHOTPSuccess     = "cliVerifyHOTPSuccess"
 	MsgCliVerifyTOTPSuccess     = "cliVerifyTOTPSuccess"
+	MsgCmdAdminShort            = "cmdAdminShort"
+	MsgCmdDaemonShort           = "cmdDaemonShort"
+	MsgCmdServeShort            = "cmdServeShort"
+	MsgCliFlagServeListen       = "cliFlagServeListen"
+	MsgCliFlagServeTokenFile    = "cliFlagServeTokenFile"
+	MsgCliFlagServeTLSCert      = "cliFlagServeTLSCert"
+	MsgCliFlagServeTLSKey       = "cliFlagServeTLSKey"
+	MsgCliFlagServeClientCA     = "cliFlagServeClientCA"
+	MsgCliServeNeedAuth         = "cliServeNeedAuth"
+	MsgCliServeTLSArgs          = "cliServeTLSArgs"
+	MsgCmdRadiusShort           = "cmdRadiusShort"
+	MsgCliFlagRadiusListen      = "cliFlagRadiusListen"
+	MsgCliFlagRadiusSecretFile  = "cliFlagRadiusSecretFile"
+	MsgCliFlagRadiusChallenge   = "cliFlagRadiusChallenge"
+	MsgCliFlagRadiusIgnorePass  = "cliFlagRadiusIgnorePassword"
+	MsgCliRadiusNeedSecret      = "cliRadiusNeedSecret"
+	MsgCliFlagDaemonSocket      = "cliFlagDaemonSocket"
+	MsgCliFlagDaemonSocketMode  = "cliFlagDaemonSocketMode"
+	MsgCliFlagDaemonAllowUser   = "cliFlagDaemonAllowUser"
+	MsgCliFlagDaemonAllowGroup  = "cliFlagDaemonAllowGroup"
+	MsgCmdAdminInitShort        = "cmdAdminInitShort"
+	MsgCmdAdminShowShort        = "cmdAdminShowShort"
+	MsgCmdAdminListShort        = "cmdAdminListShort"
+	MsgCmdAdminRemoveShort      = "cmdAdminRemoveShort"
+	MsgCmdAdminResetShort       = "cmdAdminResetShort"
+	MsgCliFlagAdminDir          = "cliFlagAdminDir"
+	MsgCliFlagAdminOwner        = "cliFlagAdminOwner"
+	MsgCliFlagAdminForce        = "cliFlagAdminForce"
+	MsgCliAdminExists           = "cliAdminExists"
+	MsgCliAdminRemoved          = "cliAdminRemoved"
+	MsgCliAdminReset            = "cliAdminReset"
+	MsgCliAdminShowFile         = "cliAdminShowFile"
+	MsgCliAdminShowMode         = "cliAdminShowMode"
+	MsgCliAdminShowScratch      = "cliAdminShowScratch"
+	MsgCliAdminShowRateLimit    = "cliAdminShowRateLimit"
+	MsgCliAdminShowUsedCodes    = "cliAdminShowUsedCodes"
+	MsgCliAdminShowLogin        = "cliAdminShowLogin"
+	MsgCmdAuditShort            = "cmdAuditShort"
+	MsgCmdAuditVerifyShort      = "cmdAuditVerifyShort"
+	MsgCliFlagAuditLog          = "cliFlagAuditLog"
+	MsgCliFlagAuditKey          = "cliFlagAuditKey"
+	MsgCliAuditIntact           = "cliAuditIntact"
+	MsgCmdMetricsShort          = "cmdMetricsShort"
+	MsgCliFlagMetricsFile       = "cliFlagMetricsFile"
+	MsgCliFlagMetricsListen     = "cliFlagMetricsListen"
 
 	// 版本信息
 	MsgShowVersionShort = "showVersionShort"
@@ -115,6 +186,191 @@ const (
 	MsgGolangVersion    = "golangVersion"
 )
 
+// allKeys 列出全部消息键，测试据此检查每个键都有翻译。新增常量时一并加入。
+var allKeys = []string{
+	// PAM 相关
+	MsgInvalidArgs,
+	MsgUserLookupFailed,
+	MsgFallbackUser,
+	MsgDropPrivilegesFailed,
+	MsgResolveSecretFailed,
+	MsgUserNoSecretNullOK,
+	MsgReadConfigFailed,
+	MsgPromptTemplateFailed,
+	MsgGraceSkip,
+	MsgUserAuthFailed,
+	MsgAuthFailedGeneric,
+	MsgInternalError,
+	MsgUpdateAuthtokFailed,
+	MsgUserAuthSuccess,
+	MsgSkewReset,
+	MsgAuditOpenFailed,
+	MsgEmptyUsername,
+	MsgSerializeConfigFailed,
+	MsgSecretChangedDuringProcess,
+	MsgSecretChangedRetry,
+	MsgReadonlyWriteIgnored,
+	MsgWriteConfigFailed,
+	MsgUpdateConfigFailed,
+	MsgPromptTooLarge,
+	MsgDummyPassword,
+	MsgStateStoreFailed,
+	MsgUserNoSecretEnroll,
+	MsgEnrollStateFailed,
+	MsgEnrollPending,
+	MsgEnrollExpired,
+	MsgEnrollReminder,
+	MsgEnrollDeadlinePassed,
+	MsgEnrollOnLoginIntro,
+	MsgEnrollConfirmPrompt,
+	MsgEnrollCodeIncorrect,
+	MsgEnrollCompleted,
+	MsgEnrollAborted,
+	MsgUserExempt,
+	MsgExemptionCheckFailed,
+	MsgTrustedNetworkSkip,
+	MsgNetworkCheckFailed,
+	MsgDaemonFailed,
+	MsgDaemonEnrollUnsupported,
+	MsgChauthtokIntro,
+	MsgChauthtokCurrentPrompt,
+	MsgChauthtokNoSecret,
+	MsgChauthtokCompleted,
+	MsgChauthtokAborted,
+	MsgPutEnvFailed,
+	MsgScratchLow,
+	MsgMaxTries,
+
+	// CLI 相关
+	MsgCliDisallowReusePrompt,
+	MsgCliTotpWindowPrompt,
+	MsgCliHotpWindowPrompt,
+	MsgCliRateLimitPrompt,
+	MsgCliPromptTimeBased,
+	MsgCliAllowDisallowConflict,
+	MsgCliCounterTimeConflict,
+	MsgCliFileExistsWarn,
+	MsgCliStepRange,
+	MsgCliWindowRange,
+	MsgCliScratchRange,
+	MsgCliInvalidLang,
+	MsgCliRateArgsMismatch,
+	MsgCliRateLimitRange,
+	MsgCliRateTimePositive,
+	MsgCliRateTimeRange,
+	MsgCliConfigCancelled,
+	MsgCliConfigWritten,
+	MsgCliExecFailed,
+	MsgCliEnterCode,
+	MsgCliCodeSkipped,
+	MsgCliCodeInvalidDigits,
+	MsgCliCodeConfirmed,
+	MsgCliCodeIncorrect,
+	MsgCliUpdateFilePrompt,
+	MsgCliUnknownMode,
+	MsgCliHotpNoReuse,
+	MsgCliQRFail,
+	MsgCliSetupAddInfo,
+	MsgCliSetupURL,
+	MsgCliSetupSecret,
+	MsgCliSetupTimeBased,
+	MsgCliSetupCounterBased,
+	MsgCliSetupManual,
+	MsgCliScratchListHeader,
+	MsgCliUsage,
+	MsgCliShort,
+	MsgCliLong,
+	MsgCmdInitShort,
+	MsgCmdVerifyShort,
+	MsgCliFlagHelp,
+	MsgCliFlagPath,
+	MsgCliFlagSecret,
+	MsgCliFlagForce,
+	MsgCliFlagMode,
+	MsgCliFlagTimeBased,
+	MsgCliFlagCounterBased,
+	MsgCliFlagStepSize,
+	MsgCliFlagWindowSize,
+	MsgCliFlagMinimalWindow,
+	MsgCliFlagRateLimit,
+	MsgCliFlagRateTime,
+	MsgCliFlagDisableRate,
+	MsgCliFlagEmergencyCodes,
+	MsgCliFlagScratchCodes,
+	MsgCliFlagDisallowReuse,
+	MsgCliFlagAllowReuse,
+	MsgCliFlagLabel,
+	MsgCliFlagIssuer,
+	MsgCliFlagLang,
+	MsgCliFlagQuiet,
+	MsgCliFlagQRMode,
+	MsgCliFlagQRInverse,
+	MsgCliFlagQRUTF8,
+	MsgCliFlagConfirm,
+	MsgCliFlagNoConfirm,
+	MsgCliFlagVerifyCode,
+	MsgCliFlagNoSkew,
+	MsgCliFlagNoIncrementHOTP,
+	MsgCliFlagVerifyQuiet,
+	MsgCliVerifyNeedCode,
+	MsgCliVerifyRateLimited,
+	MsgCliVerifyScratchUsed,
+	MsgCliVerifyHOTPSuccess,
+	MsgCliVerifyTOTPSuccess,
+	MsgCmdAdminShort,
+	MsgCmdDaemonShort,
+	MsgCmdServeShort,
+	MsgCliFlagServeListen,
+	MsgCliFlagServeTokenFile,
+	MsgCliFlagServeTLSCert,
+	MsgCliFlagServeTLSKey,
+	MsgCliFlagServeClientCA,
+	MsgCliServeNeedAuth,
+	MsgCliServeTLSArgs,
+	MsgCmdRadiusShort,
+	MsgCliFlagRadiusListen,
+	MsgCliFlagRadiusSecretFile,
+	MsgCliFlagRadiusChallenge,
+	MsgCliFlagRadiusIgnorePass,
+	MsgCliRadiusNeedSecret,
+	MsgCliFlagDaemonSocket,
+	MsgCliFlagDaemonSocketMode,
+	MsgCliFlagDaemonAllowUser,
+	MsgCliFlagDaemonAllowGroup,
+	MsgCmdAdminInitShort,
+	MsgCmdAdminShowShort,
+	MsgCmdAdminListShort,
+	MsgCmdAdminRemoveShort,
+	MsgCmdAdminResetShort,
+	MsgCliFlagAdminDir,
+	MsgCliFlagAdminOwner,
+	MsgCliFlagAdminForce,
+	MsgCliAdminExists,
+	MsgCliAdminRemoved,
+	MsgCliAdminReset,
+	MsgCliAdminShowFile,
+	MsgCliAdminShowMode,
+	MsgCliAdminShowScratch,
+	MsgCliAdminShowRateLimit,
+	MsgCliAdminShowUsedCodes,
+	MsgCliAdminShowLogin,
+	MsgCmdAuditShort,
+	MsgCmdAuditVerifyShort,
+	MsgCliFlagAuditLog,
+	MsgCliFlagAuditKey,
+	MsgCliAuditIntact,
+	MsgCmdMetricsShort,
+	MsgCliFlagMetricsFile,
+	MsgCliFlagMetricsListen,
+
+	// 版本信息
+	MsgShowVersionShort,
+	MsgVersion,
+	MsgGitSha,
+	MsgBuildTime,
+	MsgGolangVersion,
+}
+
 var translations = map[string]map[string]string{
 	// PAM
 	MsgInvalidArgs: {
@@ -173,6 +429,14 @@ var translations = map[string]map[string]string{
 		"en": "User %s authenticated (%s)",
 		"zh": "用户 %s 验证成功 (%s)",
 	},
+	MsgSkewReset: {
+		"en": "Time skew of user %s reset",
+		"zh": "用户 %s 的时间偏差已重新校准",
+	},
+	MsgAuditOpenFailed: {
+		"en": "Failed to open audit log: %v",
+		"zh": "无法打开审计日志: %v",
+	},
 	MsgEmptyUsername: {
 		"en": "username is empty",
 		"zh": "用户名为空",
@@ -210,6 +474,111 @@ var translations = map[string]map[string]string{
 		"zh": "PAM 收到哑密码。请检查 OpenSSH 的 PermitRootLogin 或其他配置是否阻止登录。",
 	},
 
+	MsgStateStoreFailed: {
+		"en": "Failed to open state store %s: %v",
+		"zh": "无法打开状态存储 %s: %v",
+	},
+	MsgUserNoSecretEnroll: {
+		"en": "User %s has no secret configured; deferring to account enrollment policy",
+		"zh": "用户 %s 未配置密钥，交由账户注册策略处理",
+	},
+	MsgEnrollStateFailed: {
+		"en": "Failed to evaluate enrollment deadline: %v",
+		"zh": "无法计算注册截止时间: %v",
+	},
+	MsgEnrollPending: {
+		"en": "User %s has not enrolled; grace period ends %s",
+		"zh": "用户 %s 尚未注册，宽限期截止于 %s",
+	},
+	MsgEnrollExpired: {
+		"en": "User %s missed the enrollment deadline %s",
+		"zh": "用户 %s 已超过注册截止时间 %s",
+	},
+	MsgEnrollReminder: {
+		"en": "Two-factor authentication is not set up for your account. Run \"ggpam init\" before %s or you will be locked out.",
+		"zh": "您的账户尚未配置双因素认证。请在 %s 之前运行 \"ggpam init\"，否则将无法登录。",
+	},
+	MsgEnrollDeadlinePassed: {
+		"en": "Two-factor enrollment was required by %s. Please contact your administrator.",
+		"zh": "双因素认证须在 %s 之前完成注册，请联系管理员。",
+	},
+	MsgEnrollOnLoginIntro: {
+		"en": "Two-factor authentication is required for this account. Set it up now to continue logging in.",
+		"zh": "该账户需要双因素认证，请立即完成配置以继续登录。",
+	},
+	MsgEnrollConfirmPrompt: {
+		"en": "Enter the code from your app to finish setup: ",
+		"zh": "请输入应用中的验证码以完成配置：",
+	},
+	MsgEnrollCodeIncorrect: {
+		"en": "Code incorrect, please try again.",
+		"zh": "验证码不正确，请重试。",
+	},
+	MsgEnrollCompleted: {
+		"en": "User %s enrolled on login; secret written to %s",
+		"zh": "用户 %s 已在登录时完成注册，密钥写入 %s",
+	},
+	MsgEnrollAborted: {
+		"en": "Enrollment on login for user %s failed: %v",
+		"zh": "用户 %s 登录时注册失败: %v",
+	},
+	MsgUserExempt: {
+		"en": "Skipping verification for user %s: %s",
+		"zh": "跳过用户 %s 的验证: %s",
+	},
+	MsgExemptionCheckFailed: {
+		"en": "Failed to evaluate exemptions for user %s: %v",
+		"zh": "无法判断用户 %s 的豁免规则: %v",
+	},
+	MsgTrustedNetworkSkip: {
+		"en": "Host %s is in trusted_networks, skip verification",
+		"zh": "主机 %s 位于 trusted_networks 中，跳过验证码",
+	},
+	MsgNetworkCheckFailed: {
+		"en": "Failed to match host %s against network rules, requiring verification: %v",
+		"zh": "无法匹配主机 %s 的网络规则，仍要求验证码: %v",
+	},
+	MsgDaemonFailed: {
+		"en": "ggpamd request for user %s failed: %v",
+		"zh": "用户 %s 的 ggpamd 请求失败: %v",
+	},
+	MsgDaemonEnrollUnsupported: {
+		"en": "enroll_on_login is not supported with daemon=; use ggpamd enrollment instead",
+		"zh": "daemon= 模式不支持 enroll_on_login，请通过 ggpamd 完成注册",
+	},
+	MsgChauthtokIntro: {
+		"en": "Changing your verification code secret. The current secret keeps working until the new device is confirmed.",
+		"zh": "正在更换验证码密钥，新设备确认之前当前密钥仍然有效。",
+	},
+	MsgChauthtokCurrentPrompt: {
+		"en": "Current verification code: ",
+		"zh": "当前验证码：",
+	},
+	MsgChauthtokNoSecret: {
+		"en": "User %s has no secret configured; nothing to change",
+		"zh": "用户 %s 未配置密钥，无需更换",
+	},
+	MsgChauthtokCompleted: {
+		"en": "Secret for user %s replaced in %s",
+		"zh": "用户 %s 的密钥已在 %s 中更换",
+	},
+	MsgChauthtokAborted: {
+		"en": "Changing the secret for user %s failed: %v",
+		"zh": "更换用户 %s 的密钥失败: %v",
+	},
+	MsgPutEnvFailed: {
+		"en": "Failed to set PAM environment variable %s",
+		"zh": "无法设置 PAM 环境变量 %s",
+	},
+	MsgScratchLow: {
+		"en": "Warning: {count, plural, =0 {no emergency scratch codes} one {only # emergency scratch code} other {only # emergency scratch codes}} left. Generate a new secret soon to get fresh ones.",
+		"zh": "警告：{count, plural, =0 {应急码已用完} other {仅剩 # 个应急码}}，请尽快重新生成密钥以获取新的应急码。",
+	},
+	MsgMaxTries: {
+		"en": "User {user} failed verification {count, plural, one {once} other {# times}}, giving up",
+		"zh": "用户 {user} 验证失败 {count} 次，放弃重试",
+	},
+
 	// CLI
 	MsgCliDisallowReusePrompt: {
 		"en": "Do you want to disallow multiple uses of the same authentication token? This restricts you to one login about every 30s, but it increases your chances to notice or even prevent man-in-the-middle attacks",
@@ -255,6 +624,10 @@ var translations = map[string]map[string]string{
 		"en": "emergency-codes must be in 0..%d",
 		"zh": "emergency-codes 需在 0..%d 范围内",
 	},
+	MsgCliInvalidLang: {
+		"en": "lang %q is not a locale such as de or zh_TW",
+		"zh": "lang %q 不是有效的语言区域（如 de、zh_TW）",
+	},
 	MsgCliRateArgsMismatch: {
 		"en": "Both --rate-limit and --rate-time must be set together",
 		"zh": "--rate-limit 与 --rate-time 必须同时设置",
@@ -431,6 +804,10 @@ var translations = map[string]map[string]string{
 		"en": "Issuer for otpauth URL",
 		"zh": "otpauth URL 的 issuer",
 	},
+	MsgCliFlagLang: {
+		"en": "Language of the login prompts, overriding the session locale",
+		"zh": "登录提示使用的语言，优先于会话的 locale",
+	},
 	MsgCliFlagQuiet: {
 		"en": "Quiet mode, only essential output",
 		"zh": "静默模式，仅输出必要信息",
@@ -551,6 +928,186 @@ Options:
 		"en": "Google Authenticator CLI provides initialization and verification utilities.",
 		"zh": "Google Authenticator CLI，提供配置初始化与验证码验证功能。",
 	},
+	MsgCmdServeShort: {
+		"en": "Serve the HTTP verification API",
+		"zh": "提供 HTTP 验证 API",
+	},
+	MsgCliFlagServeListen: {
+		"en": "Address to listen on",
+		"zh": "监听地址",
+	},
+	MsgCliFlagServeTokenFile: {
+		"en": "File with accepted bearer tokens, one per line",
+		"zh": "允许的 Bearer 令牌文件，每行一个",
+	},
+	MsgCliFlagServeTLSCert: {
+		"en": "TLS certificate file",
+		"zh": "TLS 证书文件",
+	},
+	MsgCliFlagServeTLSKey: {
+		"en": "TLS private key file",
+		"zh": "TLS 私钥文件",
+	},
+	MsgCliFlagServeClientCA: {
+		"en": "CA bundle for verifying client certificates (mTLS)",
+		"zh": "用于校验客户端证书的 CA（mTLS）",
+	},
+	MsgCliServeNeedAuth: {
+		"en": "Client authentication is required: use --token-file and/or --client-ca",
+		"zh": "必须配置客户端认证：使用 --token-file 和/或 --client-ca",
+	},
+	MsgCliServeTLSArgs: {
+		"en": "--tls-cert and --tls-key must be given together and are required by --client-ca",
+		"zh": "--tls-cert 与 --tls-key 必须同时指定，且 --client-ca 需要二者",
+	},
+	MsgCmdRadiusShort: {
+		"en": "Serve RADIUS PAP verification for network devices",
+		"zh": "为网络设备提供 RADIUS PAP 验证服务",
+	},
+	MsgCliFlagRadiusListen: {
+		"en": "UDP address to listen on",
+		"zh": "UDP 监听地址",
+	},
+	MsgCliFlagRadiusSecretFile: {
+		"en": "File containing the RADIUS shared secret",
+		"zh": "包含 RADIUS 共享密钥的文件",
+	},
+	MsgCliFlagRadiusChallenge: {
+		"en": "Answer requests without a code with an Access-Challenge",
+		"zh": "对未携带验证码的请求返回 Access-Challenge",
+	},
+	MsgCliFlagRadiusIgnorePass: {
+		"en": "Accept a password in front of the code without checking it; only safe when the NAS or another server verifies it",
+		"zh": "接受验证码前的密码但不校验；仅在设备或另一台服务器校验该密码时才安全",
+	},
+	MsgCliRadiusNeedSecret: {
+		"en": "--secret-file must name a non-empty file",
+		"zh": "--secret-file 必须指向非空文件",
+	},
+	MsgCmdDaemonShort: {
+		"en": "Verification daemon serving the PAM module over a Unix socket",
+		"zh": "通过 Unix 套接字为 PAM 模块提供验证服务的守护进程",
+	},
+	MsgCliFlagDaemonSocket: {
+		"en": "Unix socket path",
+		"zh": "Unix 套接字路径",
+	},
+	MsgCliFlagDaemonSocketMode: {
+		"en": "Permissions of the socket (octal)",
+		"zh": "套接字权限（八进制）",
+	},
+	MsgCliFlagDaemonAllowUser: {
+		"en": "Users allowed to act on any account, in addition to root",
+		"zh": "除 root 外可操作任意账户的用户",
+	},
+	MsgCliFlagDaemonAllowGroup: {
+		"en": "Groups whose members may act on any account",
+		"zh": "成员可操作任意账户的组",
+	},
+	MsgCmdAdminShort: {
+		"en": "Manage secrets in the central store",
+		"zh": "管理集中存储中的密钥",
+	},
+	MsgCmdAdminInitShort: {
+		"en": "Create a secret for a user",
+		"zh": "为用户创建密钥",
+	},
+	MsgCmdAdminShowShort: {
+		"en": "Show a user's secret settings and state",
+		"zh": "显示用户密钥的设置与状态",
+	},
+	MsgCmdAdminListShort: {
+		"en": "List users with a secret",
+		"zh": "列出已配置密钥的用户",
+	},
+	MsgCmdAdminRemoveShort: {
+		"en": "Remove a user's secret",
+		"zh": "删除用户的密钥",
+	},
+	MsgCmdAdminResetShort: {
+		"en": "Clear rate-limit, replay and login state of a user",
+		"zh": "清除用户的速率限制、重放与登录记录",
+	},
+	MsgCliFlagAdminDir: {
+		"en": "Central secret store directory",
+		"zh": "集中密钥存储目录",
+	},
+	MsgCliFlagAdminOwner: {
+		"en": "System user owning the secret files",
+		"zh": "密钥文件的属主系统用户",
+	},
+	MsgCliFlagAdminForce: {
+		"en": "Replace an existing secret",
+		"zh": "覆盖已有密钥",
+	},
+	MsgCliAdminExists: {
+		"en": "User %s already has a secret; use --force to replace it",
+		"zh": "用户 %s 已有密钥，使用 --force 覆盖",
+	},
+	MsgCliAdminRemoved: {
+		"en": "Removed secret of %s",
+		"zh": "已删除 %s 的密钥",
+	},
+	MsgCliAdminReset: {
+		"en": "Reset state of %s",
+		"zh": "已重置 %s 的状态",
+	},
+	MsgCliAdminShowFile: {
+		"en": "File: %s",
+		"zh": "文件: %s",
+	},
+	MsgCliAdminShowMode: {
+		"en": "Mode: %s",
+		"zh": "模式: %s",
+	},
+	MsgCliAdminShowScratch: {
+		"en": "Emergency codes left: %d",
+		"zh": "剩余应急码: %d",
+	},
+	MsgCliAdminShowRateLimit: {
+		"en": "Rate limit: %d per %ds (%d recorded attempts)",
+		"zh": "速率限制: 每 %[2]d 秒 %[1]d 次（已记录 %[3]d 次尝试）",
+	},
+	MsgCliAdminShowUsedCodes: {
+		"en": "Used codes remembered: %d",
+		"zh": "已记录的已用验证码: %d",
+	},
+	MsgCliAdminShowLogin: {
+		"en": "Last login from %s at %s",
+		"zh": "最近登录: %s，时间 %s",
+	},
+	MsgCmdAuditShort: {
+		"en": "Inspect the tamper-evident audit log",
+		"zh": "检查防篡改审计日志",
+	},
+	MsgCmdAuditVerifyShort: {
+		"en": "Verify the hash chain of the audit log",
+		"zh": "校验审计日志的哈希链",
+	},
+	MsgCliFlagAuditLog: {
+		"en": "Audit log file",
+		"zh": "审计日志文件",
+	},
+	MsgCliFlagAuditKey: {
+		"en": "HMAC key file of the audit log",
+		"zh": "审计日志的 HMAC 密钥文件",
+	},
+	MsgCliAuditIntact: {
+		"en": "{log}: {records, plural, one {# record} other {# records}} intact, last sequence {seq} at {time}",
+		"zh": "{log}: {records} 条记录完好，最后序号 {seq}，时间 {time}",
+	},
+	MsgCmdMetricsShort: {
+		"en": "Print or serve the Prometheus metrics of metrics_file=",
+		"zh": "输出或通过 HTTP 提供 metrics_file= 的 Prometheus 指标",
+	},
+	MsgCliFlagMetricsFile: {
+		"en": "Metrics textfile written by the module",
+		"zh": "模块写入的指标文本文件",
+	},
+	MsgCliFlagMetricsListen: {
+		"en": "Serve /metrics on this address instead of printing",
+		"zh": "在此地址提供 /metrics，而不是直接输出",
+	},
 	MsgShowVersionShort: {
 		"en": "Show Version",
 		"zh": "显示版本信息",
@@ -573,46 +1130,29 @@ Options:
 	},
 }
 
-// Msgf returns the formatted translation.
+// Msgf returns the formatted translation for DetectLang.
 func Msgf(key string, args ...interface{}) string {
-	format := Resolve(key)
-	if len(args) == 0 {
-		return format
-	}
-	return fmt.Sprintf(format, args...)
+	return NewLocalizer(DetectLang()).Msgf(key, args...)
 }
 
-// Resolve returns the translation for key, falling back to en or key itself.
+// Format returns the translation of key in the named format for DetectLang.
+func Format(key string, args Args) string {
+	return NewLocalizer(DetectLang()).Format(key, args)
+}
+
+// Resolve returns the translation of key for DetectLang, walking the
+// locale fallback chain down to en, or key itself.
 func Resolve(key string) string {
-	lang := DetectLang()
-	if text := translations[key][lang]; text != "" {
-		return text
-	}
-	if text := translations[key]["en"]; text != "" {
-		return text
-	}
-	return key
+	return NewLocalizer(DetectLang()).Resolve(key)
 }
 
-// DetectLang reads locale env vars and normalizes to "en" / "zh".
+// DetectLang returns the locale of LC_ALL, LC_MESSAGES or LANG as a tag
+// such as "zh_TW", or "en" when none is set or it is C/POSIX.
 func DetectLang() string {
-	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
-		if v := os.Getenv(env); v != "" {
-			return normalizeLocale(v)
+	if v, ok := EnvLocale(os.Getenv); ok {
+		if tag := canonicalLocale(v); tag != "" {
+			return tag
 		}
 	}
 	return "en"
 }
-
-func normalizeLocale(locale string) string {
-	lower := strings.ToLower(locale)
-	if idx := strings.IndexAny(lower, "._@"); idx >= 0 {
-		lower = lower[:idx]
-	}
-	switch {
-	case strings.HasPrefix(lower, "zh"):
-		return "zh"
-	default:
-		return "en"
-	}
-}
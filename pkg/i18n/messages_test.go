@@ -0,0 +1,62 @@
+package i18n
+
+import (
+	"encoding/json"
+	"io/fs"
+	"testing"
+)
+
+func TestEveryConstantTranslated(t *testing.T) {
+	declared := make(map[string]bool)
+	for _, key := range allKeys {
+		if declared[key] {
+			t.Errorf("%q listed twice in allKeys", key)
+		}
+		declared[key] = true
+		for _, lang := range []string{"en", "zh"} {
+			if translations[key][lang] == "" {
+				t.Errorf("%q has no %s text", key, lang)
+			}
+		}
+	}
+	for key := range translations {
+		if !declared[key] {
+			t.Errorf("translation %q is missing from allKeys", key)
+		}
+	}
+
+	names, err := fs.Glob(bundled, "locale/*.json")
+	if err != nil || len(names) == 0 {
+		t.Fatalf("no bundled catalogs: %v", err)
+	}
+	for _, name := range names {
+		data, err := bundled.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var texts map[string]string
+		if err := json.Unmarshal(data, &texts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for key := range texts {
+			if _, ok := translations[key]; !ok {
+				t.Errorf("%s: unknown key %q", name, key)
+			}
+		}
+	}
+}
+
+func TestTranslationsPlaceholders(t *testing.T) {
+	for key, texts := range translations {
+		en := texts["en"]
+		if _, err := signature(en); err != nil {
+			t.Errorf("%s: English text: %v", key, err)
+			continue
+		}
+		for lang, text := range texts {
+			if err := checkPlaceholders(en, text); err != nil {
+				t.Errorf("%s: %s: %v", key, lang, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// DefaultAuditLog 是 "ggpam audit verify" 默认校验的审计日志。
+	DefaultAuditLog = "/var/log/ggpam/audit.log"
+	// DefaultAuditKey 是未指定 audit_key= 时使用的 HMAC 密钥文件，不存在时自动生成。
+	DefaultAuditKey = "/etc/ggpam/audit.key"
+)
+
+const (
+	auditKeyBytes = 32
+	// auditTailBytes 是查找最后一条记录时从文件末尾读取的字节数，远大于单条记录。
+	auditTailBytes = 64 << 10
+)
+
+// ErrAuditTampered 表示审计日志的哈希链断裂：记录被修改、删除或插入。
+var ErrAuditTampered = errors.New("audit log tampered")
+
+// AuditRecord 是审计日志中的一行 JSON。MAC 为以密钥计算的 HMAC-SHA256，
+// 覆盖除 mac 之外的全部字段；Prev 为上一条记录的 MAC，第一条为空，
+// 因此修改、删除或插入任意一条都会使其后的链校验失败。
+type AuditRecord struct {
+	Seq        uint64 `json:"seq"`
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	User       string `json:"user,omitempty"`
+	Rhost      string `json:"rhost,omitempty"`
+	Service    string `json:"service,omitempty"`
+	Result     string `json:"result,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	PID        int    `json:"pid"`
+	Prev       string `json:"prev"`
+	MAC        string `json:"mac,omitempty"`
+}
+
+func (r AuditRecord) sum(key []byte) string {
+	r.MAC = ""
+	data, _ := json.Marshal(r)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type auditor struct {
+	mu   sync.Mutex
+	file *os.File
+	key  []byte
+}
+
+var (
+	auditMu     sync.Mutex
+	auditWriter *auditor
+)
+
+// ConfigureAudit 打开审计日志 logPath；为空时关闭审计。keyPath 为空时使用
+// DefaultAuditKey。文件在调用时以当前身份打开，之后降权也能继续追加，
+// 因此 PAM 模块须在切换到密钥属主之前调用。
+func ConfigureAudit(logPath, keyPath string) error {
+	var a *auditor
+	if logPath != "" {
+		var err error
+		if a, err = openAuditor(logPath, firstNonEmpty(keyPath, DefaultAuditKey)); err != nil {
+			return err
+		}
+	}
+	auditMu.Lock()
+	old := auditWriter
+	auditWriter = a
+	auditMu.Unlock()
+	if old != nil {
+		old.file.Close()
+	}
+	return nil
+}
+
+func openAuditor(logPath, keyPath string) (*auditor, error) {
+	key, err := LoadAuditKey(keyPath, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o750); err != nil {
+		return nil, fmt.Errorf("create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND|os.O_CREATE|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrivate(f, 0o022); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit log %s: %w", logPath, err)
+	}
+	return &auditor{file: f, key: key}, nil
+}
+
+// LoadAuditKey 读取十六进制编码的审计密钥。create 为真且文件不存在时生成
+// 新密钥并以 0600 写入。密钥文件必须属于当前用户且不可被其他用户读取。
+func LoadAuditKey(path string, create bool) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if errors.Is(err, os.ErrNotExist) && create {
+		if err := writeAuditKey(path); err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		f, err = os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := checkPrivate(f, 0o077); err != nil {
+		return nil, fmt.Errorf("audit key %s: %w", path, err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("read audit key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < auditKeyBytes/2 {
+		return nil, fmt.Errorf("audit key %s: invalid key", path)
+	}
+	return key, nil
+}
+
+func writeAuditKey(path string) error {
+	key := make([]byte, auditKeyBytes)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create audit key directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", hex.EncodeToString(key)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("write audit key: %w", err)
+	}
+	return f.Close()
+}
+
+// checkPrivate 要求 f 为当前有效用户拥有的普通文件，且没有 forbidden 中的权限位。
+func checkPrivate(f *os.File, forbidden os.FileMode) error {
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return err
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFREG {
+		return errors.New("not a regular file")
+	}
+	if int(st.Uid) != os.Geteuid() {
+		return fmt.Errorf("owned by uid %d, want %d", st.Uid, os.Geteuid())
+	}
+	if os.FileMode(st.Mode)&forbidden != 0 {
+		return fmt.Errorf("permissions %04o are too open", st.Mode&0o7777)
+	}
+	return nil
+}
+
+// audit 为命名事件追加一条审计记录；未归类的普通日志不审计。
+func audit(ev Event, now time.Time) {
+	if ev.Name == "" || ev.Name == EventMessage {
+		return
+	}
+	auditMu.Lock()
+	a := auditWriter
+	auditMu.Unlock()
+	if a == nil {
+		return
+	}
+	if err := a.append(ev, now); err != nil {
+		defaultLogger.emit(LevelError, Event{Name: EventError, ErrorClass: ClassInternal, Err: err}, fmt.Sprintf("audit: %v", err))
+	}
+}
+
+func (a *auditor) append(ev Event, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fd := int(a.file.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return fmt.Errorf("lock audit log: %w", err)
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	last, err := lastAuditRecord(a.file)
+	if err != nil {
+		return err
+	}
+	rec := AuditRecord{
+		Seq:        last.Seq + 1,
+		Time:       now.UTC().Format(time.RFC3339Nano),
+		Event:      ev.Name,
+		User:       ev.User,
+		Rhost:      ev.Rhost,
+		Service:    ev.Service,
+		Result:     ev.Result,
+		ErrorClass: ev.ErrorClass,
+		PID:        os.Getpid(),
+		Prev:       last.MAC,
+	}
+	rec.MAC = rec.sum(a.key)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+// lastAuditRecord 返回文件中的最后一条记录，空文件返回零值。
+func lastAuditRecord(f *os.File) (AuditRecord, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return AuditRecord{}, err
+	}
+	size := info.Size()
+	start := max(size-auditTailBytes, 0)
+	buf := make([]byte, size-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return AuditRecord{}, fmt.Errorf("read audit log: %w", err)
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if len(buf) == 0 {
+		return AuditRecord{}, nil
+	}
+	line := buf[bytes.LastIndexByte(buf, '\n')+1:]
+	var rec AuditRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return AuditRecord{}, fmt.Errorf("%w: last record: %v", ErrAuditTampered, err)
+	}
+	return rec, nil
+}
+
+// AuditSummary 是 VerifyAudit 的结果。
+type AuditSummary struct {
+	Records int
+	LastSeq uint64
+	Last    string
+}
+
+// VerifyAudit 从头校验 r 中的审计记录：序号连续、Prev 与上一条的 MAC 一致、
+// MAC 正确。第一处问题以 ErrAuditTampered 返回并注明行号。截断末尾的记录
+// 无法由链本身发现，应与 LastSeq 的外部副本比对。
+func VerifyAudit(r io.Reader, key []byte) (AuditSummary, error) {
+	var sum AuditSummary
+	var prev AuditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), auditTailBytes)
+	for line := 1; scanner.Scan(); line++ {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return sum, fmt.Errorf("%w: line %d: %v", ErrAuditTampered, line, err)
+		}
+		switch {
+		case rec.Seq != prev.Seq+1:
+			return sum, fmt.Errorf("%w: line %d: sequence %d follows %d", ErrAuditTampered, line, rec.Seq, prev.Seq)
+		case rec.Prev != prev.MAC:
+			return sum, fmt.Errorf("%w: line %d: chain link does not match the previous record", ErrAuditTampered, line)
+		case !hmac.Equal([]byte(rec.MAC), []byte(rec.sum(key))):
+			return sum, fmt.Errorf("%w: line %d: record was modified", ErrAuditTampered, line)
+		}
+		prev = rec
+		sum.Records++
+		sum.LastSeq = rec.Seq
+		sum.Last = rec.Time
+	}
+	if err := scanner.Err(); err != nil {
+		return sum, err
+	}
+	return sum, nil
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestAudit(t *testing.T) (logPath, keyPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	logPath, keyPath = filepath.Join(dir, "log", "audit.log"), filepath.Join(dir, "audit.key")
+	if err := ConfigureAudit(logPath, keyPath); err != nil {
+		t.Fatalf("configure audit: %v", err)
+	}
+	t.Cleanup(func() { ConfigureAudit("", "") })
+	return logPath, keyPath
+}
+
+func verifyFile(t *testing.T, data []byte, keyPath string) (AuditSummary, error) {
+	t.Helper()
+	key, err := LoadAuditKey(keyPath, false)
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	return VerifyAudit(bytes.NewReader(data), key)
+}
+
+func TestAuditChain(t *testing.T) {
+	logPath, keyPath := newTestAudit(t)
+	base := Event{User: "alice", Rhost: "192.0.2.1", Service: "sshd"}
+	Emit(LevelDebug, base.With(EventAuthFailure).WithError(ClassRateLimited, errors.New("too many attempts")), "rate limited")
+	Emit(LevelInfo, Event{}, "plain message")
+	Emit(LevelInfo, base.With(EventSkewReset), "skew reset")
+	Emit(LevelInfo, base.With(EventAuthSuccess).WithResult("scratch"), "scratch used")
+
+	for _, path := range []string{logPath, keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%s has mode %v, want 0600", path, info.Mode().Perm())
+		}
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := verifyFile(t, data, keyPath)
+	if err != nil {
+		t.Fatalf("verify: %v\n%s", err, data)
+	}
+	if sum.Records != 3 || sum.LastSeq != 3 {
+		t.Fatalf("summary %+v, want 3 records", sum)
+	}
+	if strings.Contains(string(data), "too many attempts") {
+		t.Errorf("error text must not be audited:\n%s", data)
+	}
+
+	// A new process continues the chain of the existing file.
+	if err := ConfigureAudit(logPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	Emit(LevelInfo, base.With(EventAuthSkipped).WithResult("grace"), "grace")
+	data, _ = os.ReadFile(logPath)
+	if sum, err := verifyFile(t, data, keyPath); err != nil || sum.LastSeq != 4 {
+		t.Fatalf("after reopen: %+v %v\n%s", sum, err, data)
+	}
+}
+
+func TestAuditTampering(t *testing.T) {
+	logPath, keyPath := newTestAudit(t)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		Emit(LevelInfo, Event{Name: EventAuthFailure, User: user, ErrorClass: ClassInvalidCode}, "failed")
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+	for name, tampered := range map[string]string{
+		"modified":       strings.Replace(string(data), `"user":"bob"`, `"user":"eve"`, 1),
+		"removed":        lines[0] + lines[2],
+		"reordered":      lines[1] + lines[0] + lines[2],
+		"truncated line": string(data[:len(data)-10]),
+	} {
+		if _, err := verifyFile(t, []byte(tampered), keyPath); !errors.Is(err, ErrAuditTampered) {
+			t.Errorf("%s: got %v, want ErrAuditTampered", name, err)
+		}
+	}
+
+	other := filepath.Join(t.TempDir(), "other.key")
+	key, err := LoadAuditKey(other, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyAudit(bytes.NewReader(data), key); !errors.Is(err, ErrAuditTampered) {
+		t.Errorf("wrong key: got %v", err)
+	}
+}
+
+func TestAuditKeyPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.key")
+	if _, err := LoadAuditKey(path, false); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing key: got %v", err)
+	}
+	if _, err := LoadAuditKey(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAuditKey(path, false); err == nil {
+		t.Fatal("world-readable key accepted")
+	}
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ggpam/pkg/version"
+)
+
+// journalSink 以 systemd journal 原生协议发送记录：每个数据报由
+// "KEY=value\n" 组成，含换行的值使用 "KEY\n<64 位小端长度><value>\n"。
+// 事件字段以 GGPAM_ 前缀的大写键名写入，可用 journalctl GGPAM_EVENT=... 过滤。
+type journalSink struct {
+	*datagramSink
+}
+
+func newJournalSink(path string) (sink, error) {
+	s, err := dialSink("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return journalSink{s}, nil
+}
+
+func (s journalSink) send(level Level, ev Event, text string, _ time.Time) error {
+	return s.write(journalEntry(level, ev, text))
+}
+
+func journalEntry(level Level, ev Event, text string) []byte {
+	var buf bytes.Buffer
+	field := func(key, value string) {
+		if !strings.Contains(value, "\n") {
+			buf.WriteString(key)
+			buf.WriteByte('=')
+			buf.WriteString(value)
+			buf.WriteByte('\n')
+			return
+		}
+		buf.WriteString(key)
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	field("MESSAGE", text)
+	field("PRIORITY", strconv.Itoa(level.severity()))
+	field("SYSLOG_FACILITY", strconv.Itoa(facilityAuthpriv))
+	field("SYSLOG_IDENTIFIER", ident())
+	field("SYSLOG_PID", strconv.Itoa(os.Getpid()))
+	field("GGPAM_VERSION", version.Version)
+	for _, f := range ev.fields() {
+		field("GGPAM_"+strings.ToUpper(f[0]), f[1])
+	}
+	return buf.Bytes()
+}
@@ -1,13 +1,18 @@
 package logging
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"ggpam/pkg/version"
 )
 
 var (
@@ -17,8 +22,19 @@ var (
 )
 
 const (
-	LogLevel = "GGPAM_LOG_LEVEL"
-	LogPath  = "GGPAM_LOG_FILE"
+	LogLevel  = "GGPAM_LOG_LEVEL"
+	LogPath   = "GGPAM_LOG_FILE"
+	LogFormat = "GGPAM_LOG_FORMAT"
+)
+
+// Format 决定日志行的格式。
+type Format int
+
+const (
+	// FormatText 输出 "[LEVEL] msg" 文本行。
+	FormatText Format = iota
+	// FormatJSON 每行输出一个 JSON 对象，字段见 Event。
+	FormatJSON
 )
 
 type Level int
@@ -32,16 +48,24 @@ const (
 
 type Config struct {
 	Level           Level
+	Format          Format
 	FilePath        string
 	AlsoStderr      bool
+	Sink            string
+	Rotation        Rotation
 	derivedFromHome bool
 }
 
 type logger struct {
 	mu              sync.Mutex
 	level           Level
+	format          Format
 	logger          *log.Logger
-	file            *os.File
+	json            *slog.Logger
+	file            *rotatingFile
+	rotation        Rotation
+	sink            sink
+	sinkSpec        string
 	derivedFromHome bool
 	alsoStderr      bool
 }
@@ -53,6 +77,7 @@ func newLogger() *logger {
 	return &logger{
 		level:      LevelInfo,
 		logger:     l,
+		json:       newJSONLogger(os.Stderr),
 		alsoStderr: true,
 	}
 }
@@ -64,6 +89,14 @@ func ConfigureDefault(home string) error {
 	return defaultLogger.configure(cfg)
 }
 
+// ConfigureQuiet 与 ConfigureDefault 相同，但不输出到 stderr；未配置日志文件时
+// 丢弃日志。供交互式 CLI 使用，避免事件混入终端输出。
+func ConfigureQuiet() error {
+	cfg := buildDefaultConfig("")
+	cfg.AlsoStderr = false
+	return defaultLogger.configure(cfg)
+}
+
 // UpdateHome 在默认路径来自 Home 时，切换到新的 Home 目录。
 func UpdateHome(home string) error {
 	if home == "" {
@@ -75,10 +108,12 @@ func UpdateHome(home string) error {
 func buildDefaultConfig(home string) Config {
 	levelStr := firstNonEmpty(os.Getenv(LogLevel), DefaultLevel)
 	level := parseLevel(levelStr)
+	format := parseFormat(os.Getenv(LogFormat))
+	sinkSpec := os.Getenv(LogSink)
 
 	filePath := os.Getenv(LogPath)
 	fromHome := false
-	if filePath == "" && isTruthy(DefaultHomeLogging) {
+	if filePath == "" && isTruthy(DefaultHomeLogging) && isFileSink(sinkSpec) {
 		targetHome := home
 		if targetHome == "" {
 			if h, err := os.UserHomeDir(); err == nil {
@@ -92,8 +127,11 @@ func buildDefaultConfig(home string) Config {
 	}
 	return Config{
 		Level:           level,
+		Format:          format,
 		FilePath:        filePath,
 		AlsoStderr:      true,
+		Sink:            sinkSpec,
+		Rotation:        buildRotation(),
 		derivedFromHome: fromHome,
 	}
 }
@@ -106,31 +144,74 @@ func (l *logger) configure(cfg Config) error {
 		l.file.Close()
 		l.file = nil
 	}
+	if l.sink != nil {
+		l.sink.close()
+		l.sink = nil
+	}
+	l.level = cfg.Level
+	l.format = cfg.Format
+	l.sinkSpec = cfg.Sink
+	l.rotation = cfg.Rotation
+	l.alsoStderr = cfg.AlsoStderr
+
+	// 目标不可用时回退到文件与 stderr，并返回错误；发送失败的记录写入 stderr。
+	s, sinkErr := parseSink(cfg.Sink)
+	if s != nil {
+		l.sink = s
+		l.logger.SetOutput(os.Stderr)
+		l.json = newJSONLogger(os.Stderr)
+		l.derivedFromHome = false
+		return nil
+	}
 
 	var writers []io.Writer
 	if cfg.FilePath != "" {
-		f, err := openFile(cfg.FilePath)
+		var errs io.Writer
+		if cfg.AlsoStderr {
+			errs = os.Stderr
+		}
+		f, err := openRotating(cfg.FilePath, cfg.Rotation, errs)
 		if err != nil {
 			return err
 		}
 		l.file = f
 		writers = append(writers, f)
 	}
-	if cfg.AlsoStderr || len(writers) == 0 {
+	if cfg.AlsoStderr {
 		writers = append(writers, os.Stderr)
 	}
+	if len(writers) == 0 {
+		writers = append(writers, io.Discard)
+	}
 
-	l.logger.SetOutput(io.MultiWriter(writers...))
-	l.level = cfg.Level
+	out := io.MultiWriter(writers...)
+	l.logger.SetOutput(out)
+	l.json = newJSONLogger(out)
 	l.derivedFromHome = cfg.derivedFromHome
-	l.alsoStderr = cfg.AlsoStderr
-	return nil
+	return sinkErr
+}
+
+func isFileSink(spec string) bool {
+	kind, _, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	kind = strings.ToLower(kind)
+	return kind != "journald" && kind != "syslog"
+}
+
+// SystemLog 报告日志是否发往 journald 或 syslog。此时 PAM 模块不再另行
+// 调用 pam_syslog，避免同一条记录出现两次。
+func SystemLog() bool {
+	defaultLogger.mu.Lock()
+	defer defaultLogger.mu.Unlock()
+	return defaultLogger.sink != nil
 }
 
 func (l *logger) updateHome(home string) error {
 	l.mu.Lock()
 	fromHome := l.derivedFromHome
 	level := l.level
+	format := l.format
+	sinkSpec := l.sinkSpec
+	rotation := l.rotation
 	alsoStderr := l.alsoStderr
 	l.mu.Unlock()
 	if !fromHome {
@@ -138,23 +219,16 @@ func (l *logger) updateHome(home string) error {
 	}
 	cfg := Config{
 		Level:           level,
+		Format:          format,
 		FilePath:        filepath.Join(home, DefaultFilename),
 		AlsoStderr:      alsoStderr,
+		Sink:            sinkSpec,
+		Rotation:        rotation,
 		derivedFromHome: true,
 	}
 	return l.configure(cfg)
 }
 
-func openFile(path string) (*os.File, error) {
-	if path == "" {
-		return nil, nil
-	}
-	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
-		return nil, err
-	}
-	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
-}
-
 func parseLevel(value string) Level {
 	switch strings.ToLower(strings.TrimSpace(value)) {
 	case "debug":
@@ -168,15 +242,54 @@ func parseLevel(value string) Level {
 	}
 }
 
+func parseFormat(value string) Format {
+	if strings.EqualFold(strings.TrimSpace(value), "json") {
+		return FormatJSON
+	}
+	return FormatText
+}
+
+// newJSONLogger 创建 JSON 格式的 slog 记录器，时间统一为 UTC，
+// 每条记录附带模块版本；pid 由 emit 逐条添加，fork 后仍然正确。
+func newJSONLogger(w io.Writer) *slog.Logger {
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Time(slog.TimeKey, a.Value.Time().UTC().Truncate(time.Millisecond))
+			}
+			return a
+		},
+	})
+	return slog.New(h).With("version", version.Version)
+}
+
 func (l *logger) logf(level Level, format string, args ...any) {
+	l.emit(level, Event{Name: EventMessage}, fmt.Sprintf(format, args...))
+}
+
+func (l *logger) emit(level Level, ev Event, text string) {
 	l.mu.Lock()
 	enabled := level >= l.level
-	logger := l.logger
+	logger, json, asJSON, s := l.logger, l.json, l.format == FormatJSON, l.sink
 	l.mu.Unlock()
-	if !enabled || logger == nil {
+	if !enabled {
 		return
 	}
-	logger.Printf("[%s] %s", level.String(), fmt.Sprintf(format, args...))
+	text = Redact(text)
+	if s != nil {
+		if err := s.send(level, ev, text, time.Now()); err == nil {
+			return
+		}
+	}
+	if asJSON && json != nil {
+		attrs := append([]slog.Attr{slog.Int("pid", os.Getpid())}, ev.attrs()...)
+		json.LogAttrs(context.Background(), level.slog(), text, attrs...)
+		return
+	}
+	if logger != nil {
+		logger.Printf("[%s] %s", level.String(), text)
+	}
 }
 
 // Debugf 输出调试日志。
@@ -217,6 +330,19 @@ func isTruthy(val string) bool {
 	}
 }
 
+func (l Level) slog() slog.Level {
+	switch l {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
 func (l Level) String() string {
 	switch l {
 	case LevelDebug:
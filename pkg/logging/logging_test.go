@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ggpam/pkg/version"
+)
+
+func newTestLogger(t *testing.T, format Format) (*logger, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ggpam.log")
+	l := newLogger()
+	if err := l.configure(Config{Level: LevelInfo, Format: format, FilePath: path}); err != nil {
+		t.Fatalf("configure: %v", err)
+	}
+	t.Cleanup(func() { l.file.Close() })
+	return l, path
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestJSONEvent(t *testing.T) {
+	l, path := newTestLogger(t, FormatJSON)
+	base := Event{User: "alice", Rhost: "192.0.2.1", Service: "sshd"}
+	l.emit(LevelWarn, base.With(EventAuthFailure).WithError(ClassInvalidCode, errors.New("invalid verification code")), "verification failed")
+	l.emit(LevelDebug, base.With(EventAuthSuccess), "below the level")
+
+	lines := readLines(t, path)
+	if len(lines) != 1 {
+		t.Fatalf("want 1 line, got %q", lines)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, lines[0])
+	}
+	want := map[string]any{
+		"level":       "WARN",
+		"msg":         "verification failed",
+		"event":       EventAuthFailure,
+		"user":        "alice",
+		"rhost":       "192.0.2.1",
+		"service":     "sshd",
+		"error_class": ClassInvalidCode,
+		"error":       "invalid verification code",
+		"pid":         float64(os.Getpid()),
+		"version":     version.Version,
+	}
+	for key, value := range want {
+		if rec[key] != value {
+			t.Errorf("%s = %v, want %v", key, rec[key], value)
+		}
+	}
+	if _, ok := rec["result"]; ok {
+		t.Errorf("empty result should be omitted: %s", lines[0])
+	}
+	ts, _ := rec["time"].(string)
+	if parsed, err := time.Parse(time.RFC3339Nano, ts); err != nil || !strings.HasSuffix(ts, "Z") || parsed.IsZero() {
+		t.Errorf("time %q is not a UTC timestamp", ts)
+	}
+}
+
+func TestJSONPlainMessage(t *testing.T) {
+	l, path := newTestLogger(t, FormatJSON)
+	l.logf(LevelInfo, "listening on %s", "/run/ggpamd.sock")
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(readLines(t, path)[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["event"] != EventMessage || rec["msg"] != "listening on /run/ggpamd.sock" {
+		t.Errorf("unexpected record %v", rec)
+	}
+}
+
+func TestTextFormatUnchanged(t *testing.T) {
+	l, path := newTestLogger(t, FormatText)
+	l.emit(LevelInfo, Event{Name: EventAuthSuccess, User: "alice"}, "user alice verified")
+	line := readLines(t, path)[0]
+	if !strings.HasSuffix(line, "[INFO] user alice verified") || strings.Contains(line, "auth_success") {
+		t.Errorf("unexpected text line %q", line)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for value, want := range map[string]Format{"": FormatText, "text": FormatText, "JSON": FormatJSON, " json ": FormatJSON, "xml": FormatText} {
+		if got := parseFormat(value); got != want {
+			t.Errorf("parseFormat(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Redacted 是被遮蔽内容在日志中的替代文本。
+const Redacted = "[REDACTED]"
+
+const (
+	// maxRegistered 是登记值的上限，长期运行的服务只保留最近的若干个。
+	maxRegistered = 64
+	// minRegistered 是登记值的最短长度，过短的值遮蔽后会误伤正常文本。
+	minRegistered = 4
+)
+
+// secretPattern 匹配 16 位以上的 Base32 串，即共享密钥。验证码与应急码
+// 不按模式匹配，以免误伤 UID、PID、日期等数字；它们在进入进程时登记。
+var secretPattern = regexp.MustCompile(`\b[A-Z2-7]{16,}=*`)
+
+var (
+	registryMu sync.Mutex
+	registry   []string
+)
+
+// Secret 包装不得出现在日志中的值。无论以何种动词格式化、编码为 JSON
+// 还是作为 slog 属性，都只输出 Redacted。
+type Secret string
+
+func (Secret) String() string   { return Redacted }
+func (Secret) GoString() string { return Redacted }
+
+func (Secret) Format(f fmt.State, _ rune) { io.WriteString(f, Redacted) }
+
+func (Secret) MarshalJSON() ([]byte, error) { return json.Marshal(Redacted) }
+
+func (Secret) LogValue() slog.Value { return slog.StringValue(Redacted) }
+
+// Register 登记运行时得到的秘密值（authtok、会话应答、RADIUS 密码、经
+// service 校验的验证码等），之后写出的日志中出现这些值时一律遮蔽。空值与
+// 过短的值被忽略。
+func Register(values ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minRegistered {
+			continue
+		}
+		registry = append(registry, v)
+	}
+	if n := len(registry) - maxRegistered; n > 0 {
+		registry = append(registry[:0:0], registry[n:]...)
+	}
+}
+
+// Redact 返回遮蔽后的 text：已登记的值与 16 位以上的 Base32 串均替换为
+// Redacted。写入文件、stderr、journald、syslog 与
+// pam_syslog 的文本都经过这里；审计记录不含自由文本，无需处理。
+func Redact(text string) string {
+	registryMu.Lock()
+	values := slices.Clone(registry)
+	registryMu.Unlock()
+	// 先替换较长的值，避免短值是长值的子串时留下长值的其余部分。
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	for _, v := range values {
+		text = strings.ReplaceAll(text, v, Redacted)
+	}
+	return secretPattern.ReplaceAllString(text, Redacted)
+}
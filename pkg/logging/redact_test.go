@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	plantedAuthtok = "hunter2-hunter2"
+	plantedCode    = "492039"
+	plantedScratch = "77345121"
+	plantedSecret  = "JBSWY3DPEHPK3PXPJBSWY3DP"
+)
+
+func resetRegistry(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		registryMu.Lock()
+		registry = nil
+		registryMu.Unlock()
+	})
+}
+
+// assertClean fails when any planted value survives in out.
+func assertClean(t *testing.T, where, out string, planted ...string) {
+	t.Helper()
+	for _, p := range planted {
+		if strings.Contains(out, p) {
+			t.Errorf("%s leaks %q:\n%s", where, p, out)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	resetRegistry(t)
+	Register(plantedAuthtok, "pw", "", plantedCode, plantedScratch)
+	cases := []struct{ in, want string }{
+		{"code " + plantedCode + " rejected", "code [REDACTED] rejected"},
+		{"scratch " + plantedScratch, "scratch [REDACTED]"},
+		{"authtok " + plantedAuthtok + plantedCode, "authtok [REDACTED][REDACTED]"},
+		{"otpauth://totp/alice?secret=" + plantedSecret + "&issuer=x", "otpauth://totp/alice?secret=[REDACTED]&issuer=x"},
+		// Values shorter than minRegistered, other numbers and ordinary
+		// text are left alone.
+		{"pw pid 12345 at 1700000000 uid 1000", "pw pid 12345 at 1700000000 uid 1000"},
+		// Numbers that merely look like codes survive.
+		{"ggpamd: uid 100001 denied verify for alice", "ggpamd: uid 100001 denied verify for alice"},
+		{"backup of 20240131 by pid 4194303", "backup of 20240131 by pid 4194303"},
+		{"PAM_AUTHTOK_ERR for alice", "PAM_AUTHTOK_ERR for alice"},
+	}
+	for _, c := range cases {
+		if got := Redact(c.in); got != c.want {
+			t.Errorf("Redact(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestRedactLongestFirst(t *testing.T) {
+	resetRegistry(t)
+	Register("hunter", "hunter2hunter2")
+	if got := Redact("typed hunter2hunter2"); got != "typed [REDACTED]" {
+		t.Fatalf("Redact = %q", got)
+	}
+}
+
+func TestRegisterIsBounded(t *testing.T) {
+	resetRegistry(t)
+	for i := range maxRegistered * 2 {
+		Register(fmt.Sprintf("value-%d", i))
+	}
+	registryMu.Lock()
+	n := len(registry)
+	registryMu.Unlock()
+	if n != maxRegistered {
+		t.Fatalf("registry holds %d values, want %d", n, maxRegistered)
+	}
+	if got := Redact("value-0 value-127"); got != "value-0 [REDACTED]" {
+		t.Fatalf("Redact = %q", got)
+	}
+}
+
+func TestSecretFormatting(t *testing.T) {
+	s := Secret(plantedAuthtok)
+	var out []string
+	for _, verb := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%d", "%10s"} {
+		out = append(out, fmt.Sprintf(verb, s))
+	}
+	data, _ := json.Marshal(map[string]any{"authtok": s})
+	out = append(out, string(data))
+	var buf strings.Builder
+	slog.New(slog.NewJSONHandler(&buf, nil)).Info("m", "authtok", s)
+	out = append(out, buf.String())
+	assertClean(t, "Secret", strings.Join(out, "\n"), plantedAuthtok)
+	if got := fmt.Sprint(s); got != Redacted {
+		t.Fatalf("Sprint = %q", got)
+	}
+}
+
+func TestEmitRedactsPlantedSecrets(t *testing.T) {
+	resetRegistry(t)
+	Register(plantedAuthtok, plantedCode, plantedScratch)
+	planted := []string{plantedAuthtok, plantedCode, plantedScratch, plantedSecret}
+	ev := Event{Name: EventAuthFailure, User: "alice"}.WithError(ClassInvalidCode,
+		errors.New("code "+plantedCode+" from "+plantedAuthtok+plantedCode))
+	text := fmt.Sprintf("user alice typed %s%s, scratch %s, secret %s", plantedAuthtok, plantedCode, plantedScratch, plantedSecret)
+
+	for _, format := range []Format{FormatText, FormatJSON} {
+		l, path := newTestLogger(t, format)
+		l.emit(LevelWarn, ev, text)
+		l.logf(LevelWarn, "debug: %v", ev.Err)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertClean(t, fmt.Sprintf("format %d", format), string(data), planted...)
+		if !strings.Contains(string(data), Redacted) {
+			t.Errorf("format %d: no %s marker:\n%s", format, Redacted, data)
+		}
+	}
+
+	conn, addr := listen(t, "unixgram")
+	l := newSinkLogger(t, "journald:"+addr)
+	l.emit(LevelWarn, ev, text)
+	assertClean(t, "journald", string(receive(t, conn)), planted...)
+}
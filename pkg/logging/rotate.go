@@ -0,0 +1,327 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 日志轮转相关的环境变量。
+const (
+	LogMaxSize  = "GGPAM_LOG_MAX_SIZE"
+	LogMaxAge   = "GGPAM_LOG_MAX_AGE"
+	LogKeep     = "GGPAM_LOG_KEEP"
+	LogCompress = "GGPAM_LOG_COMPRESS"
+)
+
+// 轮转的编译时默认值，可通过 -ldflags -X 覆盖。默认不轮转。
+var (
+	DefaultMaxSize  = ""
+	DefaultMaxAge   = ""
+	DefaultKeep     = "5"
+	DefaultCompress = "false"
+)
+
+// Rotation 描述日志文件的轮转策略。MaxSize 与 MaxAge 为 0 时不按该条件轮转；
+// Keep 为保留的旧文件数（name.1 最新），Compress 时旧文件以 gzip 压缩为 name.N.gz。
+type Rotation struct {
+	MaxSize  int64
+	MaxAge   time.Duration
+	Keep     int
+	Compress bool
+}
+
+func (r Rotation) enabled() bool {
+	return r.MaxSize > 0 || r.MaxAge > 0
+}
+
+func buildRotation() Rotation {
+	r := Rotation{}
+	if n, err := parseSize(firstNonEmpty(os.Getenv(LogMaxSize), DefaultMaxSize)); err == nil {
+		r.MaxSize = n
+	}
+	if d, err := parseAge(firstNonEmpty(os.Getenv(LogMaxAge), DefaultMaxAge)); err == nil {
+		r.MaxAge = d
+	}
+	if n, err := strconv.Atoi(firstNonEmpty(os.Getenv(LogKeep), DefaultKeep)); err == nil && n >= 0 {
+		r.Keep = n
+	}
+	r.Compress = isTruthy(firstNonEmpty(os.Getenv(LogCompress), DefaultCompress))
+	return r
+}
+
+// parseSize 解析字节数，支持 K/M/G 后缀（1024 进制），0 表示不限制。
+func parseSize(value string) (int64, error) {
+	value = strings.TrimSpace(strings.ToUpper(value))
+	if value == "" {
+		return 0, nil
+	}
+	shift := 0
+	switch {
+	case strings.HasSuffix(value, "K"):
+		shift = 10
+	case strings.HasSuffix(value, "M"):
+		shift = 20
+	case strings.HasSuffix(value, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n << shift, nil
+}
+
+// parseAge 解析秒数、"7d" 或 Go 时长，空值表示不按时间轮转。
+func parseAge(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// rotatingFile 是按 Rotation 轮转的日志文件。多个进程可同时追加同一文件：
+// 每次写入都持有 name.lock 上的 flock，先确认自己的描述符仍指向当前文件
+// （其他进程轮转后重新打开），再判断是否需要轮转。锁文件同时记录当前
+// 文件的创建时间，用于 MaxAge。轮转失败的提示写入 errs，为 nil 时丢弃。
+type rotatingFile struct {
+	mu     sync.Mutex
+	path   string
+	policy Rotation
+	now    func() time.Time
+	errs   io.Writer
+	lock   *os.File
+	file   *os.File
+}
+
+func openRotating(path string, policy Rotation, errs io.Writer) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, policy: policy, now: time.Now, errs: errs}
+	if policy.enabled() {
+		lock, err := openOwned(path+".lock", os.O_RDWR|os.O_CREATE)
+		if err != nil {
+			return nil, err
+		}
+		r.lock = lock
+	}
+	if err := r.open(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := openOwned(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file = f
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lock == nil {
+		return r.file.Write(p)
+	}
+	fd := int(r.lock.Fd())
+	if err := unix.Flock(fd, unix.LOCK_EX); err != nil {
+		return 0, err
+	}
+	defer unix.Flock(fd, unix.LOCK_UN)
+	if err := r.reopenIfMoved(); err != nil {
+		return 0, err
+	}
+	if r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			// 轮转失败时继续写入原文件，不丢日志。
+			if r.errs != nil {
+				fmt.Fprintf(r.errs, "ggpam: rotate %s: %v\n", r.path, err)
+			}
+		}
+	}
+	return r.file.Write(p)
+}
+
+// openOwned 以 O_NOFOLLOW 打开 path，并要求它是属于本进程有效用户、只有一个
+// 链接的普通文件。日志可能位于用户可写的 Home 目录而由 root 写入，这样用户
+// 无法借预先放置的符号链接、硬链接或 FIFO 让 root 追加、轮转或压缩其他文件。
+func openOwned(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag|unix.O_CLOEXEC|unix.O_NOFOLLOW|unix.O_NONBLOCK, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		f.Close()
+		return nil, err
+	}
+	switch {
+	case st.Mode&unix.S_IFMT != unix.S_IFREG:
+		err = fmt.Errorf("%s is not a regular file", path)
+	case int(st.Uid) != os.Geteuid():
+		err = fmt.Errorf("%s is owned by uid %d, not %d", path, st.Uid, os.Geteuid())
+	case st.Nlink > 1:
+		err = fmt.Errorf("%s has %d links", path, st.Nlink)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// reopenIfMoved 在当前路径已不是自己打开的文件时（被其他进程轮转或删除）重新打开。
+func (r *rotatingFile) reopenIfMoved() error {
+	var cur, open unix.Stat_t
+	if err := unix.Fstat(int(r.file.Fd()), &open); err != nil {
+		return err
+	}
+	if err := unix.Stat(r.path, &cur); err == nil && cur.Dev == open.Dev && cur.Ino == open.Ino {
+		return nil
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) due(next int64) bool {
+	info, err := r.file.Stat()
+	if err != nil {
+		return false
+	}
+	if r.policy.MaxSize > 0 && info.Size() > 0 && info.Size()+next > r.policy.MaxSize {
+		return true
+	}
+	if r.policy.MaxAge > 0 {
+		created, ok := r.created()
+		if !ok {
+			r.markCreated()
+			return false
+		}
+		return info.Size() > 0 && r.now().Sub(created) >= r.policy.MaxAge
+	}
+	return false
+}
+
+// created 返回锁文件中记录的当前文件创建时间。
+func (r *rotatingFile) created() (time.Time, bool) {
+	buf := make([]byte, 32)
+	n, _ := r.lock.ReadAt(buf, 0)
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(buf[:n])), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+func (r *rotatingFile) markCreated() {
+	data := strconv.FormatInt(r.now().Unix(), 10) + "\n"
+	if _, err := r.lock.WriteAt([]byte(data), 0); err == nil {
+		r.lock.Truncate(int64(len(data)))
+	}
+}
+
+// rotate 将 name.N 依次后移，当前文件改名为 name.1（需要时压缩），
+// 删除超出 Keep 的旧文件，然后重新打开 name。
+func (r *rotatingFile) rotate() error {
+	keep := r.policy.Keep
+	for _, suffix := range []string{"", ".gz"} {
+		os.Remove(r.rotated(keep, suffix))
+	}
+	for i := keep - 1; i >= 1; i-- {
+		for _, suffix := range []string{"", ".gz"} {
+			if err := os.Rename(r.rotated(i, suffix), r.rotated(i+1, suffix)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if keep == 0 {
+		if err := os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else if err := os.Rename(r.path, r.rotated(1, "")); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.markCreated()
+	if keep > 0 && r.policy.Compress {
+		return compressFile(r.rotated(1, ""))
+	}
+	return nil
+}
+
+func (r *rotatingFile) rotated(n int, suffix string) string {
+	return r.path + "." + strconv.Itoa(n) + suffix
+}
+
+// compressFile 将 path 压缩为 path.gz 并删除原文件。
+func compressFile(path string) error {
+	in, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (r *rotatingFile) Close() error {
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+	}
+	if r.lock != nil {
+		r.lock.Close()
+	}
+	return err
+}
@@ -0,0 +1,237 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeLines(t *testing.T, w io.Writer, prefix string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := fmt.Fprintf(w, "%s %04d\n", prefix, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// countLines returns the lines of path, reading gzip files transparently.
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		r = zr
+	}
+	n := 0
+	for s := bufio.NewScanner(r); s.Scan(); n++ {
+	}
+	return n
+}
+
+func logFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "ggpam.log*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".lock") {
+			names = append(names, filepath.Base(m))
+		}
+	}
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprint("compress=", compress), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "ggpam.log")
+			r, err := openRotating(path, Rotation{MaxSize: 100, Keep: 2, Compress: compress}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			// 10 bytes per line: every file holds 10 lines.
+			writeLines(t, r, "line", 45)
+
+			suffix := ""
+			if compress {
+				suffix = ".gz"
+			}
+			want := []string{"ggpam.log", "ggpam.log.1" + suffix, "ggpam.log.2" + suffix}
+			if got := logFiles(t, dir); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("files %v, want %v", got, want)
+			}
+			for name, lines := range map[string]int{want[0]: 5, want[1]: 10, want[2]: 10} {
+				if n := countLines(t, filepath.Join(dir, name)); n != lines {
+					t.Errorf("%s has %d lines, want %d", name, n, lines)
+				}
+			}
+			info, err := os.Stat(filepath.Join(dir, want[1]))
+			if err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("rotated file mode %v, %v", info.Mode(), err)
+			}
+		})
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ggpam.log")
+	now := time.Unix(1_700_000_000, 0)
+	r, err := openRotating(path, Rotation{MaxAge: 24 * time.Hour, Keep: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.now = func() time.Time { return now }
+	writeLines(t, r, "day1", 3)
+	now = now.Add(23 * time.Hour)
+	writeLines(t, r, "day1", 1)
+	if got := logFiles(t, dir); len(got) != 1 {
+		t.Fatalf("rotated too early: %v", got)
+	}
+	now = now.Add(2 * time.Hour)
+	writeLines(t, r, "day2", 2)
+	if got := logFiles(t, dir); fmt.Sprint(got) != "[ggpam.log ggpam.log.1]" {
+		t.Fatalf("files %v", got)
+	}
+	if n := countLines(t, path+".1"); n != 4 {
+		t.Fatalf("ggpam.log.1 has %d lines, want 4", n)
+	}
+	if n := countLines(t, path); n != 2 {
+		t.Fatalf("ggpam.log has %d lines, want 2", n)
+	}
+}
+
+func TestRotateKeepZero(t *testing.T) {
+	dir := t.TempDir()
+	r, err := openRotating(filepath.Join(dir, "ggpam.log"), Rotation{MaxSize: 50, Keep: 0}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	writeLines(t, r, "line", 12)
+	if got := logFiles(t, dir); fmt.Sprint(got) != "[ggpam.log]" {
+		t.Fatalf("files %v", got)
+	}
+}
+
+// TestRotateConcurrentWriters uses separate descriptors, as separate PAM
+// processes would, and checks that rotation loses no lines.
+func TestRotateConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ggpam.log")
+	const writers, lines = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		r, err := openRotating(path, Rotation{MaxSize: 1000, Keep: 100}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		wg.Add(1)
+		go func(w int, r *rotatingFile) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				fmt.Fprintf(r, "w%d %04d\n", w, i)
+			}
+		}(w, r)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, name := range logFiles(t, dir) {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1000 {
+			t.Errorf("%s has %d bytes, limit 1000", name, info.Size())
+		}
+		total += countLines(t, filepath.Join(dir, name))
+	}
+	if total != writers*lines {
+		t.Fatalf("%d lines across files, want %d", total, writers*lines)
+	}
+}
+
+func TestRotateRefusesPlantedFiles(t *testing.T) {
+	dir := t.TempDir()
+	victim := filepath.Join(dir, "victim")
+	if err := os.WriteFile(victim, []byte("keep\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policy := Rotation{MaxSize: 100, Keep: 1}
+	for name, plant := range map[string]func(path string) error{
+		"symlink":      func(path string) error { return os.Symlink(victim, path) },
+		"hard link":    func(path string) error { return os.Link(victim, path) },
+		"lock symlink": func(path string) error { return os.Symlink(victim, path+".lock") },
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".log")
+		if err := plant(path); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := openRotating(path, policy, nil); err == nil {
+			r.Close()
+			t.Errorf("%s: planted file accepted", name)
+		}
+	}
+	if data, err := os.ReadFile(victim); err != nil || string(data) != "keep\n" {
+		t.Fatalf("victim changed: %q, %v", data, err)
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("chown needs root")
+	}
+	path := filepath.Join(dir, "foreign.log")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(path, 12345, 12345); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := openRotating(path, Rotation{}, nil); err == nil {
+		r.Close()
+		t.Fatal("log file of another user accepted")
+	}
+}
+
+func TestRotationOffByDefault(t *testing.T) {
+	t.Setenv(LogMaxSize, "")
+	t.Setenv(LogMaxAge, "")
+	if r := buildRotation(); r.enabled() {
+		t.Fatalf("rotation enabled without configuration: %+v", r)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{"": 0, "0": 0, "512": 512, "4k": 4096, "10M": 10 << 20, "1G": 1 << 30} {
+		if got, err := parseSize(value); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"ten", "-1", "5T"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("parseSize(%q) accepted", value)
+		}
+	}
+}
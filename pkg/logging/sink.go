@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogSink 选择日志目标，取值见 parseSink。
+const LogSink = "GGPAM_LOG_SINK"
+
+const (
+	// DefaultJournalSocket 是 systemd-journald 原生协议的套接字。
+	DefaultJournalSocket = "/run/systemd/journal/socket"
+	// DefaultSyslogSocket 是本地 syslog 套接字。
+	DefaultSyslogSocket = "/dev/log"
+)
+
+// Ident 是 journald 与 syslog 记录中的程序名，为空时取 os.Args[0] 的文件名。
+var Ident = ""
+
+// sink 是文件与 stderr 之外的日志目标，每条事件作为一个数据报发送。
+type sink interface {
+	send(level Level, ev Event, text string, now time.Time) error
+	close() error
+}
+
+// parseSink 解析 GGPAM_LOG_SINK：
+//
+//	file（或空）            写入 GGPAM_LOG_FILE 与 stderr
+//	journald[:PATH]        systemd journal 原生协议，默认 DefaultJournalSocket
+//	syslog[:unix:PATH]     RFC 5424，Unix 数据报套接字，默认 DefaultSyslogSocket
+//	syslog:udp:HOST:PORT   RFC 5424，UDP
+//
+// 返回 nil 表示使用文件。
+func parseSink(spec string) (sink, error) {
+	spec = strings.TrimSpace(spec)
+	kind, rest, _ := strings.Cut(spec, ":")
+	switch strings.ToLower(kind) {
+	case "", "file":
+		return nil, nil
+	case "journald":
+		return newJournalSink(firstNonEmpty(rest, DefaultJournalSocket))
+	case "syslog":
+		network, addr, _ := strings.Cut(rest, ":")
+		switch network {
+		case "":
+			return newSyslogSink("unixgram", DefaultSyslogSocket)
+		case "unix":
+			return newSyslogSink("unixgram", firstNonEmpty(addr, DefaultSyslogSocket))
+		case "udp":
+			if addr == "" {
+				return nil, fmt.Errorf("%s: syslog:udp requires HOST:PORT", LogSink)
+			}
+			return newSyslogSink("udp", addr)
+		}
+	}
+	return nil, fmt.Errorf("%s: unknown sink %q", LogSink, spec)
+}
+
+// datagramSink 持有到目标的连接，发送失败时重新连接一次，以便 journald
+// 或 syslog 守护进程重启后继续工作。
+type datagramSink struct {
+	mu      sync.Mutex
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func dialSink(network, addr string) (*datagramSink, error) {
+	s := &datagramSink{network: network, addr: addr}
+	if err := s.dial(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *datagramSink) dial() error {
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("%s: connect %s %s: %w", LogSink, s.network, s.addr, err)
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *datagramSink) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write(data); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(data)
+	return err
+}
+
+func (s *datagramSink) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func ident() string {
+	if Ident != "" {
+		return Ident
+	}
+	return filepath.Base(os.Args[0])
+}
+
+// severity 返回 syslog 严重级别。
+func (l Level) severity() int {
+	switch l {
+	case LevelDebug:
+		return 7
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	default:
+		return 6
+	}
+}
+
+// fields 返回事件名与非空字段，键名与 JSON 格式一致；错误文本经过 Redact。
+func (e Event) fields() [][2]string {
+	name := e.Name
+	if name == "" {
+		name = EventMessage
+	}
+	fields := [][2]string{{"event", name}}
+	for _, f := range [][2]string{
+		{"user", e.User},
+		{"rhost", e.Rhost},
+		{"service", e.Service},
+		{"result", e.Result},
+		{"error_class", e.ErrorClass},
+	} {
+		if f[1] != "" {
+			fields = append(fields, f)
+		}
+	}
+	if e.Err != nil {
+		fields = append(fields, [2]string{"error", Redact(e.Err.Error())})
+	}
+	return fields
+}
@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ggpam/pkg/version"
+)
+
+// listen opens a datagram socket standing in for journald or syslog.
+func listen(t *testing.T, network string) (net.PacketConn, string) {
+	t.Helper()
+	addr := "127.0.0.1:0"
+	if network == "unixgram" {
+		addr = filepath.Join(t.TempDir(), "sock")
+	}
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		t.Fatalf("listen %s: %v", network, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.LocalAddr().String()
+}
+
+func receive(t *testing.T, conn net.PacketConn) []byte {
+	t.Helper()
+	buf := make([]byte, 64<<10)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return buf[:n]
+}
+
+// parseJournal decodes the native protocol, including binary-safe fields.
+func parseJournal(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field %q", data)
+		}
+		line := string(data[:nl])
+		data = data[nl+1:]
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = value
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data[:8])
+		fields[line] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func newSinkLogger(t *testing.T, spec string) *logger {
+	t.Helper()
+	l := newLogger()
+	if err := l.configure(Config{Level: LevelInfo, Sink: spec}); err != nil {
+		t.Fatalf("configure %q: %v", spec, err)
+	}
+	t.Cleanup(func() { l.configure(Config{Level: LevelInfo}) })
+	return l
+}
+
+func TestJournalSink(t *testing.T) {
+	conn, path := listen(t, "unixgram")
+	l := newSinkLogger(t, "journald:"+path)
+	ev := Event{Name: EventAuthFailure, User: "alice", Rhost: "192.0.2.1", Service: "sshd", ErrorClass: ClassInvalidCode, Err: errors.New("bad\ncode")}
+	l.emit(LevelWarn, ev, "verification failed")
+
+	fields := parseJournal(t, receive(t, conn))
+	want := map[string]string{
+		"MESSAGE":           "verification failed",
+		"PRIORITY":          "4",
+		"SYSLOG_FACILITY":   "10",
+		"SYSLOG_IDENTIFIER": ident(),
+		"GGPAM_EVENT":       EventAuthFailure,
+		"GGPAM_USER":        "alice",
+		"GGPAM_RHOST":       "192.0.2.1",
+		"GGPAM_SERVICE":     "sshd",
+		"GGPAM_ERROR_CLASS": ClassInvalidCode,
+		"GGPAM_ERROR":       "bad\ncode",
+		"GGPAM_VERSION":     version.Version,
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("%s = %q, want %q", key, fields[key], value)
+		}
+	}
+	if _, ok := fields["GGPAM_RESULT"]; ok {
+		t.Error("empty result should be omitted")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	for _, network := range []string{"unixgram", "udp"} {
+		t.Run(network, func(t *testing.T) {
+			conn, addr := listen(t, network)
+			spec := "syslog:unix:" + addr
+			if network == "udp" {
+				spec = "syslog:udp:" + addr
+			}
+			l := newSinkLogger(t, spec)
+			l.emit(LevelInfo, Event{Name: EventAuthSuccess, User: `a"l]i\ce`, Result: "totp"}, "user verified")
+			l.emit(LevelDebug, Event{Name: EventAuthSuccess}, "below the level")
+
+			msg := string(receive(t, conn))
+			parts := strings.SplitN(msg, " ", 7)
+			if len(parts) != 7 {
+				t.Fatalf("malformed message %q", msg)
+			}
+			if parts[0] != "<86>1" {
+				t.Errorf("PRI/VERSION %q, want <86>1", parts[0])
+			}
+			if _, err := time.Parse(time.RFC3339Nano, parts[1]); err != nil || !strings.HasSuffix(parts[1], "Z") {
+				t.Errorf("timestamp %q", parts[1])
+			}
+			if parts[3] != ident() || parts[5] != EventAuthSuccess {
+				t.Errorf("APP-NAME %q MSGID %q", parts[3], parts[5])
+			}
+			wantSD := `[ggpam@32473 user="a\"l\]i\\ce" result="totp" version="` + version.Version + `"] user verified`
+			if parts[6] != wantSD {
+				t.Errorf("SD and MSG %q, want %q", parts[6], wantSD)
+			}
+		})
+	}
+}
+
+func TestSinkReconnects(t *testing.T) {
+	conn, path := listen(t, "unixgram")
+	l := newSinkLogger(t, "journald:"+path)
+	conn.Close()
+	os.Remove(path)
+	l.emit(LevelInfo, Event{}, "lost")
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	l.emit(LevelInfo, Event{}, "after restart")
+	if got := parseJournal(t, receive(t, conn))["MESSAGE"]; got != "after restart" {
+		t.Fatalf("MESSAGE = %q", got)
+	}
+}
+
+func TestParseSink(t *testing.T) {
+	for _, spec := range []string{"", "file", "FILE"} {
+		if s, err := parseSink(spec); s != nil || err != nil {
+			t.Errorf("parseSink(%q) = %v, %v", spec, s, err)
+		}
+	}
+	for _, spec := range []string{"kafka", "syslog:tcp:host:514", "syslog:udp"} {
+		if _, err := parseSink(spec); err == nil {
+			t.Errorf("parseSink(%q) accepted", spec)
+		}
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	l := newLogger()
+	if err := l.configure(Config{Level: LevelInfo, Sink: "journald:" + missing}); err == nil {
+		t.Fatal("missing socket accepted")
+	}
+	if l.sink != nil {
+		t.Fatal("unusable sink kept")
+	}
+}
@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"ggpam/pkg/version"
+)
+
+// facilityAuthpriv 是 LOG_AUTHPRIV，与 pam_syslog 使用的设施一致。
+const facilityAuthpriv = 10
+
+// syslogSDID 是结构化数据元素名。32473 为 RFC 5424 文档用的企业号，
+// 仅用于区分本模块的字段。
+const syslogSDID = "ggpam@32473"
+
+// syslogSink 以 RFC 5424 格式发送记录：
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [ggpam@32473 ...] MSG
+//
+// MSGID 为事件名，结构化数据包含事件字段与版本。
+type syslogSink struct {
+	*datagramSink
+	hostname string
+}
+
+func newSyslogSink(network, addr string) (sink, error) {
+	s, err := dialSink(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+	return syslogSink{datagramSink: s, hostname: host}, nil
+}
+
+func (s syslogSink) send(level Level, ev Event, text string, now time.Time) error {
+	return s.write([]byte(syslogMessage(level, ev, text, now, s.hostname)))
+}
+
+func syslogMessage(level Level, ev Event, text string, now time.Time, hostname string) string {
+	fields := ev.fields()
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	for _, f := range append(fields[1:], [2]string{"version", version.Version}) {
+		fmt.Fprintf(&sd, ` %s="%s"`, f[0], sdEscape(f[1]))
+	}
+	sd.WriteString("]")
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		facilityAuthpriv*8+level.severity(),
+		now.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogToken(hostname, 255),
+		syslogToken(ident(), 48),
+		os.Getpid(),
+		syslogToken(fields[0][1], 32),
+		sd.String(),
+		text)
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func sdEscape(value string) string {
+	return sdEscaper.Replace(value)
+}
+
+// syslogToken 将 HOSTNAME/APP-NAME/MSGID 限制为可打印 ASCII 且不超过 max 字节。
+func syslogToken(value string, max int) string {
+	b := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(b) < max; i++ {
+		if c := value[i]; c > 32 && c < 127 {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
@@ -0,0 +1,157 @@
+// Package metrics counts verification outcomes for Prometheus. PAM
+// modules live for a single login, so observations are kept in memory and
+// Flush adds them to a textfile in the text exposition format; the file
+// accumulates the counters of every process on the host and is exported by
+// node_exporter's textfile collector or "ggpam metrics --listen".
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/logging"
+)
+
+// latencyBuckets are the upper bounds in seconds of the verify latency
+// histogram. Local verification takes microseconds; the upper buckets are
+// for state stores and ggpamd round trips.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// Snapshot holds counter values, either the observations of this process
+// since the last Flush or the totals read from a textfile.
+type Snapshot struct {
+	// Verifications counts successful verifications by result type.
+	Verifications map[string]float64
+	// Failures counts failed verifications by error class.
+	Failures      map[string]float64
+	RateLimitHits float64
+	SkewResets    float64
+	ScratchUsed   float64
+	GraceBypasses float64
+	Latency       Histogram
+}
+
+// Histogram is a cumulative histogram over latencyBuckets.
+type Histogram struct {
+	Buckets []float64
+	Count   float64
+	Sum     float64
+}
+
+func (h *Histogram) observe(seconds float64) {
+	if h.Buckets == nil {
+		h.Buckets = make([]float64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+func (h *Histogram) add(o Histogram) {
+	if h.Buckets == nil {
+		h.Buckets = make([]float64, len(latencyBuckets))
+	}
+	for i := range min(len(h.Buckets), len(o.Buckets)) {
+		h.Buckets[i] += o.Buckets[i]
+	}
+	h.Count += o.Count
+	h.Sum += o.Sum
+}
+
+func (s *Snapshot) add(o Snapshot) {
+	s.Verifications = addLabeled(s.Verifications, o.Verifications)
+	s.Failures = addLabeled(s.Failures, o.Failures)
+	s.RateLimitHits += o.RateLimitHits
+	s.SkewResets += o.SkewResets
+	s.ScratchUsed += o.ScratchUsed
+	s.GraceBypasses += o.GraceBypasses
+	s.Latency.add(o.Latency)
+}
+
+func addLabeled(dst, src map[string]float64) map[string]float64 {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]float64, len(src))
+	}
+	for k, v := range src {
+		dst[k] += v
+	}
+	return dst
+}
+
+func (s Snapshot) empty() bool {
+	return len(s.Verifications) == 0 && len(s.Failures) == 0 && s.GraceBypasses == 0 && s.Latency.Count == 0
+}
+
+var (
+	mu       sync.Mutex
+	pending  Snapshot
+	textfile string
+)
+
+// Configure sets the textfile Flush writes to; an empty path disables it.
+func Configure(path string) {
+	mu.Lock()
+	textfile = path
+	mu.Unlock()
+}
+
+// Verified records a successful verification that took elapsed.
+func Verified(res authenticator.Result, elapsed time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	pending.Verifications = addLabeled(pending.Verifications, map[string]float64{string(res.Type): 1})
+	if res.Type == authenticator.ResultScratch {
+		pending.ScratchUsed++
+	}
+	if res.SkewReset {
+		pending.SkewResets++
+	}
+	pending.Latency.observe(elapsed.Seconds())
+}
+
+// Failed records a rejected verification; class is the error_class of the
+// matching log event, such as logging.ClassInvalidCode.
+func Failed(class string, elapsed time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	pending.Failures = addLabeled(pending.Failures, map[string]float64{class: 1})
+	if class == logging.ClassRateLimited {
+		pending.RateLimitHits++
+	}
+	pending.Latency.observe(elapsed.Seconds())
+}
+
+// GraceBypass records a login that skipped the code under grace_period.
+func GraceBypass() {
+	mu.Lock()
+	defer mu.Unlock()
+	pending.GraceBypasses++
+}
+
+// Flush adds the observations since the last Flush to the configured
+// textfile. Without a textfile the observations are dropped. On failure
+// they are kept for the next Flush.
+func Flush() error {
+	mu.Lock()
+	path, delta := textfile, pending
+	pending = Snapshot{}
+	mu.Unlock()
+	if path == "" || delta.empty() {
+		return nil
+	}
+	if err := mergeTextfile(path, delta); err != nil {
+		mu.Lock()
+		pending.add(delta)
+		mu.Unlock()
+		return err
+	}
+	return nil
+}
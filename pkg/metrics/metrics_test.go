@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/logging"
+)
+
+func configureTest(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "textfile", "ggpam.prom")
+	Configure(path)
+	t.Cleanup(func() {
+		Configure("")
+		mu.Lock()
+		pending = Snapshot{}
+		mu.Unlock()
+	})
+	return path
+}
+
+func TestFlushAccumulates(t *testing.T) {
+	path := configureTest(t)
+	Verified(authenticator.Result{Type: authenticator.ResultTOTP}, 2*time.Millisecond)
+	Verified(authenticator.Result{Type: authenticator.ResultScratch}, 20*time.Millisecond)
+	Failed(logging.ClassRateLimited, time.Millisecond)
+	Failed(logging.ClassInvalidCode, 3*time.Second)
+	GraceBypass()
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	Verified(authenticator.Result{Type: authenticator.ResultTOTP, SkewReset: true}, 2*time.Millisecond)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing new: the file is left alone.
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`ggpam_verifications_total{result="scratch"} 1`,
+		`ggpam_verifications_total{result="totp"} 2`,
+		`ggpam_verification_failures_total{reason="invalid_code"} 1`,
+		`ggpam_verification_failures_total{reason="rate_limited"} 1`,
+		`ggpam_rate_limit_hits_total 1`,
+		`ggpam_skew_resets_total 1`,
+		`ggpam_scratch_codes_used_total 1`,
+		`ggpam_grace_bypasses_total 1`,
+		`ggpam_verify_duration_seconds_bucket{le="0.001"} 1`,
+		`ggpam_verify_duration_seconds_bucket{le="0.005"} 3`,
+		`ggpam_verify_duration_seconds_bucket{le="0.025"} 4`,
+		`ggpam_verify_duration_seconds_bucket{le="5"} 5`,
+		`ggpam_verify_duration_seconds_bucket{le="+Inf"} 5`,
+		`ggpam_verify_duration_seconds_count 5`,
+		`# TYPE ggpam_verify_duration_seconds histogram`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("textfile lacks %q:\n%s", line, data)
+		}
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestParseTextRoundTrip(t *testing.T) {
+	var want Snapshot
+	want.Verifications = map[string]float64{"totp": 3, "hotp": 1}
+	want.Failures = map[string]float64{"code_reused": 2}
+	want.SkewResets = 4
+	want.Latency.observe(0.2)
+	want.Latency.observe(7)
+	var b strings.Builder
+	if err := want.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseText(strings.NewReader(b.String() + "garbage line\nggpam_skew_resets_total not-a-number\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again strings.Builder
+	got.WriteText(&again)
+	if again.String() != b.String() {
+		t.Fatalf("round trip changed the file:\n%s\nwant\n%s", again.String(), b.String())
+	}
+}
+
+func TestFlushConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ggpam.prom")
+	const writers, rounds = 8, 10
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				delta := Snapshot{Verifications: map[string]float64{"totp": 1}}
+				delta.Latency.observe(0.01)
+				if err := mergeTextfile(path, delta); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	s, err := ReadTextfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Verifications["totp"] != writers*rounds || s.Latency.Count != writers*rounds {
+		t.Fatalf("lost updates: %+v", s)
+	}
+}
+
+func TestFlushKeepsObservationsOnError(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configureTest(t)
+	Configure(filepath.Join(blocker, "ggpam.prom"))
+	GraceBypass()
+	if err := Flush(); err == nil {
+		t.Fatal("Flush into a file as directory succeeded")
+	}
+	path := filepath.Join(dir, "ggpam.prom")
+	Configure(path)
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := ReadTextfile(path); s.GraceBypasses != 1 {
+		t.Fatalf("observation lost after a failed Flush: %+v", s)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ggpam.prom")
+	srv := httptest.NewServer(Handler(path))
+	defer srv.Close()
+	get := func() string {
+		t.Helper()
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	if body := get(); !strings.Contains(body, "ggpam_grace_bypasses_total 0\n") {
+		t.Fatalf("missing textfile should serve zeros:\n%s", body)
+	}
+	if err := mergeTextfile(path, Snapshot{Failures: map[string]float64{"not_enrolled": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%s{reason=%q} 2\n", metricFailures, "not_enrolled")
+	if body := get(); !strings.Contains(body, want) {
+		t.Fatalf("body lacks %q:\n%s", want, body)
+	}
+}
@@ -0,0 +1,221 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultTextfile is the textfile "ggpam metrics" reads unless --file is
+// given, inside the usual node_exporter textfile collector directory.
+const DefaultTextfile = "/var/lib/node_exporter/textfile_collector/ggpam.prom"
+
+// ContentType is the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+const (
+	metricVerifications = "ggpam_verifications_total"
+	metricFailures      = "ggpam_verification_failures_total"
+	metricRateLimitHits = "ggpam_rate_limit_hits_total"
+	metricSkewResets    = "ggpam_skew_resets_total"
+	metricScratchUsed   = "ggpam_scratch_codes_used_total"
+	metricGrace         = "ggpam_grace_bypasses_total"
+	metricLatency       = "ggpam_verify_duration_seconds"
+)
+
+// WriteText writes s in the Prometheus text exposition format. Unlabeled
+// counters are always present so that rates work from the first scrape.
+func (s Snapshot) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	labeled := func(name, label string, values map[string]float64) {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s{%s=%q} %s\n", name, label, k, formatValue(values[k]))
+		}
+	}
+	header(metricVerifications, "counter", "Successful verifications by result type.")
+	labeled(metricVerifications, "result", s.Verifications)
+	header(metricFailures, "counter", "Rejected verifications by reason.")
+	labeled(metricFailures, "reason", s.Failures)
+	for _, c := range []struct {
+		name, help string
+		value      float64
+	}{
+		{metricRateLimitHits, "Verifications refused by RATE_LIMIT.", s.RateLimitHits},
+		{metricSkewResets, "Codes that matched after the TOTP time skew was re-learned.", s.SkewResets},
+		{metricScratchUsed, "Scratch codes consumed.", s.ScratchUsed},
+		{metricGrace, "Logins that skipped the code under grace_period.", s.GraceBypasses},
+	} {
+		header(c.name, "counter", c.help)
+		fmt.Fprintf(&b, "%s %s\n", c.name, formatValue(c.value))
+	}
+	header(metricLatency, "histogram", "Time spent verifying a code.")
+	for i, le := range latencyBuckets {
+		var v float64
+		if i < len(s.Latency.Buckets) {
+			v = s.Latency.Buckets[i]
+		}
+		fmt.Fprintf(&b, "%s_bucket{le=%q} %s\n", metricLatency, formatValue(le), formatValue(v))
+	}
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %s\n", metricLatency, formatValue(s.Latency.Count))
+	fmt.Fprintf(&b, "%s_sum %s\n", metricLatency, formatValue(s.Latency.Sum))
+	fmt.Fprintf(&b, "%s_count %s\n", metricLatency, formatValue(s.Latency.Count))
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ParseText reads the series written by WriteText. Comments, unknown
+// series and malformed lines are skipped, so a damaged file restarts the
+// affected counters, which Prometheus treats as a counter reset.
+func ParseText(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		series, raw, ok := cutLast(line, " ")
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		name, label := series, ""
+		if i := strings.IndexByte(series, '{'); i >= 0 && strings.HasSuffix(series, "}") {
+			name = series[:i]
+			_, quoted, _ := strings.Cut(series[i+1:len(series)-1], "=")
+			if label, err = strconv.Unquote(quoted); err != nil {
+				continue
+			}
+		}
+		switch name {
+		case metricVerifications:
+			s.Verifications = addLabeled(s.Verifications, map[string]float64{label: value})
+		case metricFailures:
+			s.Failures = addLabeled(s.Failures, map[string]float64{label: value})
+		case metricRateLimitHits:
+			s.RateLimitHits = value
+		case metricSkewResets:
+			s.SkewResets = value
+		case metricScratchUsed:
+			s.ScratchUsed = value
+		case metricGrace:
+			s.GraceBypasses = value
+		case metricLatency + "_bucket":
+			le, err := strconv.ParseFloat(label, 64)
+			if err != nil {
+				continue
+			}
+			if i := slices.Index(latencyBuckets, le); i >= 0 {
+				if s.Latency.Buckets == nil {
+					s.Latency.Buckets = make([]float64, len(latencyBuckets))
+				}
+				s.Latency.Buckets[i] = value
+			}
+		case metricLatency + "_sum":
+			s.Latency.Sum = value
+		case metricLatency + "_count":
+			s.Latency.Count = value
+		}
+	}
+	return s, scanner.Err()
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// ReadTextfile returns the totals in path; a missing file has all zeros.
+func ReadTextfile(path string) (Snapshot, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, nil
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer f.Close()
+	return ParseText(f)
+}
+
+// mergeTextfile adds delta to the totals in path. Writers serialize on
+// flock of path.lock and replace path by rename, so the textfile collector
+// never reads a partial file.
+func mergeTextfile(path string, delta Snapshot) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create metrics directory: %w", err)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("lock metrics textfile: %w", err)
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	total, err := ReadTextfile(path)
+	if err != nil {
+		return err
+	}
+	total.add(delta)
+	// node_exporter only reads *.prom, so the temporary file is ignored.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0o644)
+	if err != nil {
+		return err
+	}
+	err = total.WriteText(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write metrics textfile: %w", err)
+	}
+	return nil
+}
+
+// Handler serves the totals of the textfile at path on every request.
+func Handler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := ReadTextfile(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		s.WriteText(w)
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"golang.org/x/sys/unix"
 
 	"ggpam/pkg/config"
+	"ggpam/pkg/util"
 )
 
 type FileState struct {
@@ -32,6 +33,9 @@ func ResolveSecretPath(spec string, account *user.User) (string, error) {
 	if spec == "" {
 		return filepath.Join(home, ".google_authenticator"), nil
 	}
+	if strings.Contains(spec, "%u") && !util.SafeFilename(username) {
+		return "", fmt.Errorf("user name %q cannot be used in secret path %s", username, spec)
+	}
 	path := strings.ReplaceAll(spec, "%u", username)
 	path = strings.ReplaceAll(path, "%h", home)
 	if strings.HasPrefix(path, "~") {
@@ -40,6 +44,21 @@ func ResolveSecretPath(spec string, account *user.User) (string, error) {
 	return os.ExpandEnv(path), nil
 }
 
+// SecretOwnerAccount returns the account used to access the secret file.
+// With secret_owner= the files live in a central store owned by that system
+// user, so the target user can neither read nor tamper with them; otherwise
+// the target account owns its own secret.
+func SecretOwnerAccount(params Params, account *user.User) (*user.User, error) {
+	if params.SecretOwner == "" {
+		return account, nil
+	}
+	owner, err := user.Lookup(params.SecretOwner)
+	if err != nil {
+		return nil, fmt.Errorf("lookup secret_owner %s: %w", params.SecretOwner, err)
+	}
+	return owner, nil
+}
+
 func validateSecretFile(path string, info os.FileInfo, account *user.User, params Params) error {
 	if info.IsDir() {
 		return fmt.Errorf("secret file %s is a directory", path)
@@ -96,13 +115,20 @@ func LoadConfig(account *user.User, path string, params Params) (*config.Config,
 }
 
 func WriteConfig(account *user.User, path string, data []byte, perm os.FileMode, expected FileState) error {
+	create := false
 	info, err := os.Lstat(path)
-	if err != nil {
+	switch {
+	case err == nil:
+		if !expected.isZero() && !expected.matches(info) {
+			return ErrSecretModified
+		}
+	case errors.Is(err, os.ErrNotExist) && expected.isZero():
+		// No prior state: create the file, but never replace one that
+		// appeared concurrently.
+		create = true
+	default:
 		return fmt.Errorf("stat secret file %s: %w", path, err)
 	}
-	if !expected.isZero() && !expected.matches(info) {
-		return ErrSecretModified
-	}
 	dir := filepath.Dir(path)
 	tmp, err := os.CreateTemp(dir, ".ga-*")
 	if err != nil {
@@ -114,6 +140,10 @@ func WriteConfig(account *user.User, path string, data []byte, perm os.FileMode,
 		tmp.Close()
 		return fmt.Errorf("chmod temp file %s: %w", tmpName, err)
 	}
+	if err := chownToAccount(tmp, account); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chown temp file %s: %w", tmpName, err)
+	}
 	if _, err := tmp.Write(data); err != nil {
 		tmp.Close()
 		return fmt.Errorf("write temp file %s: %w", tmpName, err)
@@ -125,7 +155,14 @@ func WriteConfig(account *user.User, path string, data []byte, perm os.FileMode,
 	if err := tmp.Close(); err != nil {
 		return fmt.Errorf("close temp file %s: %w", tmpName, err)
 	}
-	if err := os.Rename(tmpName, path); err != nil {
+	if create {
+		if err := os.Link(tmpName, path); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return ErrSecretModified
+			}
+			return fmt.Errorf("link temp file %s to %s: %w", tmpName, path, err)
+		}
+	} else if err := os.Rename(tmpName, path); err != nil {
 		return fmt.Errorf("rename temp file %s to %s: %w", tmpName, path, err)
 	}
 	if dirFile, err := os.Open(dir); err == nil {
@@ -139,6 +176,34 @@ func WriteConfig(account *user.User, path string, data []byte, perm os.FileMode,
 	return nil
 }
 
+// chownToAccount hands a freshly created file to account when running as
+// root, e.g. inside ggpamd; with dropped privileges the file already belongs
+// to the effective user.
+func chownToAccount(f *os.File, account *user.User) error {
+	if account == nil || os.Geteuid() != 0 {
+		return nil
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return fmt.Errorf("parse user UID %q: %w", account.Uid, err)
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return fmt.Errorf("parse user GID %q: %w", account.Gid, err)
+	}
+	return f.Chown(uid, gid)
+}
+
+// CurrentFileState returns the state of path for comparison with the state
+// captured by LoadConfig, e.g. to decide whether a cached config is stale.
+func CurrentFileState(path string) (FileState, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return FileState{}, err
+	}
+	return newFileState(info), nil
+}
+
 func openLocked(path string, flags int, perm os.FileMode) (*os.File, error) {
 	fd, err := unix.Open(path, flags|unix.O_CLOEXEC|unix.O_NOFOLLOW, uint32(perm))
 	if err != nil {
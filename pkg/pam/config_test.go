@@ -0,0 +1,78 @@
+package pam
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteConfigCreatesMissingFile(t *testing.T) {
+	account, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), ".google_authenticator")
+	if err := WriteConfig(account, path, []byte("JBSWY3DPEHPK3PXP\n\" TOTP_AUTH\n"), 0o600, FileState{}); err != nil {
+		t.Fatalf("create secret: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat secret: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected mode %04o", info.Mode().Perm())
+	}
+	cfg, state, err := LoadConfig(account, path, DefaultParams())
+	if err != nil {
+		t.Fatalf("load created secret: %v", err)
+	}
+	if cfg.Secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("unexpected secret %q", cfg.Secret)
+	}
+	if err := os.WriteFile(path, []byte("OTHERSECRET\n"), 0o600); err != nil {
+		t.Fatalf("modify secret: %v", err)
+	}
+	if err := WriteConfig(account, path, []byte("JBSWY3DPEHPK3PXP\n"), 0o600, state); !errors.Is(err, ErrSecretModified) {
+		t.Fatalf("expected ErrSecretModified, got %v", err)
+	}
+}
+
+func TestWriteConfigRequiresExistingFileWithState(t *testing.T) {
+	account, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), ".google_authenticator")
+	err = WriteConfig(account, path, []byte("JBSWY3DPEHPK3PXP\n"), 0o600, FileState{Dev: 1, Ino: 1})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestSecretOwnerAccount(t *testing.T) {
+	account := &user.User{Username: "alice", Uid: "1000", Gid: "1000", HomeDir: "/home/alice"}
+	owner, err := SecretOwnerAccount(DefaultParams(), account)
+	if err != nil || owner != account {
+		t.Fatalf("without secret_owner the target account owns the secret: %v %v", owner, err)
+	}
+	params, err := ParseParams([]string{"secret=/var/lib/ggpam/%u", "secret_owner=root"})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	owner, err = SecretOwnerAccount(params, account)
+	if err != nil || owner.Uid != "0" {
+		t.Fatalf("expected root owner, got %v err=%v", owner, err)
+	}
+	path, err := ResolveSecretPath(params.SecretSpec, account)
+	if err != nil || path != "/var/lib/ggpam/alice" {
+		t.Fatalf("path = %q, err=%v", path, err)
+	}
+	if _, err := ResolveSecretPath(params.SecretSpec, &user.User{Username: "../root"}); err == nil {
+		t.Fatal("expected error for path-like user name")
+	}
+	if _, err := ParseParams([]string{"secret_owner="}); err == nil {
+		t.Fatal("expected error for empty secret_owner")
+	}
+}
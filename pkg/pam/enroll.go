@@ -0,0 +1,74 @@
+package pam
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ggpam/pkg/util"
+)
+
+const DefaultEnrollStateDir = "/var/lib/ggpam/enroll"
+
+// EnrollmentEnforced reports whether users without a secret get a grace
+// period from pam_sm_acct_mgmt instead of the all-or-nothing nullok decision.
+func (p Params) EnrollmentEnforced() bool {
+	return p.EnrollGrace > 0
+}
+
+// EnrollmentDeadline returns the time by which user must have enrolled.
+// Without enroll_since the grace period starts at the first login, which is
+// recorded in EnrollStateDir the first time the user is seen.
+func EnrollmentDeadline(params Params, username string, now time.Time) (time.Time, error) {
+	if !params.EnrollmentEnforced() {
+		return time.Time{}, errors.New("enrollment grace period is not configured")
+	}
+	start := params.EnrollSince
+	if start.IsZero() {
+		first, err := RecordFirstLogin(params.EnrollStateDir, username, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		start = first
+	}
+	return start.Add(params.EnrollGrace), nil
+}
+
+// RecordFirstLogin stores now as the first login of username unless a record
+// already exists, and returns the recorded time.
+func RecordFirstLogin(dir, username string, now time.Time) (time.Time, error) {
+	if !util.SafeFilename(username) {
+		return time.Time{}, fmt.Errorf("invalid username %q", username)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return time.Time{}, fmt.Errorf("create enroll state dir %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, username)
+	fd, err := unix.Open(path, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_CLOEXEC|unix.O_NOFOLLOW, 0o600)
+	if err == nil {
+		f := os.NewFile(uintptr(fd), path)
+		defer f.Close()
+		if _, err := fmt.Fprintf(f, "%d\n", now.Unix()); err != nil {
+			return time.Time{}, fmt.Errorf("write enroll state %s: %w", path, err)
+		}
+		return time.Unix(now.Unix(), 0), nil
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return time.Time{}, fmt.Errorf("create enroll state %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read enroll state %s: %w", path, err)
+	}
+	ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse enroll state %s: %w", path, err)
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -0,0 +1,72 @@
+package pam
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnrollmentDeadlineFromFirstLogin(t *testing.T) {
+	params, err := ParseParams([]string{"enroll_grace=14d", "enroll_state=" + t.TempDir()})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	first := time.Unix(1_700_000_000, 0)
+	deadline, err := EnrollmentDeadline(params, "alice", first)
+	if err != nil {
+		t.Fatalf("deadline: %v", err)
+	}
+	if want := first.Add(14 * 24 * time.Hour); !deadline.Equal(want) {
+		t.Fatalf("deadline = %v, want %v", deadline, want)
+	}
+	later, err := EnrollmentDeadline(params, "alice", first.Add(72*time.Hour))
+	if err != nil {
+		t.Fatalf("deadline on later login: %v", err)
+	}
+	if !later.Equal(deadline) {
+		t.Fatalf("grace must be measured from the first login: %v != %v", later, deadline)
+	}
+	if _, err := os.Stat(filepath.Join(params.EnrollStateDir, "alice")); err != nil {
+		t.Fatalf("first login not recorded: %v", err)
+	}
+}
+
+func TestEnrollmentDeadlineFromAdminDate(t *testing.T) {
+	dir := t.TempDir()
+	params, err := ParseParams([]string{"enroll_grace=36h", "enroll_since=2026-01-10T00:00:00Z", "enroll_state=" + dir})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	deadline, err := EnrollmentDeadline(params, "bob", time.Unix(1_800_000_000, 0))
+	if err != nil {
+		t.Fatalf("deadline: %v", err)
+	}
+	if want := time.Date(2026, 1, 11, 12, 0, 0, 0, time.UTC); !deadline.Equal(want) {
+		t.Fatalf("deadline = %v, want %v", deadline, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bob")); !os.IsNotExist(err) {
+		t.Fatalf("admin date must not record first login, stat err=%v", err)
+	}
+}
+
+func TestEnrollmentParams(t *testing.T) {
+	for _, args := range [][]string{
+		{"enroll_grace=0"},
+		{"enroll_grace=soon"},
+		{"enroll_since=2026-01-01"},
+		{"enroll_grace=1d", "enroll_since=tomorrow"},
+		{"enroll_grace=1d", "enroll_state="},
+	} {
+		if _, err := ParseParams(args); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+	params, err := ParseParams([]string{"enroll_grace=3600"})
+	if err != nil || params.EnrollGrace != time.Hour || params.EnrollStateDir != DefaultEnrollStateDir {
+		t.Fatalf("unexpected params %+v err=%v", params, err)
+	}
+	if _, err := RecordFirstLogin(t.TempDir(), "../root", time.Now()); err == nil {
+		t.Fatal("expected error for path-like username")
+	}
+}
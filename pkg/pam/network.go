@@ -0,0 +1,127 @@
+package pam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Resolver turns a PAM_RHOST hostname into addresses; *net.Resolver satisfies it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type NetworkDecision int
+
+const (
+	NetworkDefault NetworkDecision = iota
+	NetworkTrusted
+	NetworkRequired
+)
+
+// ParseNetworks parses a comma separated list of CIDR prefixes or single
+// addresses. IPv4-mapped IPv6 prefixes are folded into their IPv4 form.
+func ParseNetworks(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range splitList(value) {
+		var prefix netip.Prefix
+		if strings.Contains(item, "/") {
+			p, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", item, err)
+			}
+			prefix = p
+		} else {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", item, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, normalizePrefix(prefix))
+	}
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("empty network list")
+	}
+	return prefixes, nil
+}
+
+// ParseRhost extracts an IP address from PAM_RHOST, accepting bracketed IPv6
+// literals and zone suffixes. ok is false for hostnames.
+func ParseRhost(rhost string) (netip.Addr, bool) {
+	host := strings.TrimSpace(rhost)
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// ClassifyHost matches rhost against require_networks and trusted_networks.
+// require_networks wins so a trusted supernet cannot hide a sensitive subnet.
+// Hostnames are only resolved when resolve_rhost is set and r is not nil;
+// a hostname counts as trusted only if every resolved address is trusted.
+func ClassifyHost(ctx context.Context, params Params, rhost string, r Resolver) (NetworkDecision, string, error) {
+	if len(params.TrustedNetworks) == 0 && len(params.RequireNetworks) == 0 {
+		return NetworkDefault, "no network rules configured", nil
+	}
+	if rhost == "" {
+		return NetworkDefault, "PAM_RHOST is empty", nil
+	}
+	var addrs []netip.Addr
+	if addr, ok := ParseRhost(rhost); ok {
+		addrs = append(addrs, addr)
+	} else {
+		if !params.ResolveRhost || r == nil {
+			return NetworkDefault, fmt.Sprintf("rhost %s is not an IP address", rhost), nil
+		}
+		resolved, err := r.LookupHost(ctx, rhost)
+		if err != nil {
+			return NetworkDefault, "", fmt.Errorf("resolve rhost %s: %w", rhost, err)
+		}
+		for _, item := range resolved {
+			if addr, ok := ParseRhost(item); ok {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			return NetworkDefault, fmt.Sprintf("rhost %s resolved to no addresses", rhost), nil
+		}
+	}
+	for _, addr := range addrs {
+		if prefix, ok := matchPrefix(params.RequireNetworks, addr); ok {
+			return NetworkRequired, fmt.Sprintf("rhost %s (%s) is in require_networks %s", rhost, addr, prefix), nil
+		}
+	}
+	if len(params.TrustedNetworks) == 0 {
+		return NetworkDefault, fmt.Sprintf("rhost %s is outside require_networks", rhost), nil
+	}
+	var matched netip.Prefix
+	for _, addr := range addrs {
+		prefix, ok := matchPrefix(params.TrustedNetworks, addr)
+		if !ok {
+			return NetworkDefault, fmt.Sprintf("rhost %s (%s) is outside trusted_networks", rhost, addr), nil
+		}
+		matched = prefix
+	}
+	return NetworkTrusted, fmt.Sprintf("rhost %s is in trusted_networks %s", rhost, matched), nil
+}
+
+func matchPrefix(prefixes []netip.Prefix, addr netip.Addr) (netip.Prefix, bool) {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Addr()
+	if addr.Is4In6() && prefix.Bits() >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), prefix.Bits()-96).Masked()
+	}
+	return prefix.Masked()
+}
@@ -0,0 +1,97 @@
+package pam
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestClassifyHost(t *testing.T) {
+	params, err := ParseParams([]string{
+		"trusted_networks=10.0.0.0/8,fd00::/8,192.0.2.7",
+		"require_networks=10.66.0.0/16",
+		"resolve_rhost",
+	})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	resolver := staticResolver{
+		"bastion.internal": {"10.1.2.3", "fd00::10"},
+		"mixed.example":    {"10.1.2.3", "203.0.113.9"},
+		"vault.internal":   {"10.66.1.1"},
+	}
+	cases := map[string]NetworkDecision{
+		"10.1.2.3":             NetworkTrusted,
+		"::ffff:10.1.2.3":      NetworkTrusted,
+		"[::ffff:10.1.2.3]":    NetworkTrusted,
+		"fd00::1%eth0":         NetworkTrusted,
+		"[fd12:3456::1]":       NetworkTrusted,
+		"192.0.2.7":            NetworkTrusted,
+		"192.0.2.8":            NetworkDefault,
+		"11.0.0.1":             NetworkDefault,
+		"fe80::1":              NetworkDefault,
+		"10.66.4.4":            NetworkRequired,
+		"::ffff:10.66.4.4":     NetworkRequired,
+		"bastion.internal":     NetworkTrusted,
+		"mixed.example":        NetworkDefault,
+		"vault.internal":       NetworkRequired,
+		"":                     NetworkDefault,
+		"not-an-ip-or-a-host!": NetworkDefault,
+	}
+	for rhost, want := range cases {
+		got, reason, err := ClassifyHost(context.Background(), params, rhost, resolver)
+		if rhost == "not-an-ip-or-a-host!" {
+			if err == nil {
+				t.Fatalf("%q: expected resolve error", rhost)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", rhost, err)
+		}
+		if got != want || reason == "" {
+			t.Fatalf("%q: decision=%d want %d (%s)", rhost, got, want, reason)
+		}
+	}
+}
+
+func TestClassifyHostWithoutResolve(t *testing.T) {
+	params, err := ParseParams([]string{"trusted_networks=0.0.0.0/0,::/0"})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	resolver := staticResolver{"bastion.internal": {"10.1.2.3"}}
+	if got, _, err := ClassifyHost(context.Background(), params, "bastion.internal", resolver); err != nil || got != NetworkDefault {
+		t.Fatalf("hostnames must not be trusted without resolve_rhost: %d %v", got, err)
+	}
+	if got, _, err := ClassifyHost(context.Background(), params, "2001:db8::1", nil); err != nil || got != NetworkTrusted {
+		t.Fatalf("expected ::/0 to trust IPv6: %d %v", got, err)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	prefixes, err := ParseNetworks("::ffff:192.168.0.0/112, 10.1.2.3/8")
+	if err != nil {
+		t.Fatalf("parse networks: %v", err)
+	}
+	if got := prefixes[0].String(); got != "192.168.0.0/16" {
+		t.Fatalf("IPv4-mapped prefix not folded: %s", got)
+	}
+	if got := prefixes[1].String(); got != "10.0.0.0/8" {
+		t.Fatalf("prefix not masked: %s", got)
+	}
+	for _, bad := range []string{"", ",", "10.0.0.0/33", "example.com", "fd00::/129"} {
+		if _, err := ParseNetworks(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
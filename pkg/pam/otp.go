@@ -0,0 +1,37 @@
+package pam
+
+// ExtractOTP splits a password that carries a verification code as suffix,
+// as used by try_first_pass/use_first_pass, into the code and the remaining
+// password. Six-digit codes are tried before eight-digit scratch codes.
+func ExtractOTP(raw string) (string, string, bool) {
+	if raw == "" {
+		return "", "", false
+	}
+	if code, rest, ok := splitDigits(raw, 6); ok {
+		return code, rest, true
+	}
+	if code, rest, ok := splitDigits(raw, 8); ok {
+		return code, rest, true
+	}
+	return "", "", false
+}
+
+func splitDigits(raw string, length int) (string, string, bool) {
+	if len(raw) < length {
+		return "", "", false
+	}
+	code := raw[len(raw)-length:]
+	if !onlyDigits(code) {
+		return "", "", false
+	}
+	return code, raw[:len(raw)-length], true
+}
+
+func onlyDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -2,10 +2,14 @@ package pam
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/i18n"
 )
 
 type PassMode int
@@ -16,6 +20,13 @@ const (
 	ModeUseFirst
 )
 
+type ExemptResult int
+
+const (
+	ExemptIgnore ExemptResult = iota
+	ExemptSuccess
+)
+
 type Params struct {
 	SecretSpec      string
 	Prompt          string
@@ -32,14 +43,50 @@ type Params struct {
 	NoStrictOwner   bool
 	AllowedPerm     os.FileMode
 	GracePeriod     time.Duration
+	GracePrefix4    int
+	GracePrefix6    int
+	GracePerService bool
+	GraceRecords    int
 	ForcedUser      string
+	SecretOwner     string
+	Daemon          string
+	StateStore      string
+	EnrollGrace     time.Duration
+	EnrollSince     time.Time
+	EnrollStateDir  string
+	EnrollOnLogin   bool
+	EnrollIssuer    string
+	ExemptUsers     []string
+	ExemptUsersFile string
+	ExemptGroups    []string
+	RequireGroups   []string
+	ExemptResult    ExemptResult
+	TrustedNetworks []netip.Prefix
+	RequireNetworks []netip.Prefix
+	ResolveRhost    bool
+	ScratchWarn     int
+	Retries         int
+	RetryDelay      time.Duration
+	AuditLog        string
+	AuditKey        string
+	MetricsFile     string
+	Lang            string
 }
 
+// DefaultScratchWarn is the number of remaining scratch codes at or below
+// which the session banner warns the user.
+const DefaultScratchWarn = 2
+
+// DefaultDaemonSocket is where ggpamd listens unless daemon= names a socket.
+const DefaultDaemonSocket = "/run/ggpam.sock"
+
 func DefaultParams() Params {
 	return Params{
-		Prompt:      "Verification code: ",
-		PassMode:    ModePrompt,
-		AllowedPerm: 0o600,
+		Prompt:         "Verification code: ",
+		PassMode:       ModePrompt,
+		AllowedPerm:    0o600,
+		EnrollStateDir: DefaultEnrollStateDir,
+		ScratchWarn:    DefaultScratchWarn,
 	}
 }
 
@@ -58,6 +105,16 @@ func ParseParams(args []string) (Params, error) {
 				return params, fmt.Errorf("prompt_file requires a path")
 			}
 			params.PromptTemplate = parts[1]
+		case strings.HasPrefix(arg, "state_store="):
+			params.StateStore = strings.TrimPrefix(arg, "state_store=")
+			if params.StateStore == "" {
+				return params, fmt.Errorf("state_store requires a value")
+			}
+		case strings.HasPrefix(arg, "secret_owner="):
+			params.SecretOwner = strings.TrimPrefix(arg, "secret_owner=")
+			if params.SecretOwner == "" {
+				return params, fmt.Errorf("secret_owner requires a user name")
+			}
 		case strings.HasPrefix(arg, "user="):
 			params.ForcedUser = strings.TrimPrefix(arg, "user=")
 		case strings.HasPrefix(arg, "allowed_perm="):
@@ -74,6 +131,134 @@ func ParseParams(args []string) (Params, error) {
 				return params, fmt.Errorf("grace_period must be a non-negative integer seconds: %q", value)
 			}
 			params.GracePeriod = time.Duration(secs) * time.Second
+		case strings.HasPrefix(arg, "grace_prefix="):
+			value := strings.TrimPrefix(arg, "grace_prefix=")
+			bits, err := strconv.Atoi(value)
+			if err != nil || bits < 0 || bits > 32 {
+				return params, fmt.Errorf("grace_prefix must be an IPv4 prefix length 0..32: %q", value)
+			}
+			params.GracePrefix4 = bits
+		case strings.HasPrefix(arg, "grace_prefix6="):
+			value := strings.TrimPrefix(arg, "grace_prefix6=")
+			bits, err := strconv.Atoi(value)
+			if err != nil || bits < 0 || bits > 128 {
+				return params, fmt.Errorf("grace_prefix6 must be an IPv6 prefix length 0..128: %q", value)
+			}
+			params.GracePrefix6 = bits
+		case strings.HasPrefix(arg, "grace_records="):
+			value := strings.TrimPrefix(arg, "grace_records=")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > config.MaxLoginRecords {
+				return params, fmt.Errorf("grace_records must be in 1..%d: %q", config.MaxLoginRecords, value)
+			}
+			params.GraceRecords = n
+		case arg == "grace_per_service":
+			params.GracePerService = true
+		case strings.HasPrefix(arg, "enroll_grace="):
+			value := strings.TrimPrefix(arg, "enroll_grace=")
+			d, err := parseDuration(value)
+			if err != nil || d <= 0 {
+				return params, fmt.Errorf("enroll_grace must be a positive duration such as 14d or 36h: %q", value)
+			}
+			params.EnrollGrace = d
+		case strings.HasPrefix(arg, "enroll_since="):
+			value := strings.TrimPrefix(arg, "enroll_since=")
+			since, err := parseDate(value)
+			if err != nil {
+				return params, fmt.Errorf("enroll_since must be a date (YYYY-MM-DD) or RFC 3339 time: %q", value)
+			}
+			params.EnrollSince = since
+		case strings.HasPrefix(arg, "enroll_state="):
+			params.EnrollStateDir = strings.TrimPrefix(arg, "enroll_state=")
+			if params.EnrollStateDir == "" {
+				return params, fmt.Errorf("enroll_state requires a directory")
+			}
+		case strings.HasPrefix(arg, "exempt_user="):
+			params.ExemptUsers = append(params.ExemptUsers, splitList(strings.TrimPrefix(arg, "exempt_user="))...)
+		case strings.HasPrefix(arg, "exempt_users_file="):
+			params.ExemptUsersFile = strings.TrimPrefix(arg, "exempt_users_file=")
+			if params.ExemptUsersFile == "" {
+				return params, fmt.Errorf("exempt_users_file requires a path")
+			}
+		case strings.HasPrefix(arg, "exempt_group="):
+			params.ExemptGroups = append(params.ExemptGroups, splitList(strings.TrimPrefix(arg, "exempt_group="))...)
+		case strings.HasPrefix(arg, "require_group="):
+			params.RequireGroups = append(params.RequireGroups, splitList(strings.TrimPrefix(arg, "require_group="))...)
+		case strings.HasPrefix(arg, "exempt_result="):
+			switch value := strings.TrimPrefix(arg, "exempt_result="); value {
+			case "ignore":
+				params.ExemptResult = ExemptIgnore
+			case "success":
+				params.ExemptResult = ExemptSuccess
+			default:
+				return params, fmt.Errorf("exempt_result must be ignore or success: %q", value)
+			}
+		case strings.HasPrefix(arg, "trusted_networks="):
+			prefixes, err := ParseNetworks(strings.TrimPrefix(arg, "trusted_networks="))
+			if err != nil {
+				return params, fmt.Errorf("trusted_networks: %w", err)
+			}
+			params.TrustedNetworks = append(params.TrustedNetworks, prefixes...)
+		case strings.HasPrefix(arg, "require_networks="):
+			prefixes, err := ParseNetworks(strings.TrimPrefix(arg, "require_networks="))
+			if err != nil {
+				return params, fmt.Errorf("require_networks: %w", err)
+			}
+			params.RequireNetworks = append(params.RequireNetworks, prefixes...)
+		case arg == "resolve_rhost":
+			params.ResolveRhost = true
+		case strings.HasPrefix(arg, "enroll_issuer="):
+			params.EnrollIssuer = strings.TrimPrefix(arg, "enroll_issuer=")
+		case arg == "daemon":
+			params.Daemon = DefaultDaemonSocket
+		case strings.HasPrefix(arg, "daemon="):
+			params.Daemon = strings.TrimPrefix(arg, "daemon=")
+			if params.Daemon == "" {
+				return params, fmt.Errorf("daemon requires a socket path")
+			}
+		case strings.HasPrefix(arg, "retries="):
+			value := strings.TrimPrefix(arg, "retries=")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > 10 {
+				return params, fmt.Errorf("retries must be an integer between 0 and 10: %q", value)
+			}
+			params.Retries = n
+		case strings.HasPrefix(arg, "retry_delay="):
+			value := strings.TrimPrefix(arg, "retry_delay=")
+			d, err := parseDuration(value)
+			if err != nil || d < 0 || d > time.Minute {
+				return params, fmt.Errorf("retry_delay must be a duration of at most 1m such as 2 or 1500ms: %q", value)
+			}
+			params.RetryDelay = d
+		case strings.HasPrefix(arg, "audit_log="):
+			params.AuditLog = strings.TrimPrefix(arg, "audit_log=")
+			if params.AuditLog == "" {
+				return params, fmt.Errorf("audit_log requires a path")
+			}
+		case strings.HasPrefix(arg, "audit_key="):
+			params.AuditKey = strings.TrimPrefix(arg, "audit_key=")
+			if params.AuditKey == "" {
+				return params, fmt.Errorf("audit_key requires a path")
+			}
+		case strings.HasPrefix(arg, "metrics_file="):
+			params.MetricsFile = strings.TrimPrefix(arg, "metrics_file=")
+			if params.MetricsFile == "" {
+				return params, fmt.Errorf("metrics_file requires a path")
+			}
+		case strings.HasPrefix(arg, "lang="):
+			params.Lang = strings.TrimPrefix(arg, "lang=")
+			if !i18n.ValidLocale(params.Lang) {
+				return params, fmt.Errorf("invalid lang %q", params.Lang)
+			}
+		case strings.HasPrefix(arg, "scratch_warn="):
+			value := strings.TrimPrefix(arg, "scratch_warn=")
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return params, fmt.Errorf("scratch_warn must be a non-negative integer: %q", value)
+			}
+			params.ScratchWarn = n
+		case arg == "enroll_on_login":
+			params.EnrollOnLogin = true
 		case arg == "try_first_pass":
 			params.PassMode = ModeTryFirst
 		case arg == "use_first_pass":
@@ -101,5 +286,53 @@ func ParseParams(args []string) (Params, error) {
 	if params.ForwardPass && !params.PromptOverride {
 		params.Prompt = "Password & verification code: "
 	}
+	if !params.EnrollSince.IsZero() && params.EnrollGrace == 0 {
+		return params, fmt.Errorf("enroll_since requires enroll_grace")
+	}
 	return params, nil
 }
+
+// GraceScope returns how grace_period records are matched for service.
+func (p Params) GraceScope(service string) config.GraceScope {
+	scope := config.GraceScope{
+		IPv4Prefix: p.GracePrefix4,
+		IPv6Prefix: p.GracePrefix6,
+		MaxRecords: p.GraceRecords,
+	}
+	if p.GracePerService {
+		scope.Service = service
+	}
+	return scope
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDuration accepts plain seconds, Go durations ("36h") and whole days ("14d").
+func parseDuration(value string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
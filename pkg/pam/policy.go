@@ -0,0 +1,111 @@
+package pam
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Exemption explains whether OTP is skipped for a user and why.
+type Exemption struct {
+	Exempt bool
+	Reason string
+}
+
+// CheckExemption applies exempt_user, exempt_users_file, exempt_group and
+// require_group to username. account may be nil when the user cannot be
+// resolved, in which case only the name based rules apply.
+func CheckExemption(params Params, username string, account *user.User) (Exemption, error) {
+	if slices.Contains(params.ExemptUsers, username) {
+		return Exemption{Exempt: true, Reason: fmt.Sprintf("user %s listed in exempt_user", username)}, nil
+	}
+	if params.ExemptUsersFile != "" {
+		listed, err := userListed(params.ExemptUsersFile, username)
+		if err != nil {
+			return Exemption{}, err
+		}
+		if listed {
+			return Exemption{Exempt: true, Reason: fmt.Sprintf("user %s listed in %s", username, params.ExemptUsersFile)}, nil
+		}
+	}
+	if len(params.ExemptGroups) == 0 && len(params.RequireGroups) == 0 {
+		return Exemption{Reason: "no exemption rules matched"}, nil
+	}
+	if account == nil {
+		return Exemption{Reason: fmt.Sprintf("user %s unknown, group rules not applied", username)}, nil
+	}
+	groups, err := accountGroups(account)
+	if err != nil {
+		return Exemption{}, err
+	}
+	for _, name := range params.ExemptGroups {
+		if slices.Contains(groups, name) {
+			return Exemption{Exempt: true, Reason: fmt.Sprintf("user %s is a member of exempt_group %s", username, name)}, nil
+		}
+	}
+	if len(params.RequireGroups) > 0 {
+		for _, name := range params.RequireGroups {
+			if slices.Contains(groups, name) {
+				return Exemption{Reason: fmt.Sprintf("user %s is a member of require_group %s", username, name)}, nil
+			}
+		}
+		return Exemption{Exempt: true, Reason: fmt.Sprintf("user %s is not a member of require_group %s", username, strings.Join(params.RequireGroups, ","))}, nil
+	}
+	return Exemption{Reason: fmt.Sprintf("user %s is not a member of exempt_group %s", username, strings.Join(params.ExemptGroups, ","))}, nil
+}
+
+// accountGroups returns the names of all groups the account belongs to,
+// falling back to the numeric GID when a group has no name.
+func accountGroups(account *user.User) ([]string, error) {
+	ids, err := account.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("list groups of %s: %w", account.Username, err)
+	}
+	if !slices.Contains(ids, account.Gid) {
+		ids = append(ids, account.Gid)
+	}
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			names = append(names, g.Name)
+			continue
+		}
+		names = append(names, id)
+	}
+	return names, nil
+}
+
+func userListed(path, username string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("open exempt_users_file %s: %w", path, err)
+	}
+	defer f.Close()
+	// Whoever can write the list can exempt themselves from OTP.
+	info, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat exempt_users_file %s: %w", path, err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.Mode().IsRegular() || stat.Uid != 0 || info.Mode().Perm()&0o022 != 0 {
+		return false, fmt.Errorf("exempt_users_file %s must be a regular file owned by root and not writable by group or others", path)
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		if strings.TrimSpace(line) == username {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read exempt_users_file %s: %w", path, err)
+	}
+	return false, nil
+}
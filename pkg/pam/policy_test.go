@@ -0,0 +1,113 @@
+package pam
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckExemptionUsers(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("exempt_users_file must be owned by root")
+	}
+	list := filepath.Join(t.TempDir(), "exempt")
+	if err := os.WriteFile(list, []byte("# service accounts\nbackup\n  deploy  # ci\n"), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+	params, err := ParseParams([]string{"exempt_user=nagios, monitor", "exempt_users_file=" + list})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	for name, want := range map[string]bool{"monitor": true, "deploy": true, "backup": true, "alice": false, "ci": false} {
+		got, err := CheckExemption(params, name, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Exempt != want {
+			t.Fatalf("%s: exempt=%v want %v (%s)", name, got.Exempt, want, got.Reason)
+		}
+	}
+	params.ExemptUsersFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := CheckExemption(params, "alice", nil); err == nil {
+		t.Fatal("expected error for missing exempt_users_file")
+	}
+}
+
+func TestCheckExemptionUntrustedUsersFile(t *testing.T) {
+	list := filepath.Join(t.TempDir(), "exempt")
+	if err := os.WriteFile(list, []byte("alice\n"), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+	params, err := ParseParams([]string{"exempt_users_file=" + list})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	check := func(what string) {
+		t.Helper()
+		got, err := CheckExemption(params, "alice", nil)
+		if err == nil || got.Exempt {
+			t.Fatalf("%s: exempt=%v err=%v, want an error", what, got.Exempt, err)
+		}
+	}
+	for _, mode := range []os.FileMode{0o620, 0o602, 0o666} {
+		if err := os.Chmod(list, mode); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		check(mode.String())
+	}
+	if os.Geteuid() == 0 {
+		if err := os.Chmod(list, 0o644); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		if err := os.Chown(list, 12345, 12345); err != nil {
+			t.Fatalf("chown: %v", err)
+		}
+		check("owned by another user")
+	}
+}
+
+func TestCheckExemptionGroups(t *testing.T) {
+	account, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	primary, err := user.LookupGroupId(account.Gid)
+	if err != nil {
+		t.Skipf("primary group has no name: %v", err)
+	}
+	cases := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"exempt_group=" + primary.Name}, true},
+		{[]string{"exempt_group=ggpam-no-such-group"}, false},
+		{[]string{"require_group=" + primary.Name}, false},
+		{[]string{"require_group=ggpam-no-such-group"}, true},
+		{[]string{"require_group=ggpam-no-such-group," + primary.Name}, false},
+		{[]string{"exempt_group=" + primary.Name, "require_group=" + primary.Name}, true},
+	}
+	for _, tc := range cases {
+		params, err := ParseParams(tc.args)
+		if err != nil {
+			t.Fatalf("parse %v: %v", tc.args, err)
+		}
+		got, err := CheckExemption(params, account.Username, account)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.args, err)
+		}
+		if got.Exempt != tc.want || got.Reason == "" {
+			t.Fatalf("%v: exempt=%v want %v (%s)", tc.args, got.Exempt, tc.want, got.Reason)
+		}
+	}
+}
+
+func TestExemptResultParam(t *testing.T) {
+	params, err := ParseParams([]string{"exempt_result=success"})
+	if err != nil || params.ExemptResult != ExemptSuccess {
+		t.Fatalf("unexpected params %+v err=%v", params, err)
+	}
+	if _, err := ParseParams([]string{"exempt_result=maybe"}); err == nil {
+		t.Fatal("expected error for invalid exempt_result")
+	}
+}
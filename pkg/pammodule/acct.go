@@ -0,0 +1,87 @@
+package pammodule
+
+import (
+	"errors"
+	"os"
+	"os/user"
+
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+)
+
+// AcctMgmt implements pam_sm_acct_mgmt: users without a secret file may log
+// in until their enrollment deadline passes, reminded to run "ggpam init".
+func (m *Module) AcctMgmt(h Handle, params pamcfg.Params) Status {
+	if !params.EnrollmentEnforced() {
+		return Ignore
+	}
+	username, rc := targetUser(h, params)
+	if rc != Success || username == "" {
+		return rc
+	}
+	account, err := m.lookup(username)
+	if err != nil {
+		syslog(h, LogWarning, msg(i18n.MsgUserLookupFailed, username, err))
+		return UserUnknown
+	}
+	if rc, done := checkExemption(h, params, username, account); done {
+		return rc
+	}
+	updateLogHome(params, account)
+	secretPath, err := pamcfg.ResolveSecretPath(params.SecretSpec, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgResolveSecretFailed, err))
+		return ServiceErr
+	}
+	var enrolled bool
+	if params.Daemon != "" {
+		enrolled, err = daemonEnrolled(params, username)
+	} else {
+		owner, oerr := pamcfg.SecretOwnerAccount(params, account)
+		if oerr != nil {
+			syslog(h, LogErr, msg(i18n.MsgDropPrivilegesFailed, params.SecretOwner, oerr))
+			return ServiceErr
+		}
+		enrolled, err = secretExists(owner, secretPath)
+	}
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgReadConfigFailed, secretPath, err))
+		return ServiceErr
+	}
+	if enrolled {
+		debugf(h, params, "user %s is enrolled", username)
+		return Success
+	}
+	now := m.now()
+	deadline, err := pamcfg.EnrollmentDeadline(params, username, now)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgEnrollStateFailed, err))
+		return ServiceErr
+	}
+	when := deadline.Local().Format("2006-01-02 15:04 MST")
+	loc := userLocalizer(h, params, nil)
+	if now.Before(deadline) {
+		syslog(h, LogInfo, msg(i18n.MsgEnrollPending, username, when))
+		h.Info(loc.Msgf(i18n.MsgEnrollReminder, when))
+		return Success
+	}
+	event(h, LogWarning, eventFor(h, username).With(logging.EventAccessDenied).WithError(logging.ClassNotEnrolled, nil), msg(i18n.MsgEnrollExpired, username, when))
+	h.Error(loc.Msgf(i18n.MsgEnrollDeadlinePassed, when))
+	return PermDenied
+}
+
+func secretExists(account *user.User, path string) (bool, error) {
+	privState, err := dropPrivileges(account)
+	if err != nil {
+		return false, err
+	}
+	defer restorePrivileges(privState)
+	if _, err := os.Lstat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
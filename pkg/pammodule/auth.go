@@ -0,0 +1,471 @@
+package pammodule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+const (
+	stateStoreTimeout = 5 * time.Second
+	resolveTimeout    = 2 * time.Second
+)
+
+var fallbackUser = "nobody"
+
+// Module holds the dependencies of the PAM entry points. The zero value uses
+// the system user database, resolver and clock.
+type Module struct {
+	Lookup   func(name string) (*user.User, error)
+	Resolver pamcfg.Resolver
+	Now      func() time.Time
+	// Sleep waits between retries; nil uses time.Sleep.
+	Sleep func(time.Duration)
+}
+
+func (m *Module) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *Module) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if m.Sleep != nil {
+		m.Sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+func (m *Module) lookup(name string) (*user.User, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%s", msg(i18n.MsgEmptyUsername))
+	}
+	if m.Lookup != nil {
+		return m.Lookup(name)
+	}
+	return lookupAccount(name)
+}
+
+func (m *Module) resolver() pamcfg.Resolver {
+	if m.Resolver != nil {
+		return m.Resolver
+	}
+	return net.DefaultResolver
+}
+
+func lookupAccount(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		if u, err2 := user.LookupId(name); err2 == nil {
+			return u, nil
+		}
+	}
+	return nil, err
+}
+
+// targetUser returns the PAM user, or the forced user= override.
+func targetUser(h Handle, params pamcfg.Params) (string, Status) {
+	pamUser, rc := h.User()
+	if rc != Success || pamUser == "" {
+		return "", rc
+	}
+	if params.ForcedUser != "" {
+		return params.ForcedUser, Success
+	}
+	return pamUser, Success
+}
+
+// Authenticate implements pam_sm_authenticate.
+func (m *Module) Authenticate(h Handle, params pamcfg.Params) Status {
+	username, rc := targetUser(h, params)
+	if rc != Success || username == "" {
+		return rc
+	}
+	debugf(h, params, "start for user %s", username)
+	loc := userLocalizer(h, params, nil)
+
+	account, lookupErr := m.lookup(username)
+	if rc, done := checkExemption(h, params, username, account); done {
+		if rc != ServiceErr {
+			exportMethod(h, MethodExempt, -1)
+		}
+		return rc
+	}
+	rhost := item(h, ItemRhost)
+	if rhost != "" {
+		debugf(h, params, "received PAM_RHOST=%s", rhost)
+	}
+	network, rc := m.classifyRhost(h, params, username, rhost)
+	if network == pamcfg.NetworkTrusted {
+		exportMethod(h, MethodTrusted, -1)
+		return rc
+	}
+	if params.Daemon != "" {
+		return m.daemonAuth(h, params, loc, username, rhost, network)
+	}
+	if lookupErr != nil {
+		syslog(h, LogWarning, msg(i18n.MsgUserLookupFailed, username, lookupErr))
+		fallback, ferr := m.lookup(fallbackUser)
+		if ferr != nil {
+			return ServiceErr
+		}
+		syslog(h, LogInfo, msg(i18n.MsgFallbackUser, fallbackUser))
+		account = fallback
+	}
+	updateLogHome(params, account)
+
+	owner, err := pamcfg.SecretOwnerAccount(params, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDropPrivilegesFailed, params.SecretOwner, err))
+		return ServiceErr
+	}
+	auth, verifyOpts, closeStore, rc := m.authenticator(h, params, username)
+	if rc != Success {
+		return rc
+	}
+	defer closeStore()
+	privState, err := dropPrivileges(owner)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDropPrivilegesFailed, owner.Username, err))
+		return ServiceErr
+	}
+	defer restorePrivileges(privState)
+	updateLogHome(params, account)
+
+	secretPath, err := pamcfg.ResolveSecretPath(params.SecretSpec, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgResolveSecretFailed, err))
+		return ServiceErr
+	}
+	debugf(h, params, "using secret file %s", secretPath)
+
+	cfg, state, err := pamcfg.LoadConfig(owner, secretPath, params)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && params.EnrollOnLogin {
+			return m.enrollOnLogin(h, params, loc, owner, username, secretPath)
+		}
+		if errors.Is(err, os.ErrNotExist) && params.EnrollmentEnforced() {
+			event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(resultEnrollGrace), msg(i18n.MsgUserNoSecretEnroll, username))
+			return Ignore
+		}
+		if errors.Is(err, os.ErrNotExist) && params.NullOK {
+			event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(resultNullOK), msg(i18n.MsgUserNoSecretNullOK, username))
+			return Ignore
+		}
+		event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(logging.ClassConfig, err), msg(i18n.MsgReadConfigFailed, secretPath, err))
+		h.Error(loc.Msgf(i18n.MsgReadConfigFailed, secretPath, err))
+		return AuthErr
+	}
+	loc = userLocalizer(h, params, cfg)
+
+	if params.PromptTemplate != "" {
+		rendered, err := preparePromptFromTemplate(h, params.PromptTemplate, account, username, rhost)
+		if err != nil {
+			syslog(h, LogErr, msg(i18n.MsgPromptTemplateFailed, err))
+			return ServiceErr
+		}
+		params.Prompt = rendered
+		debugf(h, params, "using prompt template %s", params.PromptTemplate)
+	}
+	graceScope := params.GraceScope(item(h, ItemService))
+	if params.GracePeriod > 0 && network != pamcfg.NetworkRequired && cfg.WithinGrace(rhost, graceScope, params.GracePeriod, m.now()) {
+		event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(MethodGrace), msg(i18n.MsgGraceSkip, rhost))
+		cfg.RecordLogin(rhost, graceScope, m.now())
+		metrics.GraceBypass()
+		debugf(h, params, "grace period hit for host %s", rhost)
+		if rc := persistConfig(h, loc, cfg, secretPath, params, owner, state); rc != Success {
+			return rc
+		}
+		exportMethod(h, MethodGrace, len(cfg.ScratchCodes))
+		return Success
+	}
+
+	code, remainder, rc := obtainOTP(h, params)
+	if rc != Success {
+		return rc
+	}
+	var res authenticator.Result
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+		start := time.Now()
+		res, err = auth.VerifyCodeContext(ctx, cfg, code, verifyOpts)
+		cancel()
+		if err == nil {
+			metrics.Verified(res, time.Since(start))
+			break
+		}
+		metrics.Failed(service.ErrorClass(err), time.Since(start))
+		if !errors.Is(err, authenticator.ErrInvalidCode) && !errors.Is(err, authenticator.ErrCodeReused) && !errors.Is(err, config.ErrRateLimited) {
+			event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(service.ErrorClass(err), err), msg(i18n.MsgAuthFailedGeneric, err))
+			h.Error(loc.Msgf(i18n.MsgInternalError))
+			return AuthErr
+		}
+		h.Error(err.Error())
+		event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(service.ErrorClass(err), err), msg(i18n.MsgUserAuthFailed, username, err))
+		// Keep the advanced HOTP counter, skew samples and counted
+		// attempts, as service.Verify does.
+		if rc := persistConfig(h, loc, cfg, secretPath, params, owner, state); rc != Success {
+			return rc
+		}
+		if rc, done := retryExhausted(h, params, username, attempt, err); done {
+			return rc
+		}
+		m.sleep(params.RetryDelay)
+		// Start the next attempt from the file as written, which also
+		// picks up changes made by concurrent logins.
+		cfg, state, err = pamcfg.LoadConfig(owner, secretPath, params)
+		if err != nil {
+			syslog(h, LogErr, msg(i18n.MsgReadConfigFailed, secretPath, err))
+			h.Error(loc.Msgf(i18n.MsgReadConfigFailed, secretPath, err))
+			return AuthErr
+		}
+		if code, remainder, rc = promptForwarded(h, params); rc != Success {
+			return rc
+		}
+	}
+	if params.ForwardPass && remainder != "" {
+		if rc := h.SetItem(ItemAuthtok, remainder); rc != Success {
+			syslog(h, LogWarning, msg(i18n.MsgUpdateAuthtokFailed))
+		}
+	}
+	if params.GracePeriod > 0 && rhost != "" {
+		cfg.RecordLogin(rhost, graceScope, m.now())
+	}
+	if rc := persistConfig(h, loc, cfg, secretPath, params, owner, state); rc != Success {
+		return rc
+	}
+	debugf(h, params, "authentication completed for %s", username)
+	if res.SkewReset {
+		event(h, LogInfo, eventFor(h, username).With(logging.EventSkewReset), msg(i18n.MsgSkewReset, username))
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSuccess).WithResult(string(res.Type)), msg(i18n.MsgUserAuthSuccess, username, res.Type))
+	exportMethod(h, string(res.Type), len(cfg.ScratchCodes))
+	return Success
+}
+
+// retryExhausted decides whether another code may be prompted for after
+// attempt failed with err. Rate limiting and use_first_pass, which never
+// prompts, end the loop with PAM_AUTH_ERR; running out of retries= ends it
+// with PAM_MAXTRIES.
+func retryExhausted(h Handle, params pamcfg.Params, username string, attempt int, err error) (Status, bool) {
+	if params.Retries == 0 || errors.Is(err, config.ErrRateLimited) || params.PassMode == pamcfg.ModeUseFirst {
+		return AuthErr, true
+	}
+	if attempt > params.Retries {
+		event(h, LogErr, eventFor(h, username).With(logging.EventAuthMaxTries).WithError(service.ErrorClass(err), err), i18n.Format(i18n.MsgMaxTries, i18n.Args{"user": username, "count": attempt}))
+		return MaxTries, true
+	}
+	return Success, false
+}
+
+// authenticator returns the verifier configured by params, connected to the
+// state store if one is set. The returned function closes the store. It must
+// be called before dropping privileges.
+func (m *Module) authenticator(h Handle, params pamcfg.Params, username string) (*authenticator.Authenticator, authenticator.VerifyOptions, func(), Status) {
+	auth := &authenticator.Authenticator{Now: m.Now}
+	opts := authenticator.VerifyOptions{
+		DisableSkewAdjustment: params.NoSkewAdjust,
+		NoIncrementHOTP:       params.NoIncrementHOTP,
+	}
+	if params.StateStore == "" {
+		return auth, opts, func() {}, Success
+	}
+	store, err := authenticator.OpenStateStore(params.StateStore)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgStateStoreFailed, authenticator.RedactStateStore(params.StateStore), err))
+		return nil, opts, nil, ServiceErr
+	}
+	// The state directory is private to root: open the user's file now,
+	// before privileges are dropped.
+	if fs, ok := store.(*authenticator.FileStore); ok {
+		if err := fs.Hold(username); err != nil {
+			store.Close()
+			syslog(h, LogErr, msg(i18n.MsgStateStoreFailed, authenticator.RedactStateStore(params.StateStore), err))
+			return nil, opts, nil, ServiceErr
+		}
+	}
+	auth.Store = store
+	opts.StateKey = username
+	debugf(h, params, "using state store %s", authenticator.RedactStateStore(params.StateStore))
+	return auth, opts, func() { store.Close() }, Success
+}
+
+// updateLogHome moves the default log file into the home of account. With
+// secret_owner= the module runs as the service account, which has no
+// business writing into the user's home, so the log stays where it is.
+func updateLogHome(params pamcfg.Params, account *user.User) {
+	if params.SecretOwner != "" {
+		return
+	}
+	_ = logging.UpdateHome(account.HomeDir)
+}
+
+// checkExemption reports done=true when the exemption rules decide the
+// outcome on their own, either skipping OTP or failing on a broken rule.
+func checkExemption(h Handle, params pamcfg.Params, username string, account *user.User) (Status, bool) {
+	ex, err := pamcfg.CheckExemption(params, username, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgExemptionCheckFailed, username, err))
+		return ServiceErr, true
+	}
+	debugf(h, params, "exemption check: %s", ex.Reason)
+	if !ex.Exempt {
+		return Success, false
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(MethodExempt), msg(i18n.MsgUserExempt, username, ex.Reason))
+	if params.ExemptResult == pamcfg.ExemptSuccess {
+		return Success, true
+	}
+	return Ignore, true
+}
+
+// classifyRhost applies trusted_networks/require_networks. For trusted hosts
+// the returned code is the configured exempt_result.
+func (m *Module) classifyRhost(h Handle, params pamcfg.Params, username, rhost string) (pamcfg.NetworkDecision, Status) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	decision, reason, err := pamcfg.ClassifyHost(ctx, params, rhost, m.resolver())
+	if err != nil {
+		syslog(h, LogWarning, msg(i18n.MsgNetworkCheckFailed, rhost, err))
+		return pamcfg.NetworkDefault, Success
+	}
+	debugf(h, params, "network check: %s", reason)
+	if decision != pamcfg.NetworkTrusted {
+		return decision, Success
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(MethodTrusted), msg(i18n.MsgTrustedNetworkSkip, rhost))
+	if params.ExemptResult == pamcfg.ExemptSuccess {
+		return decision, Success
+	}
+	return decision, Ignore
+}
+
+func obtainOTP(h Handle, params pamcfg.Params) (string, string, Status) {
+	switch params.PassMode {
+	case pamcfg.ModeUseFirst:
+		pw, rc := authtok(h)
+		if rc != Success {
+			return "", "", rc
+		}
+		logDummyPassword(h, pw)
+		code, rest, ok := pamcfg.ExtractOTP(pw)
+		if !ok {
+			return "", "", AuthErr
+		}
+		return code, rest, Success
+	case pamcfg.ModeTryFirst:
+		if pw, rc := authtok(h); rc == Success && pw != "" {
+			logDummyPassword(h, pw)
+			if code, rest, ok := pamcfg.ExtractOTP(pw); ok {
+				return code, rest, Success
+			}
+		}
+		return promptForwarded(h, params)
+	default:
+		return promptForwarded(h, params)
+	}
+}
+
+// promptForwarded prompts for the code; with forward_pass the answer is
+// "password & verification code" and the password part is returned as the
+// remainder.
+func promptForwarded(h Handle, params pamcfg.Params) (string, string, Status) {
+	resp, _, rc := promptCode(h, params.Prompt, params.EchoCode)
+	if rc != Success || !params.ForwardPass {
+		return resp, "", rc
+	}
+	if code, rest, ok := pamcfg.ExtractOTP(resp); ok {
+		return code, rest, Success
+	}
+	return resp, "", Success
+}
+
+// authtok returns PAM_AUTHTOK, failing when no earlier module set it.
+func authtok(h Handle) (string, Status) {
+	pw, rc := h.Item(ItemAuthtok)
+	if rc != Success {
+		return "", rc
+	}
+	if pw == "" {
+		return "", AuthtokErr
+	}
+	logging.Register(pw)
+	return pw, Success
+}
+
+func promptCode(h Handle, prompt string, echo bool) (string, string, Status) {
+	style := PromptEchoOff
+	if echo {
+		style = PromptEchoOn
+	}
+	resp, rc := h.Prompt(style, prompt)
+	if rc != Success {
+		return "", "", rc
+	}
+	logging.Register(resp)
+	code := strings.TrimSpace(resp)
+	if code == "" {
+		return "", "", AuthErr
+	}
+	return code, "", Success
+}
+
+func logDummyPassword(h Handle, pw string) {
+	if len(pw) > 0 && pw[0] == '\b' {
+		syslog(h, LogInfo, msg(i18n.MsgDummyPassword))
+	}
+}
+
+func persistConfig(h Handle, loc i18n.Localizer, cfg *config.Config, path string, params pamcfg.Params, account *user.User, state pamcfg.FileState) Status {
+	if !cfg.Dirty {
+		return Success
+	}
+	data, err := cfg.Bytes()
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgSerializeConfigFailed, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return AuthErr
+	}
+	err = pamcfg.WriteConfig(account, path, data, params.AllowedPerm, state)
+	if err != nil {
+		if errors.Is(err, pamcfg.ErrSecretModified) {
+			syslog(h, LogErr, msg(i18n.MsgSecretChangedDuringProcess))
+			h.Error(loc.Msgf(i18n.MsgSecretChangedRetry))
+			return AuthErr
+		}
+		if params.AllowReadonly && (errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EPERM)) {
+			syslog(h, LogWarning, msg(i18n.MsgReadonlyWriteIgnored, err))
+			return Success
+		}
+		syslog(h, LogErr, msg(i18n.MsgWriteConfigFailed, path, err))
+		h.Error(loc.Msgf(i18n.MsgUpdateConfigFailed))
+		return AuthErr
+	}
+	if err := applySelinuxContext(path); err != nil {
+		syslog(h, LogDebug, fmt.Sprintf("setting SELinux type \"%s\" on file \"%s\" failed. Okay if SELinux is disabled: %v", selinuxSecretType, path, err))
+	}
+	return Success
+}
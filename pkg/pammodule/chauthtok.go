@@ -0,0 +1,205 @@
+package pammodule
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/daemon"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+// ChauthtokFlags are the pam_sm_chauthtok flags the module acts on.
+type ChauthtokFlags int
+
+const (
+	PrelimCheck ChauthtokFlags = 1 << iota
+	UpdateAuthtok
+	ChangeExpiredAuthtok
+)
+
+// Chauthtok implements pam_sm_chauthtok. After the current code is verified
+// a new secret with the same options is generated, shown and confirmed from
+// the new device before it replaces the old one. All work happens in the
+// PAM_UPDATE_AUTHTOK pass; secrets never expire, so a change of expired
+// tokens is ignored.
+func (m *Module) Chauthtok(h Handle, params pamcfg.Params, flags ChauthtokFlags) Status {
+	if flags&ChangeExpiredAuthtok != 0 {
+		return Ignore
+	}
+	if flags&UpdateAuthtok == 0 {
+		return Success
+	}
+	username, rc := targetUser(h, params)
+	if rc != Success || username == "" {
+		return rc
+	}
+	debugf(h, params, "chauthtok for user %s", username)
+	loc := userLocalizer(h, params, nil)
+	account, err := m.lookup(username)
+	if err != nil {
+		syslog(h, LogWarning, msg(i18n.MsgUserLookupFailed, username, err))
+		return UserUnknown
+	}
+	if rc, done := checkExemption(h, params, username, account); done {
+		if rc == ServiceErr {
+			return rc
+		}
+		return Ignore
+	}
+	if params.Daemon != "" {
+		return m.daemonChauthtok(h, params, loc, username)
+	}
+
+	owner, err := pamcfg.SecretOwnerAccount(params, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDropPrivilegesFailed, params.SecretOwner, err))
+		return ServiceErr
+	}
+	auth, verifyOpts, closeStore, rc := m.authenticator(h, params, username)
+	if rc != Success {
+		return rc
+	}
+	defer closeStore()
+	privState, err := dropPrivileges(owner)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDropPrivilegesFailed, owner.Username, err))
+		return ServiceErr
+	}
+	defer restorePrivileges(privState)
+
+	secretPath, err := pamcfg.ResolveSecretPath(params.SecretSpec, account)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgResolveSecretFailed, err))
+		return ServiceErr
+	}
+	cfg, state, err := pamcfg.LoadConfig(owner, secretPath, params)
+	if errors.Is(err, os.ErrNotExist) {
+		syslog(h, LogInfo, msg(i18n.MsgChauthtokNoSecret, username))
+		return Ignore
+	}
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgReadConfigFailed, secretPath, err))
+		h.Error(loc.Msgf(i18n.MsgReadConfigFailed, secretPath, err))
+		return AuthtokErr
+	}
+	loc = userLocalizer(h, params, cfg)
+
+	h.Info(loc.Msgf(i18n.MsgChauthtokIntro))
+	code, _, rc := promptCode(h, loc.Msgf(i18n.MsgChauthtokCurrentPrompt), params.EchoCode)
+	if rc != Success {
+		return rc
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+	defer cancel()
+	if _, err := auth.VerifyCodeContext(ctx, cfg, code, verifyOpts); err != nil {
+		event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(service.ErrorClass(err), err), msg(i18n.MsgChauthtokAborted, username, err))
+		if errors.Is(err, authenticator.ErrInvalidCode) || errors.Is(err, authenticator.ErrCodeReused) || errors.Is(err, config.ErrRateLimited) {
+			h.Error(err.Error())
+			persistConfig(h, loc, cfg, secretPath, params, owner, state)
+			return AuthErr
+		}
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return AuthtokErr
+	}
+	// The code is spent whether or not the new device gets confirmed: save
+	// the used scratch code, HOTP counter or DISALLOW_REUSE timestamp now so
+	// that an aborted change cannot be replayed.
+	if cfg.Dirty {
+		if rc := persistConfig(h, loc, cfg, secretPath, params, owner, state); rc != Success {
+			return AuthtokErr
+		}
+		if state, err = pamcfg.CurrentFileState(secretPath); err != nil {
+			syslog(h, LogErr, msg(i18n.MsgReadConfigFailed, secretPath, err))
+			h.Error(loc.Msgf(i18n.MsgInternalError))
+			return AuthtokErr
+		}
+	}
+
+	next, err := enroll.NewConfig(enroll.OptionsFrom(cfg))
+	if err != nil {
+		event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(logging.ClassInternal, err), msg(i18n.MsgChauthtokAborted, username, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return AuthtokErr
+	}
+	showSecret(h, params, loc, enroll.OTPAuthURL(next, enroll.DefaultLabel(username), params.EnrollIssuer), next.Secret, next.ScratchCodes)
+	if rc := confirmCode(h, params, loc, m.checkPending(next)); rc != Success {
+		return chauthtokFailed(h, username, rc)
+	}
+	// state describes the file as last read or written here, so a
+	// concurrent login or change makes the write fail instead of being
+	// overwritten.
+	if !storeSecret(h, params, loc, owner, next, secretPath, state) {
+		return AuthtokErr
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventSecretChanged), msg(i18n.MsgChauthtokCompleted, username, secretPath))
+	return Success
+}
+
+// daemonChauthtok rotates the secret through ggpamd: the current code is
+// checked with a normal verification and the replacement is confirmed with
+// the daemon's enrollment operations.
+func (m *Module) daemonChauthtok(h Handle, params pamcfg.Params, loc i18n.Localizer, username string) Status {
+	enrolled, err := daemonEnrolled(params, username)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDaemonFailed, username, err))
+		return AuthtokErr
+	}
+	if !enrolled {
+		syslog(h, LogInfo, msg(i18n.MsgChauthtokNoSecret, username))
+		return Ignore
+	}
+
+	h.Info(loc.Msgf(i18n.MsgChauthtokIntro))
+	code, _, rc := promptCode(h, loc.Msgf(i18n.MsgChauthtokCurrentPrompt), params.EchoCode)
+	if rc != Success {
+		return rc
+	}
+	client := daemon.NewClient(params.Daemon)
+	ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+	defer cancel()
+	if _, err := client.Verify(ctx, username, code, "", item(h, ItemService)); err != nil {
+		var rerr *daemon.ResponseError
+		if errors.As(err, &rerr) {
+			event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(service.ErrorClass(rerr.Unwrap()), rerr.Unwrap()), msg(i18n.MsgChauthtokAborted, username, err))
+			h.Error(rerr.Unwrap().Error())
+			return AuthErr
+		}
+		event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(logging.ClassInternal, err), msg(i18n.MsgChauthtokAborted, username, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return AuthtokErr
+	}
+	enrollment, err := client.Enroll(ctx, username, params.EnrollIssuer, true)
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgDaemonFailed, username, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return AuthtokErr
+	}
+	showSecret(h, params, loc, enrollment.URL, enrollment.Secret, enrollment.ScratchCodes)
+	confirm := func(code string) error {
+		cctx, ccancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+		defer ccancel()
+		return client.ConfirmEnroll(cctx, username, code)
+	}
+	if rc := confirmCode(h, params, loc, confirm); rc != Success {
+		return chauthtokFailed(h, username, rc)
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventSecretChanged), msg(i18n.MsgChauthtokCompleted, username, params.Daemon))
+	return Success
+}
+
+// chauthtokFailed maps an unconfirmed new secret to PAM_AUTHTOK_ERR and
+// passes conversation failures through.
+func chauthtokFailed(h Handle, username string, rc Status) Status {
+	if rc != AuthErr {
+		return rc
+	}
+	event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(logging.ClassInvalidCode, authenticator.ErrInvalidCode), msg(i18n.MsgChauthtokAborted, username, authenticator.ErrInvalidCode))
+	return AuthtokErr
+}
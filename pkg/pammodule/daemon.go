@@ -0,0 +1,128 @@
+package pammodule
+
+import (
+	"context"
+	"errors"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/daemon"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+// daemonAuth delegates secret handling to ggpamd: the module only talks to
+// the user, while loading, verifying and updating the secret happens in the
+// daemon under its own privileges.
+func (m *Module) daemonAuth(h Handle, params pamcfg.Params, loc i18n.Localizer, username, rhost string, network pamcfg.NetworkDecision) Status {
+	client := daemon.NewClient(params.Daemon)
+	ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+	defer cancel()
+	debugf(h, params, "using ggpamd at %s", params.Daemon)
+	if params.EnrollOnLogin {
+		syslog(h, LogWarning, msg(i18n.MsgDaemonEnrollUnsupported))
+	}
+
+	if params.NullOK || params.EnrollmentEnforced() {
+		st, err := client.Status(ctx, username)
+		if err != nil && !errors.Is(err, service.ErrUnknownUser) {
+			event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(service.ErrorClass(err), err), msg(i18n.MsgDaemonFailed, username, err))
+			return ServiceErr
+		}
+		if err == nil && !st.Enrolled {
+			if params.EnrollmentEnforced() {
+				event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(resultEnrollGrace), msg(i18n.MsgUserNoSecretEnroll, username))
+			} else {
+				event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(resultNullOK), msg(i18n.MsgUserNoSecretNullOK, username))
+			}
+			return Ignore
+		}
+	}
+	pamService := item(h, ItemService)
+	if rhost != "" && network != pamcfg.NetworkRequired {
+		ok, err := client.Grace(ctx, username, rhost, pamService)
+		if err == nil && ok {
+			event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSkipped).WithResult(MethodGrace), msg(i18n.MsgGraceSkip, rhost))
+			exportMethod(h, MethodGrace, daemonScratch(params, username))
+			return Success
+		}
+		if err != nil {
+			debugf(h, params, "grace check failed: %v", err)
+		}
+	}
+
+	code, remainder, rc := obtainOTP(h, params)
+	if rc != Success {
+		return rc
+	}
+	var result string
+	for attempt := 1; ; attempt++ {
+		// The prompt may have taken a while; give each verification its own budget.
+		vctx, vcancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+		var err error
+		result, err = client.Verify(vctx, username, code, rhost, pamService)
+		vcancel()
+		if err == nil {
+			break
+		}
+		var rerr *daemon.ResponseError
+		if !errors.As(err, &rerr) {
+			event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(logging.ClassInternal, err), msg(i18n.MsgDaemonFailed, username, err))
+			h.Error(loc.Msgf(i18n.MsgInternalError))
+			return AuthErr
+		}
+		switch cause := rerr.Unwrap(); {
+		case errors.Is(cause, authenticator.ErrInvalidCode), errors.Is(cause, authenticator.ErrCodeReused), errors.Is(cause, config.ErrRateLimited):
+			h.Error(cause.Error())
+			event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(service.ErrorClass(cause), cause), msg(i18n.MsgUserAuthFailed, username, err))
+			// ggpamd has already stored the failed attempt.
+			if rc, done := retryExhausted(h, params, username, attempt, cause); done {
+				return rc
+			}
+		case errors.Is(cause, service.ErrNotEnrolled), errors.Is(cause, service.ErrUnknownUser):
+			event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(service.ErrorClass(cause), cause), msg(i18n.MsgUserAuthFailed, username, err))
+			return AuthErr
+		default:
+			event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(service.ErrorClass(cause), cause), msg(i18n.MsgDaemonFailed, username, err))
+			h.Error(loc.Msgf(i18n.MsgInternalError))
+			return AuthErr
+		}
+		m.sleep(params.RetryDelay)
+		if code, remainder, rc = promptForwarded(h, params); rc != Success {
+			return rc
+		}
+	}
+	if params.ForwardPass && remainder != "" {
+		if rc := h.SetItem(ItemAuthtok, remainder); rc != Success {
+			syslog(h, LogWarning, msg(i18n.MsgUpdateAuthtokFailed))
+		}
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventAuthSuccess).WithResult(result), msg(i18n.MsgUserAuthSuccess, username, result))
+	exportMethod(h, result, daemonScratch(params, username))
+	return Success
+}
+
+// daemonScratch asks ggpamd how many scratch codes username has left, or
+// returns -1 if that is unknown.
+func daemonScratch(params pamcfg.Params, username string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+	defer cancel()
+	st, err := daemon.NewClient(params.Daemon).Status(ctx, username)
+	if err != nil {
+		return -1
+	}
+	return st.ScratchCodes
+}
+
+// daemonEnrolled asks ggpamd whether username has a secret.
+func daemonEnrolled(params pamcfg.Params, username string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stateStoreTimeout)
+	defer cancel()
+	st, err := daemon.NewClient(params.Daemon).Status(ctx, username)
+	if err != nil {
+		return false, err
+	}
+	return st.Enrolled, nil
+}
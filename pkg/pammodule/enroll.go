@@ -0,0 +1,113 @@
+package pammodule
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+)
+
+const enrollConfirmAttempts = 3
+
+// enrollOnLogin generates a secret for a user without one, shows it through
+// the PAM conversation and writes it once the user proves the app works.
+// It runs with privileges already dropped to the secret owner.
+func (m *Module) enrollOnLogin(h Handle, params pamcfg.Params, loc i18n.Localizer, account *user.User, username, secretPath string) Status {
+	cfg, err := enroll.NewConfig(enroll.DefaultOptions())
+	if err != nil {
+		event(h, LogErr, eventFor(h, username).With(logging.EventError).WithError(logging.ClassInternal, err), msg(i18n.MsgEnrollAborted, username, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return ServiceErr
+	}
+	h.Info(loc.Msgf(i18n.MsgEnrollOnLoginIntro))
+	showSecret(h, params, loc, enroll.OTPAuthURL(cfg, enroll.DefaultLabel(username), params.EnrollIssuer), cfg.Secret, cfg.ScratchCodes)
+	if rc := confirmCode(h, params, loc, m.checkPending(cfg)); rc != Success {
+		if rc == AuthErr {
+			event(h, LogErr, eventFor(h, username).With(logging.EventAuthFailure).WithError(logging.ClassInvalidCode, authenticator.ErrInvalidCode), msg(i18n.MsgEnrollAborted, username, authenticator.ErrInvalidCode))
+		}
+		return rc
+	}
+	if !storeSecret(h, params, loc, account, cfg, secretPath, pamcfg.FileState{}) {
+		return AuthErr
+	}
+	event(h, LogInfo, eventFor(h, username).With(logging.EventEnrolled), msg(i18n.MsgEnrollCompleted, username, secretPath))
+	return Success
+}
+
+// showSecret presents a new secret the way "ggpam init" does.
+func showSecret(h Handle, params pamcfg.Params, loc i18n.Localizer, url, secret string, scratch []int) {
+	h.Info(loc.Msgf(i18n.MsgCliSetupAddInfo))
+	if qr, err := enroll.QRCodeUTF8(url, false); err == nil {
+		h.Info(qr)
+	} else {
+		debugf(h, params, "QR code rendering failed: %v", err)
+	}
+	h.Info(loc.Msgf(i18n.MsgCliSetupURL, url))
+	h.Info(loc.Msgf(i18n.MsgCliSetupSecret, secret))
+	if len(scratch) > 0 {
+		codes := loc.Msgf(i18n.MsgCliScratchListHeader)
+		for _, sc := range scratch {
+			codes += fmt.Sprintf("\n  %08d", sc)
+		}
+		h.Info(codes)
+	}
+}
+
+// checkPending verifies codes against a secret that is not written yet.
+func (m *Module) checkPending(cfg *config.Config) func(string) error {
+	auth := &authenticator.Authenticator{Now: m.Now}
+	return func(code string) error {
+		_, err := auth.VerifyCode(cfg, code, authenticator.VerifyOptions{DisableSkewAdjustment: true})
+		return err
+	}
+}
+
+// confirmCode asks for a code from the new device until check accepts one,
+// giving up with AuthErr after enrollConfirmAttempts.
+func confirmCode(h Handle, params pamcfg.Params, loc i18n.Localizer, check func(code string) error) Status {
+	for attempt := 0; attempt < enrollConfirmAttempts; attempt++ {
+		code, _, rc := promptCode(h, loc.Msgf(i18n.MsgEnrollConfirmPrompt), params.EchoCode)
+		if rc == ConvErr || rc == Abort {
+			return rc
+		}
+		if rc == Success && check(code) == nil {
+			return Success
+		}
+		h.Error(loc.Msgf(i18n.MsgEnrollCodeIncorrect))
+	}
+	return AuthErr
+}
+
+// storeSecret writes a confirmed secret to secretPath. state describes the
+// file being replaced; the zero value only creates a new file.
+func storeSecret(h Handle, params pamcfg.Params, loc i18n.Localizer, account *user.User, cfg *config.Config, secretPath string, state pamcfg.FileState) bool {
+	if cfg.Options.RateLimit != nil {
+		cfg.Options.RateLimit.Timestamps = nil
+	}
+	data, err := cfg.Bytes()
+	if err != nil {
+		syslog(h, LogErr, msg(i18n.MsgSerializeConfigFailed, err))
+		h.Error(loc.Msgf(i18n.MsgInternalError))
+		return false
+	}
+	if err := pamcfg.WriteConfig(account, secretPath, data, 0o600, state); err != nil {
+		if errors.Is(err, pamcfg.ErrSecretModified) {
+			syslog(h, LogErr, msg(i18n.MsgSecretChangedDuringProcess))
+			h.Error(loc.Msgf(i18n.MsgSecretChangedRetry))
+			return false
+		}
+		syslog(h, LogErr, msg(i18n.MsgWriteConfigFailed, secretPath, err))
+		h.Error(loc.Msgf(i18n.MsgUpdateConfigFailed))
+		return false
+	}
+	if err := applySelinuxContext(secretPath); err != nil {
+		debugf(h, params, "setting SELinux type on %s failed: %v", secretPath, err)
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+// Package pammodule implements the authentication and account management
+// logic of pam_ggpam.so in pure Go. The cgo layer in cmd/pam adapts a
+// pam_handle_t to Handle; tests drive the same code with a fake handle.
+package pammodule
+
+import (
+	"fmt"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+)
+
+// Status is a PAM return code. The values follow Linux-PAM; the cgo layer
+// translates them to the constants of the libpam it is built against.
+type Status int
+
+const (
+	Success     Status = 0
+	ServiceErr  Status = 3
+	PermDenied  Status = 6
+	AuthErr     Status = 7
+	UserUnknown Status = 10
+	MaxTries    Status = 11
+	ConvErr     Status = 19
+	AuthtokErr  Status = 20
+	Ignore      Status = 25
+	Abort       Status = 26
+)
+
+// Item identifies a PAM item readable with pam_get_item.
+type Item int
+
+const (
+	ItemService Item = iota + 1
+	ItemUser
+	ItemRhost
+	ItemAuthtok
+)
+
+// Style is the message style of a conversation prompt.
+type Style int
+
+const (
+	PromptEchoOff Style = iota + 1
+	PromptEchoOn
+)
+
+// Priority is a syslog priority.
+type Priority int
+
+const (
+	LogErr     Priority = 3
+	LogWarning Priority = 4
+	LogInfo    Priority = 6
+	LogDebug   Priority = 7
+)
+
+// Handle is the part of libpam the module uses.
+type Handle interface {
+	// User returns the user name, prompting for it if necessary.
+	User() (string, Status)
+	// Item returns a string item; unset items yield "" with Success.
+	Item(item Item) (string, Status)
+	SetItem(item Item, value string) Status
+	Prompt(style Style, text string) (string, Status)
+	Error(text string)
+	Info(text string)
+	Syslog(priority Priority, text string)
+	// PutEnv sets a variable in the PAM environment of the session.
+	PutEnv(name, value string) Status
+	// GetEnv returns a PAM environment variable, or "" if it is unset.
+	GetEnv(name string) string
+}
+
+// msg localizes log texts, which the administrator reads, in the locale
+// of the process. Texts shown to the user go through userLocalizer.
+func msg(key string, args ...any) string {
+	return i18n.Msgf(key, args...)
+}
+
+// userLocalizer picks the language of the conversation: the LANG option of
+// the secret file cfg once it is loaded, then LC_ALL, LC_MESSAGES or LANG
+// in the PAM environment, then lang=, and last the environment of the
+// process, which for sshd is the daemon's locale rather than the user's.
+func userLocalizer(h Handle, params pamcfg.Params, cfg *config.Config) i18n.Localizer {
+	if cfg != nil && cfg.Options.Lang != "" {
+		return i18n.NewLocalizer(cfg.Options.Lang)
+	}
+	if locale, ok := i18n.EnvLocale(h.GetEnv); ok {
+		return i18n.NewLocalizer(locale)
+	}
+	if params.Lang != "" {
+		return i18n.NewLocalizer(params.Lang)
+	}
+	return i18n.NewLocalizer(i18n.DetectLang())
+}
+
+// syslog writes text to the PAM syslog and to the module log file.
+func syslog(h Handle, priority Priority, text string) {
+	event(h, priority, logging.Event{}, text)
+}
+
+// event is syslog for auditable events: the PAM syslog gets the localized
+// text, the module log also the fields of ev when GGPAM_LOG_FORMAT=json.
+// With GGPAM_LOG_SINK=journald or syslog the module log already reaches
+// the system log, so pam_syslog is skipped. Both paths go through
+// logging.Redact.
+func event(h Handle, priority Priority, ev logging.Event, text string) {
+	logging.Emit(logLevels[priority], ev, text)
+	if !logging.SystemLog() {
+		h.Syslog(priority, logging.Redact(text))
+	}
+}
+
+// eventFor returns the fields shared by the events of one PAM call.
+func eventFor(h Handle, username string) logging.Event {
+	return logging.Event{User: username, Rhost: item(h, ItemRhost), Service: item(h, ItemService)}
+}
+
+var logLevels = map[Priority]logging.Level{
+	LogErr:     logging.LevelError,
+	LogWarning: logging.LevelWarn,
+	LogInfo:    logging.LevelInfo,
+	LogDebug:   logging.LevelDebug,
+}
+
+func debugf(h Handle, params pamcfg.Params, format string, args ...any) {
+	if !params.Debug {
+		return
+	}
+	syslog(h, LogDebug, fmt.Sprintf("debug: "+format, args...))
+}
+
+func item(h Handle, it Item) string {
+	v, rc := h.Item(it)
+	if rc != Success {
+		return ""
+	}
+	return v
+}
@@ -0,0 +1,704 @@
+package pammodule
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/otp"
+	pamcfg "ggpam/pkg/pam"
+)
+
+// fakeHandle records the conversation and answers prompts from a script.
+type fakeHandle struct {
+	user     string
+	items    map[Item]string
+	env      map[string]string
+	answers  []string
+	onPrompt func()
+
+	prompts []string
+	errors  []string
+	infos   []string
+	logs    []string
+}
+
+func newFakeHandle(username string) *fakeHandle {
+	return &fakeHandle{user: username, items: map[Item]string{ItemService: "sshd"}, env: map[string]string{}}
+}
+
+func (h *fakeHandle) User() (string, Status) { return h.user, Success }
+
+func (h *fakeHandle) Item(it Item) (string, Status) { return h.items[it], Success }
+
+func (h *fakeHandle) SetItem(it Item, value string) Status {
+	h.items[it] = value
+	return Success
+}
+
+func (h *fakeHandle) Prompt(style Style, text string) (string, Status) {
+	h.prompts = append(h.prompts, text)
+	if h.onPrompt != nil {
+		h.onPrompt()
+	}
+	if len(h.answers) == 0 {
+		return "", ConvErr
+	}
+	answer := h.answers[0]
+	h.answers = h.answers[1:]
+	return answer, Success
+}
+
+func (h *fakeHandle) Error(text string) { h.errors = append(h.errors, text) }
+
+func (h *fakeHandle) Info(text string) { h.infos = append(h.infos, text) }
+
+func (h *fakeHandle) Syslog(priority Priority, text string) { h.logs = append(h.logs, text) }
+
+func (h *fakeHandle) PutEnv(name, value string) Status {
+	h.env[name] = value
+	return Success
+}
+
+func (h *fakeHandle) GetEnv(name string) string { return h.env[name] }
+
+type fixture struct {
+	t      *testing.T
+	module *Module
+	dir    string
+	now    time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	f := &fixture{t: t, dir: t.TempDir(), now: time.Unix(1_700_000_000, 0)}
+	f.module = &Module{
+		Now: func() time.Time { return f.now },
+		Lookup: func(name string) (*user.User, error) {
+			if name == "ghost" {
+				return nil, user.UnknownUserError(name)
+			}
+			u := *current
+			u.Username = name
+			u.HomeDir = f.dir
+			return &u, nil
+		},
+	}
+	return f
+}
+
+func (f *fixture) params(args ...string) pamcfg.Params {
+	f.t.Helper()
+	params, err := pamcfg.ParseParams(append([]string{"secret=" + filepath.Join(f.dir, "%u")}, args...))
+	if err != nil {
+		f.t.Fatalf("parse params: %v", err)
+	}
+	return params
+}
+
+// enroll writes a fresh secret for username and returns its config.
+func (f *fixture) enroll(username string) *config.Config {
+	f.t.Helper()
+	cfg, err := enroll.NewConfig(enroll.DefaultOptions())
+	if err != nil {
+		f.t.Fatalf("new config: %v", err)
+	}
+	f.writeSecret(username, cfg)
+	return cfg
+}
+
+func (f *fixture) writeSecret(username string, cfg *config.Config) {
+	f.t.Helper()
+	data, err := cfg.Bytes()
+	if err != nil {
+		f.t.Fatalf("serialize: %v", err)
+	}
+	// Write a new inode so that a concurrent login notices the change.
+	tmp := filepath.Join(f.dir, "."+username+".new")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		f.t.Fatalf("write secret: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, username)); err != nil {
+		f.t.Fatalf("rename secret: %v", err)
+	}
+}
+
+func (f *fixture) readSecret(username string) string {
+	f.t.Helper()
+	data, err := os.ReadFile(filepath.Join(f.dir, username))
+	if err != nil {
+		f.t.Fatalf("read secret: %v", err)
+	}
+	return string(data)
+}
+
+func (f *fixture) code(cfg *config.Config) string {
+	f.t.Helper()
+	key, err := cfg.SecretBytes()
+	if err != nil {
+		f.t.Fatalf("decode secret: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(f.now.Unix()/config.DefaultStepSize)))
+}
+
+func TestAuthenticatePrompt(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+
+	h := newFakeHandle("alice")
+	h.answers = []string{" " + f.code(cfg) + " "}
+	if rc := f.module.Authenticate(h, f.params()); rc != Success {
+		t.Fatalf("Authenticate = %d, errors %v, logs %v", rc, h.errors, h.logs)
+	}
+	if len(h.prompts) != 1 || h.prompts[0] != f.params().Prompt {
+		t.Fatalf("prompts = %q", h.prompts)
+	}
+	if !strings.Contains(f.readSecret("alice"), "DISALLOW_REUSE "+fmt.Sprint(f.now.Unix()/config.DefaultStepSize)) {
+		t.Fatalf("used code not recorded:\n%s", f.readSecret("alice"))
+	}
+
+	// The same code is now rejected and the user is told why.
+	h = newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr || len(h.errors) != 1 {
+		t.Fatalf("reused code: rc=%d errors=%v", rc, h.errors)
+	}
+
+	h = newFakeHandle("alice")
+	if rc := f.module.Authenticate(h, f.params()); rc != ConvErr {
+		t.Fatalf("failed conversation: rc=%d", rc)
+	}
+}
+
+func TestAuthenticateTryFirstPass(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+
+	h := newFakeHandle("alice")
+	h.items[ItemAuthtok] = "hunter2" + f.code(cfg)
+	if rc := f.module.Authenticate(h, f.params("try_first_pass", "forward_pass")); rc != Success {
+		t.Fatalf("Authenticate = %d, logs %v", rc, h.logs)
+	}
+	if len(h.prompts) != 0 {
+		t.Fatalf("unexpected prompts %q", h.prompts)
+	}
+	if got := h.items[ItemAuthtok]; got != "hunter2" {
+		t.Fatalf("forwarded authtok = %q, want hunter2", got)
+	}
+
+	// Without a code in PAM_AUTHTOK, try_first_pass falls back to a prompt.
+	f.now = f.now.Add(time.Minute)
+	h = newFakeHandle("alice")
+	h.items[ItemAuthtok] = "hunter2"
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("try_first_pass", "forward_pass")); rc != Success || len(h.prompts) != 1 {
+		t.Fatalf("fallback prompt: rc=%d prompts=%q", rc, h.prompts)
+	}
+	if got := h.items[ItemAuthtok]; got != "hunter2" {
+		t.Fatalf("authtok changed to %q", got)
+	}
+
+	// With forward_pass alone the prompt asks for both and splits the answer.
+	f.now = f.now.Add(time.Minute)
+	h = newFakeHandle("alice")
+	h.answers = []string{"hunter2" + f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("forward_pass")); rc != Success || h.items[ItemAuthtok] != "hunter2" {
+		t.Fatalf("forward_pass prompt: rc=%d authtok=%q logs=%v", rc, h.items[ItemAuthtok], h.logs)
+	}
+
+	// use_first_pass never prompts.
+	h = newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("use_first_pass")); rc != AuthtokErr || len(h.prompts) != 0 {
+		t.Fatalf("use_first_pass without authtok: rc=%d prompts=%q", rc, h.prompts)
+	}
+}
+
+func TestAuthenticateFailureIsPersisted(t *testing.T) {
+	f := newFixture(t)
+	f.enroll("alice")
+
+	h := newFakeHandle("alice")
+	h.answers = []string{"000000"}
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr {
+		t.Fatalf("wrong code: rc=%d", rc)
+	}
+	if !strings.Contains(f.readSecret("alice"), fmt.Sprint(f.now.Unix())) {
+		t.Fatalf("failed attempt not counted:\n%s", f.readSecret("alice"))
+	}
+}
+
+func TestAuthenticateGrace(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	params := f.params("grace_period=300")
+
+	h := newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.7"
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, params); rc != Success {
+		t.Fatalf("first login: rc=%d logs=%v", rc, h.logs)
+	}
+
+	f.now = f.now.Add(2 * time.Minute)
+	h = newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.7"
+	if rc := f.module.Authenticate(h, params); rc != Success || len(h.prompts) != 0 {
+		t.Fatalf("login within grace: rc=%d prompts=%q", rc, h.prompts)
+	}
+
+	h = newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.8"
+	if rc := f.module.Authenticate(h, params); rc != ConvErr || len(h.prompts) != 1 {
+		t.Fatalf("other host: rc=%d prompts=%q", rc, h.prompts)
+	}
+
+	f.now = f.now.Add(10 * time.Minute)
+	h = newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.7"
+	if rc := f.module.Authenticate(h, params); rc != ConvErr || len(h.prompts) != 1 {
+		t.Fatalf("after grace period: rc=%d prompts=%q", rc, h.prompts)
+	}
+}
+
+func TestAuthenticateNullOK(t *testing.T) {
+	f := newFixture(t)
+
+	h := newFakeHandle("bob")
+	if rc := f.module.Authenticate(h, f.params("nullok")); rc != Ignore || len(h.prompts) != 0 {
+		t.Fatalf("nullok without secret: rc=%d prompts=%q", rc, h.prompts)
+	}
+	h = newFakeHandle("bob")
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr || len(h.errors) != 1 {
+		t.Fatalf("missing secret: rc=%d errors=%v", rc, h.errors)
+	}
+}
+
+func TestAuthenticateConcurrentModification(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	code := f.code(cfg)
+
+	// Another login rewrites the secret while this one waits for the code.
+	h := newFakeHandle("alice")
+	h.answers = []string{code}
+	h.onPrompt = func() { f.writeSecret("alice", cfg) }
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr {
+		t.Fatalf("Authenticate = %d, want AuthErr", rc)
+	}
+	if len(h.errors) != 1 || h.errors[0] != msg(i18n.MsgSecretChangedRetry) {
+		t.Fatalf("errors = %q", h.errors)
+	}
+	if strings.Contains(f.readSecret("alice"), "DISALLOW_REUSE "+fmt.Sprint(f.now.Unix()/config.DefaultStepSize)) {
+		t.Fatal("concurrently written secret was overwritten")
+	}
+}
+
+func TestAuthenticateReadonly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	if err := os.Chmod(f.dir, 0o500); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	t.Cleanup(func() { os.Chmod(f.dir, 0o700) })
+
+	h := newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr {
+		t.Fatalf("read-only without allow_readonly: rc=%d", rc)
+	}
+	f.now = f.now.Add(time.Minute)
+	h = newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("allow_readonly")); rc != Success {
+		t.Fatalf("read-only with allow_readonly: rc=%d logs=%v", rc, h.logs)
+	}
+}
+
+func TestAuthenticateForcedAndUnknownUser(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("shared")
+
+	h := newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("user=shared")); rc != Success {
+		t.Fatalf("forced user: rc=%d logs=%v", rc, h.logs)
+	}
+
+	// Unknown users fall back to nobody so that probing reveals nothing.
+	h = newFakeHandle("ghost")
+	if rc := f.module.Authenticate(h, f.params()); rc != AuthErr {
+		t.Fatalf("unknown user: rc=%d", rc)
+	}
+}
+
+func TestAcctMgmt(t *testing.T) {
+	f := newFixture(t)
+	f.enroll("alice")
+	params := f.params("enroll_grace=7d", "enroll_state="+filepath.Join(f.dir, "enroll"))
+
+	if rc := f.module.AcctMgmt(newFakeHandle("alice"), f.params()); rc != Ignore {
+		t.Fatalf("without enrollment policy: rc=%d", rc)
+	}
+	if rc := f.module.AcctMgmt(newFakeHandle("alice"), params); rc != Success {
+		t.Fatalf("enrolled user: rc=%d", rc)
+	}
+	h := newFakeHandle("bob")
+	if rc := f.module.AcctMgmt(h, params); rc != Success || len(h.infos) != 1 {
+		t.Fatalf("pending enrollment: rc=%d infos=%q", rc, h.infos)
+	}
+	f.now = f.now.Add(8 * 24 * time.Hour)
+	h = newFakeHandle("bob")
+	if rc := f.module.AcctMgmt(h, params); rc != PermDenied || len(h.errors) != 1 {
+		t.Fatalf("expired enrollment: rc=%d errors=%q", rc, h.errors)
+	}
+	if rc := f.module.AcctMgmt(newFakeHandle("ghost"), params); rc != UserUnknown {
+		t.Fatalf("unknown user: rc=%d", rc)
+	}
+}
+
+func TestChauthtok(t *testing.T) {
+	f := newFixture(t)
+	old := f.enroll("alice")
+
+	if rc := f.module.Chauthtok(newFakeHandle("alice"), f.params(), PrelimCheck); rc != Success {
+		t.Fatalf("prelim check: rc=%d", rc)
+	}
+	if rc := f.module.Chauthtok(newFakeHandle("alice"), f.params(), UpdateAuthtok|ChangeExpiredAuthtok); rc != Ignore {
+		t.Fatalf("expired authtok change: rc=%d", rc)
+	}
+
+	// A wrong current code leaves the secret alone.
+	h := newFakeHandle("alice")
+	h.answers = []string{"000000"}
+	if rc := f.module.Chauthtok(h, f.params(), UpdateAuthtok); rc != AuthErr || len(h.prompts) != 1 {
+		t.Fatalf("wrong current code: rc=%d prompts=%q", rc, h.prompts)
+	}
+
+	// The confirmation prompt is answered with a code for the secret shown.
+	h = newFakeHandle("alice")
+	h.answers = []string{f.code(old)}
+	h.onPrompt = func() {
+		for _, info := range h.infos {
+			if secret, ok := strings.CutPrefix(info, i18n.Msgf(i18n.MsgCliSetupSecret, "")); ok {
+				h.answers = []string{f.code(&config.Config{Secret: secret})}
+			}
+		}
+	}
+	if rc := f.module.Chauthtok(h, f.params(), UpdateAuthtok); rc != Success {
+		t.Fatalf("Chauthtok = %d, errors %v, logs %v", rc, h.errors, h.logs)
+	}
+	if len(h.prompts) != 2 {
+		t.Fatalf("prompts = %q", h.prompts)
+	}
+	if strings.Contains(f.readSecret("alice"), old.Secret) {
+		t.Fatal("secret was not replaced")
+	}
+	if !strings.Contains(f.readSecret("alice"), "RATE_LIMIT 3 30") {
+		t.Fatalf("options not kept:\n%s", f.readSecret("alice"))
+	}
+
+	h = newFakeHandle("bob")
+	if rc := f.module.Chauthtok(h, f.params(), UpdateAuthtok); rc != Ignore || len(h.prompts) != 0 {
+		t.Fatalf("user without secret: rc=%d prompts=%q", rc, h.prompts)
+	}
+}
+
+func TestConversationLocale(t *testing.T) {
+	f := newFixture(t)
+	// The process runs in the locale of sshd, which only the logs use.
+	t.Setenv("LC_ALL", "zh_CN.UTF-8")
+	cfg := f.enroll("alice")
+	withLang := *cfg
+	withLang.Options.Lang = "ja_JP.UTF-8"
+
+	for _, tc := range []struct {
+		name    string
+		env     string
+		args    []string
+		prefers bool
+		want    string
+	}{
+		{name: "process", want: "zh_CN"},
+		{name: "param", args: []string{"lang=fr"}, want: "fr"},
+		{name: "pam env", env: "de_CH.UTF-8", args: []string{"lang=fr"}, want: "de_CH"},
+		{name: "secret file", env: "de_CH.UTF-8", args: []string{"lang=fr"}, prefers: true, want: "ja_JP"},
+	} {
+		if tc.prefers {
+			f.writeSecret("alice", &withLang)
+		} else {
+			f.writeSecret("alice", cfg)
+		}
+		h := newFakeHandle("alice")
+		if tc.env != "" {
+			h.env["LANG"] = tc.env
+		}
+		h.answers = []string{"000000"}
+		if rc := f.module.Chauthtok(h, f.params(tc.args...), UpdateAuthtok); rc != AuthErr {
+			t.Fatalf("%s: rc=%d", tc.name, rc)
+		}
+		loc := i18n.NewLocalizer(tc.want)
+		if len(h.infos) != 1 || h.infos[0] != loc.Resolve(i18n.MsgChauthtokIntro) {
+			t.Errorf("%s: infos %q, want %s", tc.name, h.infos, tc.want)
+		}
+		if len(h.prompts) != 1 || h.prompts[0] != loc.Resolve(i18n.MsgChauthtokCurrentPrompt) {
+			t.Errorf("%s: prompts %q, want %s", tc.name, h.prompts, tc.want)
+		}
+		logged := i18n.Msgf(i18n.MsgChauthtokAborted, "alice", "")
+		if !slices.ContainsFunc(h.logs, func(l string) bool { return strings.HasPrefix(l, logged) }) {
+			t.Errorf("%s: logs %q not in the process locale", tc.name, h.logs)
+		}
+	}
+	if _, err := pamcfg.ParseParams([]string{"lang=C"}); err == nil {
+		t.Fatal("lang=C accepted")
+	}
+}
+
+func TestChauthtokUnconfirmed(t *testing.T) {
+	f := newFixture(t)
+	for username, code := range map[string]func(*config.Config) string{
+		"alice": f.code,
+		"bob":   func(cfg *config.Config) string { return fmt.Sprintf("%08d", cfg.ScratchCodes[0]) },
+	} {
+		old := f.enroll(username)
+		used := code(old)
+
+		h := newFakeHandle(username)
+		h.answers = []string{used, "000000", "000000", "000000"}
+		if rc := f.module.Chauthtok(h, f.params(), UpdateAuthtok); rc != AuthtokErr || len(h.errors) != enrollConfirmAttempts {
+			t.Fatalf("%s: unconfirmed secret: rc=%d errors=%q", username, rc, h.errors)
+		}
+		cfg, err := config.Parse(strings.NewReader(f.readSecret(username)))
+		if err != nil {
+			t.Fatalf("%s: parse secret: %v", username, err)
+		}
+		if cfg.Secret != old.Secret {
+			t.Fatalf("%s: secret replaced without confirmation", username)
+		}
+
+		// The code spent on the aborted change must not work again.
+		h = newFakeHandle(username)
+		h.answers = []string{used}
+		if rc := f.module.Authenticate(h, f.params()); rc == Success {
+			t.Fatalf("%s: code %s accepted after the aborted change", username, used)
+		}
+	}
+}
+
+func TestAuthenticateExportsMethod(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	params := f.params("grace_period=300")
+
+	h := newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.7"
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, params); rc != Success {
+		t.Fatalf("Authenticate = %d, logs %v", rc, h.logs)
+	}
+	want := map[string]string{EnvMethod: "totp", EnvGrace: "0", EnvScratchRemaining: "5"}
+	if fmt.Sprint(h.env) != fmt.Sprint(want) {
+		t.Fatalf("env = %v, want %v", h.env, want)
+	}
+
+	h = newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.8"
+	h.answers = []string{fmt.Sprintf("%08d", cfg.ScratchCodes[0])}
+	if rc := f.module.Authenticate(h, params); rc != Success {
+		t.Fatalf("scratch code: rc=%d logs=%v", rc, h.logs)
+	}
+	if h.env[EnvMethod] != "scratch" || h.env[EnvScratchRemaining] != "4" {
+		t.Fatalf("scratch code env = %v", h.env)
+	}
+
+	h = newFakeHandle("alice")
+	h.items[ItemRhost] = "198.51.100.7"
+	if rc := f.module.Authenticate(h, params); rc != Success {
+		t.Fatalf("grace login: rc=%d", rc)
+	}
+	if h.env[EnvMethod] != MethodGrace || h.env[EnvGrace] != "1" || h.env[EnvScratchRemaining] != "4" {
+		t.Fatalf("grace env = %v", h.env)
+	}
+
+	h = newFakeHandle("carol")
+	if rc := f.module.Authenticate(h, f.params("exempt_user=carol")); rc != Ignore || h.env[EnvMethod] != MethodExempt {
+		t.Fatalf("exempt user: rc=%d env=%v", rc, h.env)
+	}
+}
+
+func TestOpenSession(t *testing.T) {
+	f := newFixture(t)
+	for _, tc := range []struct {
+		remaining string
+		args      []string
+		warn      bool
+	}{
+		{remaining: "", warn: false},
+		{remaining: "3", warn: false},
+		{remaining: "2", warn: true},
+		{remaining: "0", warn: true},
+		{remaining: "3", args: []string{"scratch_warn=3"}, warn: true},
+		{remaining: "0", args: []string{"scratch_warn=0"}, warn: false},
+	} {
+		h := newFakeHandle("alice")
+		if tc.remaining != "" {
+			h.env[EnvScratchRemaining] = tc.remaining
+		}
+		if rc := f.module.OpenSession(h, f.params(tc.args...)); rc != Success {
+			t.Fatalf("%q %v: rc=%d", tc.remaining, tc.args, rc)
+		}
+		if got := len(h.infos) == 1; got != tc.warn {
+			t.Fatalf("%q %v: infos %q", tc.remaining, tc.args, h.infos)
+		}
+	}
+}
+
+func TestAuthenticateRetries(t *testing.T) {
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	var slept []time.Duration
+	f.module.Sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	h := newFakeHandle("alice")
+	h.answers = []string{"000000", "111111", f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params("retries=2", "retry_delay=2")); rc != Success {
+		t.Fatalf("third attempt: rc=%d logs=%v", rc, h.logs)
+	}
+	if len(h.prompts) != 3 || len(h.errors) != 2 || fmt.Sprint(slept) != "[2s 2s]" {
+		t.Fatalf("prompts=%q errors=%q slept=%v", h.prompts, h.errors, slept)
+	}
+
+	// Every failed attempt is stored before the next prompt.
+	f.now = f.now.Add(time.Minute)
+	h = newFakeHandle("alice")
+	h.answers = []string{"000000", "111111", "222222"}
+	var counted []int
+	h.onPrompt = func() {
+		counted = append(counted, strings.Count(f.readSecret("alice"), " "+fmt.Sprint(f.now.Unix())))
+	}
+	if rc := f.module.Authenticate(h, f.params("retries=1")); rc != MaxTries || len(h.prompts) != 2 {
+		t.Fatalf("exhausted retries: rc=%d prompts=%q", rc, h.prompts)
+	}
+	if fmt.Sprint(counted) != "[0 1]" {
+		t.Fatalf("attempts stored before each prompt: %v\n%s", counted, f.readSecret("alice"))
+	}
+
+	// RATE_LIMIT (3 per 30s) ends the loop early with PAM_AUTH_ERR.
+	f.now = f.now.Add(time.Minute)
+	h = newFakeHandle("alice")
+	h.answers = []string{"000000", "111111", "222222", "333333", "444444"}
+	if rc := f.module.Authenticate(h, f.params("retries=5")); rc != AuthErr || len(h.prompts) != 4 {
+		t.Fatalf("rate limited: rc=%d prompts=%q errors=%q", rc, h.prompts, h.errors)
+	}
+}
+
+func TestSystemLogSinkReplacesPamSyslog(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "journal")
+	conn, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv(logging.LogSink, "journald:"+sock)
+	if err := logging.ConfigureDefault(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv(logging.LogSink)
+		logging.ConfigureDefault("")
+	})
+
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	h := newFakeHandle("alice")
+	h.answers = []string{f.code(cfg)}
+	if rc := f.module.Authenticate(h, f.params()); rc != Success {
+		t.Fatalf("Authenticate = %d", rc)
+	}
+	if len(h.logs) != 0 {
+		t.Fatalf("pam_syslog used alongside the journal: %v", h.logs)
+	}
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("no auth_success entry: %v", err)
+		}
+		if strings.Contains(string(buf[:n]), "GGPAM_EVENT=auth_success\n") {
+			return
+		}
+	}
+}
+
+func TestLogsNeverContainSecrets(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "ggpam.log")
+	t.Setenv(logging.LogPath, logPath)
+	t.Setenv(logging.LogFormat, "json")
+	t.Setenv(logging.LogLevel, "debug")
+	if err := logging.ConfigureDefault(""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Unsetenv(logging.LogPath)
+		os.Unsetenv(logging.LogFormat)
+		os.Unsetenv(logging.LogLevel)
+		logging.ConfigureDefault("")
+	})
+
+	f := newFixture(t)
+	cfg := f.enroll("alice")
+	const password = "correct-horse"
+
+	// A password typed at the code prompt is rejected for its length
+	// without being quoted.
+	h := newFakeHandle("alice")
+	h.answers = []string{password}
+	if rc := f.module.Authenticate(h, f.params("debug")); rc != AuthErr {
+		t.Fatalf("password as code: rc=%d", rc)
+	}
+	outputs := [][]string{h.logs, h.errors}
+
+	// password+code in PAM_AUTHTOK, once wrong and once right.
+	for _, code := range []string{"000000", f.code(cfg)} {
+		h = newFakeHandle("alice")
+		h.items[ItemAuthtok] = password + code
+		f.module.Authenticate(h, f.params("debug", "use_first_pass", "forward_pass"))
+		outputs = append(outputs, h.logs, h.errors)
+		f.now = f.now.Add(time.Minute)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs = append(outputs, []string{string(data)})
+	if !strings.Contains(string(data), `"error_class":"invalid_code"`) {
+		t.Fatalf("wrong-length code not classified as invalid_code:\n%s", data)
+	}
+	for _, out := range outputs {
+		joined := strings.Join(out, "\n")
+		for _, planted := range []string{password, "000000", cfg.Secret} {
+			if strings.Contains(joined, planted) {
+				t.Errorf("output leaks %q:\n%s", planted, joined)
+			}
+		}
+	}
+}
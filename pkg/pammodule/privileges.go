@@ -0,0 +1,51 @@
+package pammodule
+
+import (
+	"os/user"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+type privilegeState struct {
+	origUID int
+	origGID int
+	dropped bool
+}
+
+// dropPrivileges switches the effective IDs to account; it is a no-op when
+// they already match, which is the case in tests.
+func dropPrivileges(account *user.User) (*privilegeState, error) {
+	state := &privilegeState{
+		origUID: unix.Geteuid(),
+		origGID: unix.Getegid(),
+	}
+	uid, err := strconv.Atoi(account.Uid)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.Atoi(account.Gid)
+	if err != nil {
+		return nil, err
+	}
+	if state.origUID == uid && state.origGID == gid {
+		return state, nil
+	}
+	if err := unix.Setresgid(-1, gid, -1); err != nil {
+		return nil, err
+	}
+	if err := unix.Setresuid(-1, uid, -1); err != nil {
+		unix.Setresgid(-1, state.origGID, -1)
+		return nil, err
+	}
+	state.dropped = true
+	return state, nil
+}
+
+func restorePrivileges(state *privilegeState) {
+	if state == nil || !state.dropped {
+		return
+	}
+	unix.Setresuid(-1, state.origUID, -1)
+	unix.Setresgid(-1, state.origGID, -1)
+}
@@ -1,11 +1,4 @@
-package main
-
-/*
-#cgo LDFLAGS: -lpam
-#include <security/pam_appl.h>
-#include <security/pam_modules.h>
-*/
-import "C"
+package pammodule
 
 import (
 	"bytes"
@@ -15,7 +8,6 @@ import (
 	"os/user"
 	"strings"
 	"text/template"
-	"unsafe"
 
 	"golang.org/x/sys/unix"
 
@@ -31,12 +23,15 @@ type promptContext struct {
 	Service string
 }
 
+// PromptContextProvider builds the data available to prompt templates.
 type PromptContextProvider interface {
-	Build(pamh *C.pam_handle_t, account *user.User, user, host string) promptContext
+	Build(h Handle, account *user.User, user, host string) promptContext
 }
 
 var promptProvider PromptContextProvider = defaultPromptProvider{}
 
+// RegisterPromptContextProvider replaces the provider used for
+// prompt_template.
 func RegisterPromptContextProvider(p PromptContextProvider) {
 	if p != nil {
 		promptProvider = p
@@ -45,15 +40,15 @@ func RegisterPromptContextProvider(p PromptContextProvider) {
 
 type defaultPromptProvider struct{}
 
-func (defaultPromptProvider) Build(pamh *C.pam_handle_t, account *user.User, user, host string) promptContext {
+func (defaultPromptProvider) Build(h Handle, account *user.User, user, host string) promptContext {
 	return promptContext{
 		User:    user,
 		Host:    host,
-		Service: getPamService(pamh),
+		Service: item(h, ItemService),
 	}
 }
 
-func preparePromptFromTemplate(pamh *C.pam_handle_t, pathSpec string, account *user.User, user, host string) (string, error) {
+func preparePromptFromTemplate(h Handle, pathSpec string, account *user.User, user, host string) (string, error) {
 	tmplPath, err := pamcfg.ResolveSecretPath(pathSpec, account)
 	if err != nil {
 		return "", err
@@ -62,7 +57,7 @@ func preparePromptFromTemplate(pamh *C.pam_handle_t, pathSpec string, account *u
 	if err != nil {
 		return "", err
 	}
-	return renderPromptTemplate(raw, promptProvider.Build(pamh, account, user, host))
+	return renderPromptTemplate(raw, promptProvider.Build(h, account, user, host))
 }
 
 func loadPromptTemplate(path string) (string, error) {
@@ -93,11 +88,3 @@ func renderPromptTemplate(raw string, ctx promptContext) (string, error) {
 	}
 	return buf.String(), nil
 }
-
-func getPamService(pamh *C.pam_handle_t) string {
-	var item unsafe.Pointer
-	if C.pam_get_item(pamh, C.PAM_SERVICE, &item) != C.PAM_SUCCESS || item == nil {
-		return ""
-	}
-	return C.GoString((*C.char)(item))
-}
@@ -1,4 +1,4 @@
-package main
+package pammodule
 
 import (
 	"github.com/opencontainers/selinux/go-selinux"
@@ -0,0 +1,70 @@
+package pammodule
+
+import (
+	"strconv"
+
+	"ggpam/pkg/i18n"
+	pamcfg "ggpam/pkg/pam"
+)
+
+// PAM environment variables describing how the user authenticated. They are
+// visible to later modules and, through pam_getenvlist, to the session.
+const (
+	EnvMethod           = "GGPAM_METHOD"
+	EnvScratchRemaining = "GGPAM_SCRATCH_REMAINING"
+	EnvGrace            = "GGPAM_GRACE"
+)
+
+// Values of GGPAM_METHOD besides the authenticator result types "totp",
+// "hotp" and "scratch".
+const (
+	MethodGrace   = "grace"
+	MethodExempt  = "exempt"
+	MethodTrusted = "trusted_network"
+)
+
+// Results of skipped authentications that are logged but not exported,
+// since the module returns PAM_IGNORE for them.
+const (
+	resultNullOK      = "nullok"
+	resultEnrollGrace = "enroll_pending"
+)
+
+// exportMethod records the authentication method in the PAM environment.
+// A negative scratch count leaves GGPAM_SCRATCH_REMAINING unset.
+func exportMethod(h Handle, method string, scratch int) {
+	grace := "0"
+	if method == MethodGrace {
+		grace = "1"
+	}
+	putEnv(h, EnvMethod, method)
+	putEnv(h, EnvGrace, grace)
+	if scratch >= 0 {
+		putEnv(h, EnvScratchRemaining, strconv.Itoa(scratch))
+	}
+}
+
+func putEnv(h Handle, name, value string) {
+	if rc := h.PutEnv(name, value); rc != Success {
+		syslog(h, LogWarning, msg(i18n.MsgPutEnvFailed, name))
+	}
+}
+
+// OpenSession implements pam_sm_open_session: it warns when the login used
+// a secret with scratch_warn or fewer scratch codes left, as reported by
+// Authenticate through GGPAM_SCRATCH_REMAINING.
+func (m *Module) OpenSession(h Handle, params pamcfg.Params) Status {
+	value := h.GetEnv(EnvScratchRemaining)
+	if value == "" || params.ScratchWarn == 0 {
+		return Success
+	}
+	remaining, err := strconv.Atoi(value)
+	if err != nil {
+		debugf(h, params, "ignoring %s=%q: %v", EnvScratchRemaining, value, err)
+		return Success
+	}
+	if remaining <= params.ScratchWarn {
+		h.Info(userLocalizer(h, params, nil).Format(i18n.MsgScratchLow, i18n.Args{"count": remaining}))
+	}
+	return Success
+}
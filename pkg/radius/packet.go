@@ -0,0 +1,234 @@
+// Package radius implements the subset of RFC 2865 needed to verify codes
+// for network devices: PAP Access-Request, Access-Accept, Access-Reject and
+// Access-Challenge, plus the Message-Authenticator of RFC 3579.
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type Code byte
+
+const (
+	CodeAccessRequest   Code = 1
+	CodeAccessAccept    Code = 2
+	CodeAccessReject    Code = 3
+	CodeAccessChallenge Code = 11
+)
+
+type AttributeType byte
+
+const (
+	AttrUserName             AttributeType = 1
+	AttrUserPassword         AttributeType = 2
+	AttrReplyMessage         AttributeType = 18
+	AttrState                AttributeType = 24
+	AttrMessageAuthenticator AttributeType = 80
+)
+
+const (
+	headerLength  = 20
+	maxPacketSize = 4096
+	maxPassword   = 128
+)
+
+var (
+	ErrMalformed              = errors.New("malformed RADIUS packet")
+	ErrMessageAuthenticator   = errors.New("invalid Message-Authenticator")
+	ErrNoMessageAuthenticator = errors.New("missing Message-Authenticator")
+	errPasswordLength         = errors.New("User-Password length must be a non-zero multiple of 16 up to 128")
+	zeroMessageAuthenticator  = make([]byte, 16)
+)
+
+type Attribute struct {
+	Type  AttributeType
+	Value []byte
+}
+
+// Packet is a decoded RADIUS packet.
+type Packet struct {
+	Code          Code
+	Identifier    byte
+	Authenticator [16]byte
+	Attributes    []Attribute
+}
+
+// Parse decodes a packet received from the network.
+func Parse(data []byte) (*Packet, error) {
+	if len(data) < headerLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformed, len(data))
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < headerLength || length > maxPacketSize || length > len(data) {
+		return nil, fmt.Errorf("%w: length %d", ErrMalformed, length)
+	}
+	p := &Packet{Code: Code(data[0]), Identifier: data[1]}
+	copy(p.Authenticator[:], data[4:20])
+	rest := data[headerLength:length]
+	for len(rest) > 0 {
+		if len(rest) < 2 || int(rest[1]) < 2 || int(rest[1]) > len(rest) {
+			return nil, fmt.Errorf("%w: truncated attribute", ErrMalformed)
+		}
+		n := int(rest[1])
+		p.Attributes = append(p.Attributes, Attribute{Type: AttributeType(rest[0]), Value: append([]byte(nil), rest[2:n]...)})
+		rest = rest[n:]
+	}
+	return p, nil
+}
+
+// Get returns the first attribute of type t.
+func (p *Packet) Get(t AttributeType) ([]byte, bool) {
+	for _, a := range p.Attributes {
+		if a.Type == t {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Add appends an attribute.
+func (p *Packet) Add(t AttributeType, value []byte) {
+	p.Attributes = append(p.Attributes, Attribute{Type: t, Value: value})
+}
+
+// Encode serializes the packet as is, without computing authenticators.
+func (p *Packet) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write([]byte{byte(p.Code), p.Identifier, 0, 0})
+	buf.Write(p.Authenticator[:])
+	for _, a := range p.Attributes {
+		if len(a.Value) > 253 {
+			return nil, fmt.Errorf("attribute %d too long: %d bytes", a.Type, len(a.Value))
+		}
+		buf.WriteByte(byte(a.Type))
+		buf.WriteByte(byte(len(a.Value) + 2))
+		buf.Write(a.Value)
+	}
+	data := buf.Bytes()
+	if len(data) > maxPacketSize {
+		return nil, fmt.Errorf("packet too large: %d bytes", len(data))
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	return data, nil
+}
+
+// Response builds a reply to p. Reply encodes it, including the Response
+// Authenticator and a Message-Authenticator.
+func (p *Packet) Response(code Code) *Packet {
+	return &Packet{Code: code, Identifier: p.Identifier, Authenticator: p.Authenticator}
+}
+
+// Reply encodes a response whose Authenticator field still holds the request
+// authenticator, as returned by Response.
+func (p *Packet) Reply(secret []byte) ([]byte, error) {
+	p.Add(AttrMessageAuthenticator, zeroMessageAuthenticator)
+	data, err := p.Encode()
+	if err != nil {
+		return nil, err
+	}
+	// Message-Authenticator covers the packet with the request authenticator
+	// in place; the Response Authenticator is computed afterwards over the
+	// final attribute values.
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	copy(data[len(data)-16:], mac.Sum(nil))
+	sum := md5.Sum(append(append([]byte(nil), data...), secret...))
+	copy(data[4:20], sum[:])
+	return data, nil
+}
+
+// VerifyMessageAuthenticator checks the Message-Authenticator of a request
+// in its raw form. It returns false when the attribute is absent.
+func VerifyMessageAuthenticator(raw []byte, secret []byte) (bool, error) {
+	p, err := Parse(raw)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := p.Get(AttrMessageAuthenticator); !ok {
+		return false, nil
+	}
+	data := append([]byte(nil), raw[:binary.BigEndian.Uint16(raw[2:4])]...)
+	offset := headerLength
+	var got []byte
+	for offset < len(data) {
+		t, n := AttributeType(data[offset]), int(data[offset+1])
+		if t == AttrMessageAuthenticator {
+			if n != 18 {
+				return false, ErrMessageAuthenticator
+			}
+			got = append([]byte(nil), data[offset+2:offset+n]...)
+			copy(data[offset+2:offset+n], zeroMessageAuthenticator)
+			break
+		}
+		offset += n
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return false, ErrMessageAuthenticator
+	}
+	return true, nil
+}
+
+// DecryptPassword reverses the User-Password hiding of RFC 2865 section 5.2.
+func DecryptPassword(hidden []byte, secret []byte, requestAuth [16]byte) (string, error) {
+	if len(hidden) == 0 || len(hidden)%16 != 0 || len(hidden) > maxPassword {
+		return "", errPasswordLength
+	}
+	plain := make([]byte, len(hidden))
+	prev := requestAuth[:]
+	for i := 0; i < len(hidden); i += 16 {
+		b := md5.Sum(append(append([]byte(nil), secret...), prev...))
+		for j := 0; j < 16; j++ {
+			plain[i+j] = hidden[i+j] ^ b[j]
+		}
+		prev = hidden[i : i+16]
+	}
+	return string(bytes.TrimRight(plain, "\x00")), nil
+}
+
+// EncryptPassword hides password as a client does; it is used by tests and
+// tools that talk to the server.
+func EncryptPassword(password string, secret []byte, requestAuth [16]byte) ([]byte, error) {
+	if len(password) > maxPassword {
+		return nil, errPasswordLength
+	}
+	n := (len(password) + 15) / 16 * 16
+	if n == 0 {
+		n = 16
+	}
+	plain := make([]byte, n)
+	copy(plain, password)
+	hidden := make([]byte, n)
+	prev := requestAuth[:]
+	for i := 0; i < n; i += 16 {
+		b := md5.Sum(append(append([]byte(nil), secret...), prev...))
+		for j := 0; j < 16; j++ {
+			hidden[i+j] = plain[i+j] ^ b[j]
+		}
+		prev = hidden[i : i+16]
+	}
+	return hidden, nil
+}
+
+// VerifyResponse checks the Response Authenticator of a reply to a request
+// sent with requestAuth.
+func VerifyResponse(raw []byte, secret []byte, requestAuth [16]byte) bool {
+	if len(raw) < headerLength {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(raw[2:4]))
+	if length < headerLength || length > len(raw) {
+		return false
+	}
+	data := append([]byte(nil), raw[:length]...)
+	got := append([]byte(nil), data[4:20]...)
+	copy(data[4:20], requestAuth[:])
+	sum := md5.Sum(append(data, secret...))
+	return hmac.Equal(got, sum[:])
+}
@@ -0,0 +1,261 @@
+package radius
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/otp"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+var testSecret = []byte("testing123")
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	id   byte
+}
+
+// newTestServer enrolls alice and returns a client and the code she would
+// enter next; the enrollment confirmation used the previous time step.
+// configure, if not nil, sets options of the server.
+func newTestServer(t *testing.T, configure func(*Server)) (*client, string) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	params, err := pamcfg.ParseParams([]string{"secret=" + filepath.Join(t.TempDir(), "%u")})
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	svc := service.New(params)
+	svc.Now = func() time.Time { return now }
+	svc.Lookup = func(name string) (*user.User, error) {
+		u := *current
+		u.Username = name
+		return &u, nil
+	}
+	ctx := context.Background()
+	enr, err := svc.Enroll(ctx, "alice", "", false)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "alice", codeAt(t, enr.Secret, now)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	now = now.Add(config.DefaultStepSize * time.Second)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	srv := &Server{Service: svc, Secret: testSecret}
+	if configure != nil {
+		configure(srv)
+	}
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, pc)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &client{t: t, conn: conn}, codeAt(t, enr.Secret, now)
+}
+
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := (&config.Config{Secret: secret}).SecretBytes()
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(at.Unix()/config.DefaultStepSize)))
+}
+
+// request builds an Access-Request with a Message-Authenticator.
+func (c *client) request(username, password string, state []byte) ([]byte, [16]byte) {
+	c.t.Helper()
+	c.id++
+	p := &Packet{Code: CodeAccessRequest, Identifier: c.id}
+	if _, err := rand.Read(p.Authenticator[:]); err != nil {
+		c.t.Fatalf("rand: %v", err)
+	}
+	hidden, err := EncryptPassword(password, testSecret, p.Authenticator)
+	if err != nil {
+		c.t.Fatalf("encrypt: %v", err)
+	}
+	p.Add(AttrUserName, []byte(username))
+	p.Add(AttrUserPassword, hidden)
+	if state != nil {
+		p.Add(AttrState, state)
+	}
+	p.Add(AttrMessageAuthenticator, zeroMessageAuthenticator)
+	raw, err := p.Encode()
+	if err != nil {
+		c.t.Fatalf("encode: %v", err)
+	}
+	mac := hmac.New(md5.New, testSecret)
+	mac.Write(raw)
+	copy(raw[len(raw)-16:], mac.Sum(nil))
+	return raw, p.Authenticator
+}
+
+func (c *client) exchange(raw []byte, auth [16]byte) *Packet {
+	c.t.Helper()
+	if _, err := c.conn.Write(raw); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxPacketSize)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	if !VerifyResponse(buf[:n], testSecret, auth) {
+		c.t.Fatal("invalid Response Authenticator")
+	}
+	resp, err := Parse(buf[:n])
+	if err != nil {
+		c.t.Fatalf("parse reply: %v", err)
+	}
+	if resp.Identifier != raw[1] {
+		c.t.Fatalf("reply identifier %d, want %d", resp.Identifier, raw[1])
+	}
+	return resp
+}
+
+func (c *client) send(username, password string, state []byte) *Packet {
+	c.t.Helper()
+	return c.exchange(c.request(username, password, state))
+}
+
+func TestPasswordRoundTrip(t *testing.T) {
+	var auth [16]byte
+	copy(auth[:], "0123456789abcdef")
+	for _, pw := range []string{"a", "exactly16bytes!!", "a much longer password with a code 123456"} {
+		hidden, err := EncryptPassword(pw, testSecret, auth)
+		if err != nil {
+			t.Fatalf("encrypt %q: %v", pw, err)
+		}
+		got, err := DecryptPassword(hidden, testSecret, auth)
+		if err != nil || got != pw {
+			t.Fatalf("decrypt %q = %q, err=%v", pw, got, err)
+		}
+	}
+	if _, err := DecryptPassword(make([]byte, 15), testSecret, auth); err == nil {
+		t.Fatal("expected error for odd password length")
+	}
+}
+
+func TestAccessAcceptAndReject(t *testing.T) {
+	c, code := newTestServer(t, nil)
+
+	if resp := c.send("alice", "000000", nil); resp.Code != CodeAccessReject {
+		t.Fatalf("wrong code: got %d", resp.Code)
+	}
+	if resp := c.send("alice", "hunter2", nil); resp.Code != CodeAccessReject {
+		t.Fatalf("no code: got %d", resp.Code)
+	}
+	if resp := c.send("bob", code, nil); resp.Code != CodeAccessReject {
+		t.Fatalf("unenrolled user: got %d", resp.Code)
+	}
+	if resp := c.send("alice", "anything"+code, nil); resp.Code != CodeAccessReject {
+		t.Fatalf("unchecked password prefix: got %d", resp.Code)
+	}
+
+	raw, auth := c.request("alice", code, nil)
+	if resp := c.exchange(raw, auth); resp.Code != CodeAccessAccept {
+		t.Fatalf("valid code: got %d", resp.Code)
+	}
+	// A retransmission gets the same answer even though the code is spent.
+	if resp := c.exchange(raw, auth); resp.Code != CodeAccessAccept {
+		t.Fatalf("retransmission: got %d", resp.Code)
+	}
+	if resp := c.send("alice", code, nil); resp.Code != CodeAccessReject {
+		t.Fatalf("reused code: got %d", resp.Code)
+	}
+}
+
+func TestIgnorePassword(t *testing.T) {
+	c, code := newTestServer(t, func(s *Server) { s.IgnorePassword = true })
+
+	if resp := c.send("alice", "hunter2000000", nil); resp.Code != CodeAccessReject {
+		t.Fatalf("wrong code: got %d", resp.Code)
+	}
+	if resp := c.send("alice", "hunter2"+code, nil); resp.Code != CodeAccessAccept {
+		t.Fatalf("password and code: got %d", resp.Code)
+	}
+}
+
+func TestAccessChallenge(t *testing.T) {
+	c, code := newTestServer(t, func(s *Server) { s.Challenge = true })
+
+	resp := c.send("alice", "hunter2", nil)
+	if resp.Code != CodeAccessChallenge {
+		t.Fatalf("expected challenge, got %d", resp.Code)
+	}
+	state, ok := resp.Get(AttrState)
+	if !ok {
+		t.Fatal("challenge without State")
+	}
+	if msg, _ := resp.Get(AttrReplyMessage); string(msg) != challengeText {
+		t.Fatalf("Reply-Message = %q", msg)
+	}
+	if resp := c.send("mallory", code, state); resp.Code != CodeAccessReject {
+		t.Fatalf("State of another user: got %d", resp.Code)
+	}
+
+	state, _ = c.send("alice", "hunter2", nil).Get(AttrState)
+	if resp := c.send("alice", code, state); resp.Code != CodeAccessAccept {
+		t.Fatalf("challenge response: got %d", resp.Code)
+	}
+	if resp := c.send("alice", "000000", state); resp.Code != CodeAccessReject {
+		t.Fatalf("replayed State: got %d", resp.Code)
+	}
+}
+
+func TestDropsUnauthenticatedRequests(t *testing.T) {
+	srv := &Server{Secret: testSecret}
+	c := &client{t: t}
+	raw, _ := c.request("alice", "hunter2123456", nil)
+	raw[len(raw)-1] ^= 0xff
+	if reply := srv.Handle(context.Background(), "127.0.0.1:1", raw); reply != nil {
+		t.Fatal("request with bad Message-Authenticator was answered")
+	}
+	if reply := srv.Handle(context.Background(), "127.0.0.1:1", raw[:10]); reply != nil {
+		t.Fatal("truncated request was answered")
+	}
+
+	p := &Packet{Code: CodeAccessRequest, Identifier: 1}
+	hidden, err := EncryptPassword("123456", testSecret, p.Authenticator)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	p.Add(AttrUserName, []byte("alice"))
+	p.Add(AttrUserPassword, hidden)
+	raw, err = p.Encode()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if reply := srv.Handle(context.Background(), "127.0.0.1:1", raw); reply != nil {
+		t.Fatal("request without Message-Authenticator was answered")
+	}
+}
@@ -0,0 +1,249 @@
+package radius
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+const (
+	// ServiceName is passed to the service as PAM service name, e.g. for
+	// grace_per_service.
+	ServiceName = "radius"
+
+	challengeTTL  = 2 * time.Minute
+	duplicateTTL  = 5 * time.Second
+	challengeText = "Verification code: "
+)
+
+// Server answers PAP Access-Requests sent with Secret. Requests must carry
+// a Message-Authenticator (RFC 3579 section 3.2); others are dropped.
+//
+// User-Password must be the verification code alone. With IgnorePassword a
+// password in front of the code is split off like try_first_pass does and
+// not checked, which leaves it to the NAS or a second server.
+//
+// With Challenge, a request without a code is answered with an
+// Access-Challenge and the code is expected in the User-Password of the
+// follow-up request carrying the same State.
+type Server struct {
+	Service        *service.Service
+	Secret         []byte
+	Challenge      bool
+	IgnorePassword bool
+	Now            func() time.Time
+
+	mu         sync.Mutex
+	challenges map[string]pendingChallenge
+	replies    map[string]cachedReply
+}
+
+type pendingChallenge struct {
+	user    string
+	expires time.Time
+}
+
+type cachedReply struct {
+	data    []byte
+	expires time.Time
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// Serve handles requests on conn until ctx is canceled.
+func (s *Server) Serve(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			logging.Errorf("radius: read: %v", err)
+			continue
+		}
+		reply := s.Handle(ctx, addr.String(), append([]byte(nil), buf[:n]...))
+		if reply == nil {
+			continue
+		}
+		if _, err := conn.WriteTo(reply, addr); err != nil {
+			logging.Errorf("radius: reply to %s: %v", addr, err)
+		}
+	}
+}
+
+// Handle processes a single datagram from src and returns the encoded reply,
+// or nil when the packet is silently discarded as RFC 2865 requires for
+// malformed or unauthenticated requests.
+func (s *Server) Handle(ctx context.Context, src string, raw []byte) []byte {
+	req, err := Parse(raw)
+	if err != nil {
+		logging.Infof("radius: dropping packet from %s: %v", src, err)
+		return nil
+	}
+	if req.Code != CodeAccessRequest {
+		logging.Infof("radius: dropping packet code %d from %s", req.Code, src)
+		return nil
+	}
+	// Without a Message-Authenticator an Access-Request can be forged from
+	// a captured one (BlastRADIUS).
+	ok, err := VerifyMessageAuthenticator(raw, s.Secret)
+	if err == nil && !ok {
+		err = ErrNoMessageAuthenticator
+	}
+	if err != nil {
+		logging.Infof("radius: dropping request from %s: %v", src, err)
+		return nil
+	}
+
+	// Retransmissions must get the original answer: verifying the same code
+	// again would fail with DISALLOW_REUSE.
+	key := src + "/" + hex.EncodeToString([]byte{req.Identifier}) + hex.EncodeToString(req.Authenticator[:])
+	if data := s.cachedReply(key); data != nil {
+		return data
+	}
+	resp := s.respond(ctx, src, req)
+	data, err := resp.Reply(s.Secret)
+	if err != nil {
+		logging.Errorf("radius: encode reply to %s: %v", src, err)
+		return nil
+	}
+	s.storeReply(key, data)
+	return data
+}
+
+func (s *Server) respond(ctx context.Context, src string, req *Packet) *Packet {
+	name, _ := req.Get(AttrUserName)
+	username := string(name)
+	hidden, ok := req.Get(AttrUserPassword)
+	if username == "" || !ok {
+		logging.Infof("radius: rejecting request from %s without User-Name or User-Password", src)
+		return req.Response(CodeAccessReject)
+	}
+	password, err := DecryptPassword(hidden, s.Secret, req.Authenticator)
+	if err != nil {
+		logging.Infof("radius: rejecting %s from %s: %v", username, src, err)
+		return req.Response(CodeAccessReject)
+	}
+	logging.Register(password)
+
+	code := ""
+	if state, ok := req.Get(AttrState); ok {
+		if !s.takeChallenge(string(state), username) {
+			logging.Infof("radius: rejecting %s from %s: unknown or expired State", username, src)
+			return req.Response(CodeAccessReject)
+		}
+		code = password
+	} else if c, rest, ok := pamcfg.ExtractOTP(password); ok {
+		if rest != "" && !s.IgnorePassword {
+			logging.Infof("radius: rejecting %s from %s: unexpected password before verification code", username, src)
+			return req.Response(CodeAccessReject)
+		}
+		code = c
+	} else if s.Challenge {
+		state, err := s.newChallenge(username)
+		if err != nil {
+			logging.Errorf("radius: challenge for %s: %v", username, err)
+			return req.Response(CodeAccessReject)
+		}
+		resp := req.Response(CodeAccessChallenge)
+		resp.Add(AttrState, []byte(state))
+		resp.Add(AttrReplyMessage, []byte(challengeText))
+		return resp
+	} else {
+		logging.Infof("radius: rejecting %s from %s: no verification code in password", username, src)
+		return req.Response(CodeAccessReject)
+	}
+
+	ev := logging.Event{User: username, Rhost: src, Service: ServiceName}
+	res, err := s.Service.Verify(ctx, service.Request{User: username, Code: code, Service: ServiceName})
+	if err != nil {
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthFailure).WithError(service.ErrorClass(err), err),
+			fmt.Sprintf("radius: verify %s from %s failed: %v", username, src, err))
+		return req.Response(CodeAccessReject)
+	}
+	if res.SkewReset {
+		logging.Emit(logging.LevelInfo, ev.With(logging.EventSkewReset), fmt.Sprintf("radius: time skew of %s reset", username))
+	}
+	logging.Emit(logging.LevelInfo, ev.With(logging.EventAuthSuccess).WithResult(string(res.Type)),
+		fmt.Sprintf("radius: verify %s from %s succeeded (%s)", username, src, res.Type))
+	return req.Response(CodeAccessAccept)
+}
+
+func (s *Server) newChallenge(username string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(buf)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.challenges == nil {
+		s.challenges = make(map[string]pendingChallenge)
+	}
+	now := s.now()
+	for k, c := range s.challenges {
+		if now.After(c.expires) {
+			delete(s.challenges, k)
+		}
+	}
+	s.challenges[state] = pendingChallenge{user: username, expires: now.Add(challengeTTL)}
+	return state, nil
+}
+
+// takeChallenge consumes state and reports whether it was issued to username
+// and has not expired.
+func (s *Server) takeChallenge(state, username string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.challenges[state]
+	if !ok {
+		return false
+	}
+	delete(s.challenges, state)
+	return c.user == username && !s.now().After(c.expires)
+}
+
+func (s *Server) cachedReply(key string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.replies[key]
+	if !ok || s.now().After(r.expires) {
+		return nil
+	}
+	return r.data
+}
+
+func (s *Server) storeReply(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replies == nil {
+		s.replies = make(map[string]cachedReply)
+	}
+	now := s.now()
+	for k, r := range s.replies {
+		if now.After(r.expires) {
+			delete(s.replies, k)
+		}
+	}
+	s.replies[key] = cachedReply{data: data, expires: now.Add(duplicateTTL)}
+}
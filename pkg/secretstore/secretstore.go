@@ -0,0 +1,196 @@
+// Package secretstore manages a central directory of per-user secret files,
+// e.g. secret=/var/lib/ggpam/%u, owned by a dedicated system user rather
+// than by the users the secrets belong to.
+package secretstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ggpam/pkg/config"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/util"
+)
+
+const (
+	DefaultDir   = "/var/lib/ggpam"
+	DefaultOwner = "ggpam"
+	FilePerm     = 0o600
+	DirPerm      = 0o700
+)
+
+var ErrExists = errors.New("secret already exists")
+
+// Store is a directory holding one secret file per user name. Files and the
+// directory itself belong to Owner so the PAM module can drop to that
+// account (secret_owner=) to read and update them. Files are read and
+// written with the same checks and atomic replacement as the PAM module.
+type Store struct {
+	Dir   string
+	Owner *user.User
+}
+
+// Open returns the store in dir owned by the named account. An empty owner
+// keeps files owned by the calling process.
+func Open(dir, owner string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("secret store directory is empty")
+	}
+	var account *user.User
+	var err error
+	if owner == "" {
+		account, err = user.LookupId(strconv.Itoa(os.Geteuid()))
+	} else {
+		account, err = user.Lookup(owner)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup owner %s: %w", owner, err)
+	}
+	return &Store{Dir: dir, Owner: account}, nil
+}
+
+// Path returns the secret file of username.
+func (s *Store) Path(username string) (string, error) {
+	if !util.SafeFilename(username) || strings.HasPrefix(username, ".") {
+		return "", fmt.Errorf("invalid user name %q", username)
+	}
+	return filepath.Join(s.Dir, username), nil
+}
+
+// List returns the user names that have a secret, sorted.
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read secret store %s: %w", s.Dir, err)
+	}
+	var users []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		users = append(users, entry.Name())
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Load reads the secret of username.
+func (s *Store) Load(username string) (*config.Config, error) {
+	cfg, _, err := s.load(username)
+	return cfg, err
+}
+
+// Create writes a new secret for username. Unless overwrite is set an
+// existing secret is left alone and ErrExists is returned.
+func (s *Store) Create(username string, cfg *config.Config, overwrite bool) error {
+	path, err := s.Path(username)
+	if err != nil {
+		return err
+	}
+	if err := s.ensureDir(); err != nil {
+		return err
+	}
+	if !overwrite {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%s: %w", path, ErrExists)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("stat secret file %s: %w", path, err)
+		}
+	}
+	// Without a previous state WriteConfig creates the file exclusively, or
+	// replaces one that already exists.
+	err = s.write(path, cfg, pamcfg.FileState{})
+	if !overwrite && errors.Is(err, pamcfg.ErrSecretModified) {
+		return fmt.Errorf("%s: %w", path, ErrExists)
+	}
+	return err
+}
+
+// Update applies fn to the secret of username and writes the result back
+// when fn marks the config dirty. It fails with pamcfg.ErrSecretModified if
+// the file changed in between.
+func (s *Store) Update(username string, fn func(*config.Config) error) error {
+	cfg, state, err := s.load(username)
+	if err != nil {
+		return err
+	}
+	if err := fn(cfg); err != nil {
+		return err
+	}
+	if !cfg.Dirty {
+		return nil
+	}
+	path, err := s.Path(username)
+	if err != nil {
+		return err
+	}
+	return s.write(path, cfg, state)
+}
+
+// Remove deletes the secret of username.
+func (s *Store) Remove(username string) error {
+	path, err := s.Path(username)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("remove secret %s: %w", path, err)
+	}
+	return nil
+}
+
+// ensureDir creates the store directory and makes sure, also for one that
+// already exists, that it has DirPerm and belongs to the owner.
+func (s *Store) ensureDir() error {
+	if err := os.MkdirAll(s.Dir, DirPerm); err != nil {
+		return fmt.Errorf("create secret store %s: %w", s.Dir, err)
+	}
+	info, err := os.Lstat(s.Dir)
+	if err != nil {
+		return fmt.Errorf("stat secret store %s: %w", s.Dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("secret store %s is not a directory", s.Dir)
+	}
+	if err := os.Chmod(s.Dir, DirPerm); err != nil {
+		return fmt.Errorf("chmod secret store %s: %w", s.Dir, err)
+	}
+	uid, err := strconv.Atoi(s.Owner.Uid)
+	if err != nil {
+		return fmt.Errorf("owner %s: invalid uid %q", s.Owner.Username, s.Owner.Uid)
+	}
+	gid, err := strconv.Atoi(s.Owner.Gid)
+	if err != nil {
+		return fmt.Errorf("owner %s: invalid gid %q", s.Owner.Username, s.Owner.Gid)
+	}
+	if err := os.Chown(s.Dir, uid, gid); err != nil {
+		return fmt.Errorf("chown secret store %s: %w", s.Dir, err)
+	}
+	return nil
+}
+
+func (s *Store) load(username string) (*config.Config, pamcfg.FileState, error) {
+	path, err := s.Path(username)
+	if err != nil {
+		return nil, pamcfg.FileState{}, err
+	}
+	return pamcfg.LoadConfig(s.Owner, path, pamcfg.Params{AllowedPerm: FilePerm})
+}
+
+func (s *Store) write(path string, cfg *config.Config, state pamcfg.FileState) error {
+	data, err := cfg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := pamcfg.WriteConfig(s.Owner, path, data, FilePerm, state); err != nil {
+		return err
+	}
+	cfg.Dirty = false
+	return nil
+}
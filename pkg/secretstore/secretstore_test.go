@@ -0,0 +1,121 @@
+package secretstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Secret:       "JBSWY3DPEHPK3PXP",
+		ScratchCodes: []int{12345678},
+		Options: config.Options{
+			TOTPAuth:             true,
+			StepSize:             config.DefaultStepSize,
+			WindowSize:           config.DefaultWindow,
+			DisallowReuse:        true,
+			DisallowedTimestamps: []int64{1},
+			RateLimit:            &config.RateLimit{Attempts: 3, Interval: 30 * time.Second, Timestamps: []int64{10, 20}},
+			Additional:           map[string]string{},
+			LastLogins:           map[int]config.LoginRecord{0: {Host: "10.0.0.1", When: 100}},
+		},
+	}
+}
+
+func TestStoreLifecycle(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "ggpam"), "")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := store.Create("alice", testConfig(), false); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := store.Create("alice", testConfig(), false); !errors.Is(err, ErrExists) {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+	if err := store.Create("bob", testConfig(), true); err != nil {
+		t.Fatalf("create bob: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(store.Dir, "enroll"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	users, err := store.List()
+	if err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Fatalf("list = %v, err=%v", users, err)
+	}
+
+	path, _ := store.Path("alice")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if info.Mode().Perm() != FilePerm || strconv.Itoa(int(stat.Uid)) != store.Owner.Uid {
+		t.Fatalf("unexpected mode %04o owner %d", info.Mode().Perm(), stat.Uid)
+	}
+
+	if err := store.Update("alice", func(cfg *config.Config) error {
+		cfg.ResetState()
+		return nil
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	cfg, err := store.Load("alice")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.Options.RateLimit.Timestamps) != 0 || len(cfg.Options.DisallowedTimestamps) != 0 || len(cfg.Options.LastLogins) != 0 {
+		t.Fatalf("state not reset: %+v", cfg.Options)
+	}
+	if cfg.Secret != "JBSWY3DPEHPK3PXP" || !cfg.Options.DisallowReuse {
+		t.Fatalf("settings lost: %+v", cfg)
+	}
+
+	if err := store.Remove("alice"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := store.Load("alice"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+}
+
+func TestStoreRejectsPathNames(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	for _, name := range []string{"", ".", "..", "../etc/passwd", "a/b", ".ga-tmp"} {
+		if _, err := store.Path(name); err == nil {
+			t.Fatalf("expected error for %q", name)
+		}
+	}
+}
+
+func TestCreateFixesExistingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ggpam")
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	store, err := Open(dir, "")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := store.Create("alice", testConfig(), false); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != DirPerm {
+		t.Fatalf("store directory mode %04o, want %04o", info.Mode().Perm(), DirPerm)
+	}
+}
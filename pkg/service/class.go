@@ -0,0 +1,33 @@
+package service
+
+import (
+	"errors"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/logging"
+	pamcfg "ggpam/pkg/pam"
+)
+
+// ErrorClass maps a verification or enrollment error to the stable
+// error_class field of structured log events.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, authenticator.ErrInvalidCode):
+		return logging.ClassInvalidCode
+	case errors.Is(err, authenticator.ErrCodeReused):
+		return logging.ClassCodeReused
+	case errors.Is(err, config.ErrRateLimited):
+		return logging.ClassRateLimited
+	case errors.Is(err, ErrNotEnrolled), errors.Is(err, authenticator.ErrNoSecret):
+		return logging.ClassNotEnrolled
+	case errors.Is(err, ErrUnknownUser):
+		return logging.ClassUnknownUser
+	case errors.Is(err, pamcfg.ErrSecretModified), errors.Is(err, authenticator.ErrModeUnknown):
+		return logging.ClassConfig
+	default:
+		return logging.ClassInternal
+	}
+}
@@ -0,0 +1,361 @@
+// Package service implements verification, enrollment and status queries on
+// top of the secret files for long-running front ends such as ggpamd. All
+// updates for a user are serialized in-process and parsed configs are cached
+// until the file on disk changes.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/util"
+)
+
+var (
+	ErrUnknownUser         = errors.New("unknown user")
+	ErrNotEnrolled         = errors.New("user has no secret")
+	ErrAlreadyEnrolled     = errors.New("user already has a secret")
+	ErrNoPendingEnrollment = errors.New("no enrollment in progress")
+)
+
+// Request describes a login attempt.
+type Request struct {
+	User    string
+	Code    string
+	Rhost   string
+	Service string
+}
+
+// Status summarizes the secret of a user without exposing it.
+type Status struct {
+	User         string               `json:"user"`
+	Enrolled     bool                 `json:"enrolled"`
+	Mode         string               `json:"mode,omitempty"`
+	ScratchCodes int                  `json:"scratch_codes,omitempty"`
+	RateLimited  bool                 `json:"rate_limited,omitempty"`
+	LastLogins   []config.LoginRecord `json:"last_logins,omitempty"`
+}
+
+// Enrollment carries a freshly generated secret that still has to be
+// confirmed with a code from the authenticator app.
+type Enrollment struct {
+	User         string `json:"user"`
+	Secret       string `json:"secret"`
+	URL          string `json:"url"`
+	ScratchCodes []int  `json:"scratch_codes"`
+}
+
+// Service works on the secret files described by Params, i.e. the same
+// secret=, secret_owner=, allowed_perm=, grace_* and state_store settings
+// the PAM module accepts.
+type Service struct {
+	Params pamcfg.Params
+	Store  authenticator.StateStore
+	Now    func() time.Time
+	Lookup func(name string) (*user.User, error)
+
+	mu    sync.Mutex
+	users map[string]*entry
+}
+
+type entry struct {
+	mu      sync.Mutex
+	path    string
+	cfg     *config.Config
+	state   pamcfg.FileState
+	pending *config.Config
+	replace bool
+}
+
+// New returns a service for params.
+func New(params pamcfg.Params) *Service {
+	return &Service{Params: params}
+}
+
+func (s *Service) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Service) lookup(name string) (*user.User, error) {
+	if !util.SafeFilename(name) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownUser, name)
+	}
+	lookup := s.Lookup
+	if lookup == nil {
+		lookup = user.Lookup
+	}
+	account, err := lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrUnknownUser, name, err)
+	}
+	return account, nil
+}
+
+// acquire returns the locked cache entry of username together with the
+// accounts needed to locate and access the secret. Callers must unlock.
+func (s *Service) acquire(username string) (*entry, *user.User, error) {
+	account, err := s.lookup(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	owner, err := pamcfg.SecretOwnerAccount(s.Params, account)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := pamcfg.ResolveSecretPath(s.Params.SecretSpec, account)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mu.Lock()
+	if s.users == nil {
+		s.users = map[string]*entry{}
+	}
+	e := s.users[username]
+	if e == nil {
+		e = &entry{}
+		s.users[username] = e
+	}
+	s.mu.Unlock()
+	e.mu.Lock()
+	if e.path != path {
+		e.path, e.cfg, e.pending = path, nil, nil
+	}
+	return e, owner, nil
+}
+
+// load returns the cached config unless the file changed since it was read.
+func (e *entry) load(owner *user.User, params pamcfg.Params) (*config.Config, error) {
+	if e.cfg != nil {
+		if state, err := pamcfg.CurrentFileState(e.path); err == nil && state == e.state {
+			return e.cfg, nil
+		}
+		e.cfg = nil
+	}
+	cfg, state, err := pamcfg.LoadConfig(owner, e.path, params)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %v", ErrNotEnrolled, err)
+		}
+		return nil, err
+	}
+	e.cfg, e.state = cfg, state
+	return cfg, nil
+}
+
+// persist writes a dirty config back and refreshes the cached state. Any
+// failure drops the cache so the next request re-reads the file.
+func (e *entry) persist(cfg *config.Config, owner *user.User, params pamcfg.Params, expected pamcfg.FileState) error {
+	if !cfg.Dirty {
+		return nil
+	}
+	data, err := cfg.Bytes()
+	if err == nil {
+		err = pamcfg.WriteConfig(owner, e.path, data, params.AllowedPerm, expected)
+	}
+	if err == nil {
+		e.state, err = pamcfg.CurrentFileState(e.path)
+	}
+	if err != nil {
+		e.cfg = nil
+		return err
+	}
+	cfg.Dirty = false
+	e.cfg = cfg
+	return nil
+}
+
+// Grace reports whether req.Rhost logged in within grace_period and, if so,
+// refreshes its login record.
+func (s *Service) Grace(ctx context.Context, req Request) (bool, error) {
+	defer flushMetrics()
+	if s.Params.GracePeriod <= 0 || req.Rhost == "" {
+		return false, nil
+	}
+	e, owner, err := s.acquire(req.User)
+	if err != nil {
+		return false, err
+	}
+	defer e.mu.Unlock()
+	cfg, err := e.load(owner, s.Params)
+	if err != nil {
+		return false, err
+	}
+	scope := s.Params.GraceScope(req.Service)
+	now := s.now()
+	if !cfg.WithinGrace(req.Rhost, scope, s.Params.GracePeriod, now) {
+		return false, nil
+	}
+	cfg.RecordLogin(req.Rhost, scope, now)
+	metrics.GraceBypass()
+	return true, e.persist(cfg, owner, s.Params, e.state)
+}
+
+// Verify checks req.Code and stores the resulting state, including failed
+// attempts counted by RATE_LIMIT.
+func (s *Service) Verify(ctx context.Context, req Request) (res authenticator.Result, err error) {
+	logging.Register(req.Code)
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			metrics.Verified(res, time.Since(start))
+		} else {
+			metrics.Failed(ErrorClass(err), time.Since(start))
+		}
+		flushMetrics()
+	}()
+	e, owner, err := s.acquire(req.User)
+	if err != nil {
+		return authenticator.Result{}, err
+	}
+	defer e.mu.Unlock()
+	cfg, err := e.load(owner, s.Params)
+	if err != nil {
+		return authenticator.Result{}, err
+	}
+	auth := &authenticator.Authenticator{Now: s.Now, Store: s.Store}
+	opts := authenticator.VerifyOptions{
+		DisableSkewAdjustment: s.Params.NoSkewAdjust,
+		NoIncrementHOTP:       s.Params.NoIncrementHOTP,
+	}
+	if s.Store != nil {
+		opts.StateKey = req.User
+	}
+	res, verr := auth.VerifyCodeContext(ctx, cfg, req.Code, opts)
+	if verr == nil && s.Params.GracePeriod > 0 && req.Rhost != "" {
+		cfg.RecordLogin(req.Rhost, s.Params.GraceScope(req.Service), s.now())
+	}
+	if err := e.persist(cfg, owner, s.Params, e.state); err != nil {
+		return authenticator.Result{}, errors.Join(verr, err)
+	}
+	return res, verr
+}
+
+// Status reports whether username is enrolled and a summary of its state.
+func (s *Service) Status(ctx context.Context, username string) (Status, error) {
+	e, owner, err := s.acquire(username)
+	if err != nil {
+		return Status{}, err
+	}
+	defer e.mu.Unlock()
+	st := Status{User: username}
+	cfg, err := e.load(owner, s.Params)
+	if errors.Is(err, ErrNotEnrolled) {
+		return st, nil
+	}
+	if err != nil {
+		return Status{}, err
+	}
+	st.Enrolled = true
+	st.Mode = "totp"
+	if cfg.Mode() == config.ModeHOTP {
+		st.Mode = "hotp"
+	}
+	st.ScratchCodes = len(cfg.ScratchCodes)
+	if cfg.Options.RateLimit != nil {
+		// Probe a copy so that pruning old attempts does not dirty the cache.
+		probe, rl := *cfg, *cfg.Options.RateLimit
+		probe.Options.RateLimit = &rl
+		st.RateLimited = probe.CheckRateLimit(s.now()) != nil
+	}
+	for _, rec := range cfg.Options.LastLogins {
+		st.LastLogins = append(st.LastLogins, rec)
+	}
+	sort.Slice(st.LastLogins, func(i, j int) bool { return st.LastLogins[i].When > st.LastLogins[j].When })
+	return st, nil
+}
+
+// Enroll generates a secret for username and keeps it pending until
+// ConfirmEnroll receives a valid code. With force an existing secret is
+// replaced on confirmation.
+func (s *Service) Enroll(ctx context.Context, username, issuer string, force bool) (Enrollment, error) {
+	e, owner, err := s.acquire(username)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	defer e.mu.Unlock()
+	if !force {
+		_, err := e.load(owner, s.Params)
+		if err == nil {
+			return Enrollment{}, ErrAlreadyEnrolled
+		}
+		if !errors.Is(err, ErrNotEnrolled) {
+			return Enrollment{}, err
+		}
+	}
+	cfg, err := enroll.NewConfig(enroll.DefaultOptions())
+	if err != nil {
+		return Enrollment{}, err
+	}
+	if issuer == "" {
+		issuer = s.Params.EnrollIssuer
+	}
+	e.pending, e.replace = cfg, force
+	return Enrollment{
+		User:         username,
+		Secret:       cfg.Secret,
+		URL:          enroll.OTPAuthURL(cfg, enroll.DefaultLabel(username), issuer),
+		ScratchCodes: append([]int(nil), cfg.ScratchCodes...),
+	}, nil
+}
+
+// ConfirmEnroll verifies code against the pending secret and writes it.
+func (s *Service) ConfirmEnroll(ctx context.Context, username, code string) error {
+	logging.Register(code)
+	e, owner, err := s.acquire(username)
+	if err != nil {
+		return err
+	}
+	defer e.mu.Unlock()
+	cfg := e.pending
+	if cfg == nil {
+		return ErrNoPendingEnrollment
+	}
+	auth := &authenticator.Authenticator{Now: s.Now}
+	if _, err := auth.VerifyCode(cfg, code, authenticator.VerifyOptions{DisableSkewAdjustment: true}); err != nil {
+		return err
+	}
+	if cfg.Options.RateLimit != nil {
+		cfg.Options.RateLimit.Timestamps = nil
+	}
+	cfg.Dirty = true
+	var expected pamcfg.FileState
+	state, err := pamcfg.CurrentFileState(e.path)
+	switch {
+	case err == nil && !e.replace:
+		e.pending = nil
+		return ErrAlreadyEnrolled
+	case err == nil:
+		expected = state
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	if err := e.persist(cfg, owner, s.Params, expected); err != nil {
+		return err
+	}
+	e.pending = nil
+	return nil
+}
+
+// flushMetrics writes the counters of the last request to metrics_file=,
+// if configured; the front ends are long-running, so there is no later
+// point to batch them at.
+func flushMetrics() {
+	if err := metrics.Flush(); err != nil {
+		logging.Errorf("metrics: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ggpam/pkg/authenticator"
+	"ggpam/pkg/config"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	"ggpam/pkg/otp"
+	pamcfg "ggpam/pkg/pam"
+)
+
+func newTestService(t *testing.T, args ...string) (*Service, *time.Time) {
+	t.Helper()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	dir := t.TempDir()
+	params, err := pamcfg.ParseParams(append([]string{"secret=" + filepath.Join(dir, "%u")}, args...))
+	if err != nil {
+		t.Fatalf("parse params: %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	svc := New(params)
+	svc.Now = func() time.Time { return now }
+	svc.Lookup = func(name string) (*user.User, error) {
+		if name == "ghost" {
+			return nil, user.UnknownUserError(name)
+		}
+		u := *current
+		u.Username = name
+		return &u, nil
+	}
+	return svc, &now
+}
+
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	cfg := &config.Config{Secret: secret}
+	key, err := cfg.SecretBytes()
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(at.Unix()/config.DefaultStepSize)))
+}
+
+func TestEnrollVerifyAndGrace(t *testing.T) {
+	svc, now := newTestService(t, "grace_period=300")
+	ctx := context.Background()
+
+	st, err := svc.Status(ctx, "alice")
+	if err != nil || st.Enrolled {
+		t.Fatalf("fresh user status = %+v, err=%v", st, err)
+	}
+	if _, err := svc.Verify(ctx, Request{User: "alice", Code: "123456"}); !errors.Is(err, ErrNotEnrolled) {
+		t.Fatalf("expected ErrNotEnrolled, got %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "alice", "123456"); !errors.Is(err, ErrNoPendingEnrollment) {
+		t.Fatalf("expected ErrNoPendingEnrollment, got %v", err)
+	}
+	enr, err := svc.Enroll(ctx, "alice", "", false)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "alice", "000000"); !errors.Is(err, authenticator.ErrInvalidCode) {
+		t.Fatalf("expected invalid code, got %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "alice", codeAt(t, enr.Secret, *now)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	if _, err := svc.Enroll(ctx, "alice", "", false); !errors.Is(err, ErrAlreadyEnrolled) {
+		t.Fatalf("expected ErrAlreadyEnrolled, got %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	req := Request{User: "alice", Code: codeAt(t, enr.Secret, *now), Rhost: "192.0.2.7", Service: "sshd"}
+	if res, err := svc.Verify(ctx, req); err != nil || res.Type != authenticator.ResultTOTP {
+		t.Fatalf("verify: res=%+v err=%v", res, err)
+	}
+	if _, err := svc.Verify(ctx, req); err == nil {
+		t.Fatal("reused code must be rejected")
+	}
+	if ok, err := svc.Grace(ctx, Request{User: "alice", Rhost: "192.0.2.7"}); err != nil || !ok {
+		t.Fatalf("grace: ok=%v err=%v", ok, err)
+	}
+	if ok, err := svc.Grace(ctx, Request{User: "alice", Rhost: "198.51.100.1"}); err != nil || ok {
+		t.Fatalf("grace for other host: ok=%v err=%v", ok, err)
+	}
+
+	st, err = svc.Status(ctx, "alice")
+	if err != nil || !st.Enrolled || st.Mode != "totp" || st.ScratchCodes != 5 || len(st.LastLogins) != 1 {
+		t.Fatalf("status = %+v, err=%v", st, err)
+	}
+	if _, err := svc.Status(ctx, "ghost"); !errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected ErrUnknownUser, got %v", err)
+	}
+}
+
+func TestCacheFollowsFileChanges(t *testing.T) {
+	svc, now := newTestService(t)
+	ctx := context.Background()
+	enr, err := svc.Enroll(ctx, "bob", "", false)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "bob", codeAt(t, enr.Secret, *now)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	// Replace the secret behind the service's back, as "ggpam admin init --force" would.
+	path := filepath.Join(filepath.Dir(svc.Params.SecretSpec), "bob")
+	replacement := &config.Config{
+		Secret: "JBSWY3DPEHPK3PXP",
+		Options: config.Options{
+			TOTPAuth:   true,
+			StepSize:   config.DefaultStepSize,
+			WindowSize: config.DefaultWindow,
+		},
+	}
+	if err := replacement.Save(path, 0o600); err != nil {
+		t.Fatalf("replace secret: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := svc.Verify(ctx, Request{User: "bob", Code: codeAt(t, "JBSWY3DPEHPK3PXP", *now)}); err != nil {
+		t.Fatalf("verify against replaced secret: %v", err)
+	}
+}
+
+func TestVerifyRecordsMetrics(t *testing.T) {
+	prom := filepath.Join(t.TempDir(), "ggpam.prom")
+	metrics.Configure(prom)
+	t.Cleanup(func() { metrics.Configure("") })
+	svc, now := newTestService(t, "grace_period=300")
+	ctx := context.Background()
+
+	svc.Verify(ctx, Request{User: "bob", Code: "123456"})
+	enr, err := svc.Enroll(ctx, "bob", "", false)
+	if err != nil {
+		t.Fatalf("enroll: %v", err)
+	}
+	if err := svc.ConfirmEnroll(ctx, "bob", codeAt(t, enr.Secret, *now)); err != nil {
+		t.Fatalf("confirm: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := svc.Verify(ctx, Request{User: "bob", Code: codeAt(t, enr.Secret, *now), Rhost: "192.0.2.1"}); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if ok, err := svc.Grace(ctx, Request{User: "bob", Rhost: "192.0.2.1"}); !ok || err != nil {
+		t.Fatalf("grace = %v, %v", ok, err)
+	}
+
+	s, err := metrics.ReadTextfile(prom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Failures[logging.ClassNotEnrolled] != 1 || s.Verifications["totp"] != 1 || s.GraceBypasses != 1 || s.Latency.Count != 2 {
+		t.Fatalf("unexpected totals %+v", s)
+	}
+}
@@ -31,3 +31,9 @@ func ExpandPath(p string) (string, error) {
 	}
 	return p, nil
 }
+
+// SafeFilename reports whether name can be used as a single path element,
+// e.g. a user name substituted into a per-user file path.
+func SafeFilename(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
@@ -10,10 +10,11 @@ STAGE="${DIST_DIR}/deb/ggpam_${VERSION}_${ARCH}"
 "${ROOT_DIR}/scripts/build.sh"
 
 CLI_BIN="${ROOT_DIR}/bin/ggpam"
+DAEMON_BIN="${ROOT_DIR}/bin/ggpamd"
 PAM_SO="${ROOT_DIR}/bin/pam_ggpam.so"
 PAM_HEADER="${ROOT_DIR}/bin/pam_ggpam.h"
 
-for file in "$CLI_BIN" "$PAM_SO" "$PAM_HEADER"; do
+for file in "$CLI_BIN" "$DAEMON_BIN" "$PAM_SO" "$PAM_HEADER"; do
 	if [[ ! -e "$file" ]]; then
 		echo "缺少构建产物: $file" >&2
 		exit 1
@@ -23,6 +24,7 @@ done
 rm -rf "$STAGE"
 mkdir -p "$STAGE/DEBIAN"
 mkdir -p "$STAGE/usr/bin"
+mkdir -p "$STAGE/usr/sbin"
 mkdir -p "$STAGE/lib/security"
 mkdir -p "$STAGE/usr/include/ggpam"
 
@@ -61,8 +63,10 @@ EOF_POSTRM
 chmod 0755 "$STAGE/DEBIAN/postinst" "$STAGE/DEBIAN/postrm"
 
 install -m 0755 "$CLI_BIN" "$STAGE/usr/bin/ggpam"
+install -m 0755 "$DAEMON_BIN" "$STAGE/usr/sbin/ggpamd"
 install -m 0644 "$PAM_SO" "$STAGE/lib/security/pam_ggpam.so"
 install -m 0644 "$PAM_HEADER" "$STAGE/usr/include/ggpam/pam_ggpam.h"
+install -d -m 0755 "$STAGE/usr/share/ggpam/locale"
 
 mkdir -p "$DIST_DIR"
 PACKAGE="${DIST_DIR}/ggpam_${VERSION}_${ARCH}.deb"
@@ -11,10 +11,11 @@ ARCH="${ARCH:-$(uname -m)}"
 "${ROOT_DIR}/scripts/build.sh"
 
 CLI_BIN="${ROOT_DIR}/bin/ggpam"
+DAEMON_BIN="${ROOT_DIR}/bin/ggpamd"
 PAM_SO="${ROOT_DIR}/bin/pam_ggpam.so"
 PAM_HEADER="${ROOT_DIR}/bin/pam_ggpam.h"
 
-for file in "$CLI_BIN" "$PAM_SO" "$PAM_HEADER"; do
+for file in "$CLI_BIN" "$DAEMON_BIN" "$PAM_SO" "$PAM_HEADER"; do
 	if [[ ! -e "$file" ]]; then
 		echo "缺少构建产物: $file" >&2
 		exit 1
@@ -34,6 +35,7 @@ SRC_DIR="${BUILD_ROOT}/src/ggpam-${VERSION}"
 rm -rf "$SRC_DIR"
 mkdir -p "$SRC_DIR"
 cp "$CLI_BIN" "$SRC_DIR/ggpam"
+cp "$DAEMON_BIN" "$SRC_DIR/ggpamd"
 cp "$PAM_SO" "$SRC_DIR/pam_ggpam.so"
 cp "$PAM_HEADER" "$SRC_DIR/pam_ggpam.h"
 tar -C "$(dirname "$SRC_DIR")" -czf "${RPMROOT}/SOURCES/ggpam-${VERSION}.tar.gz" "ggpam-${VERSION}"
@@ -66,8 +68,10 @@ Go 语言重写的 google-authenticator-libpam，提供 CLI 与 PAM 模块。
 
 %install
 install -D -m 0755 ggpam %{buildroot}/usr/bin/ggpam
+install -D -m 0755 ggpamd %{buildroot}/usr/sbin/ggpamd
 install -D -m 0644 pam_ggpam.so %{buildroot}/lib/security/pam_ggpam.so
 install -D -m 0644 pam_ggpam.h %{buildroot}/usr/include/ggpam/pam_ggpam.h
+install -d -m 0755 %{buildroot}/usr/share/ggpam/locale
 
 %post
 /sbin/ldconfig
@@ -77,8 +81,10 @@ install -D -m 0644 pam_ggpam.h %{buildroot}/usr/include/ggpam/pam_ggpam.h
 
 %files
 /usr/bin/ggpam
+/usr/sbin/ggpamd
 /lib/security/pam_ggpam.so
 /usr/include/ggpam/pam_ggpam.h
+%dir /usr/share/ggpam/locale
 
 %changelog
 * $(date +"%a %b %d %Y") sofiworker <sofiworker@outlook.com> - ${VERSION}-${RELEASE}
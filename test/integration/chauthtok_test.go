@@ -0,0 +1,48 @@
+//go:build integration
+
+package integration
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"ggpam/pkg/config"
+)
+
+func TestChauthtok(t *testing.T) {
+	e := newEnv(t)
+	e.stack("ggpam", "password required $MODULE")
+	old := e.enroll(e.account.Username)
+
+	// The first prompt asks for the current code, the second for a code
+	// from the secret shown in between.
+	rotate := func(prompt string, tr *transcript) (string, bool) {
+		if len(tr.Prompts) == 1 {
+			return code(t, old, 0), true
+		}
+		for _, info := range tr.Infos {
+			if i := strings.Index(info, "otpauth://"); i >= 0 {
+				u, err := url.Parse(strings.Fields(info[i:])[0])
+				if err != nil {
+					t.Errorf("parse %q: %v", info, err)
+					return "", false
+				}
+				return code(t, &config.Config{Secret: u.Query().Get("secret")}, 0), true
+			}
+		}
+		return "", false
+	}
+	tr := e.run(request{answer: rotate, ops: []string{"chauthtok"}})
+	tr.expect(t, "chauthtok", pamSuccess)
+	if len(tr.Prompts) != 2 || tr.Prompts[0] != "Current verification code: " {
+		t.Fatalf("prompts %q", tr.Prompts)
+	}
+	if strings.Contains(e.secret(e.account.Username), old.Secret) || !tr.logged("Secret for user "+e.account.Username+" replaced") {
+		t.Fatalf("secret not replaced\n%s", tr)
+	}
+
+	// Codes from the replaced secret are rejected afterwards.
+	tr = e.run(request{answer: answers(code(t, old, 1)), ops: []string{"chauthtok"}})
+	tr.expect(t, "chauthtok", pamAuthErr)
+}
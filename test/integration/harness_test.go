@@ -0,0 +1,382 @@
+//go:build integration
+
+// Package integration loads the real pam_ggpam.so through Linux-PAM. The
+// module is a Go c-shared library and cannot be loaded into the Go test
+// binary itself, so TestMain builds it together with testdata/pamdriver.c,
+// a small libpam application that reports the conversation, pam_syslog
+// output and return codes on stdout while the tests answer its prompts.
+//
+// Run with "make integration-test" or
+// "go test -tags integration ./test/integration/". Linux-PAM 1.4 or newer
+// (for pam_start_confdir) and a C compiler are required.
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/otp"
+)
+
+// Linux-PAM return codes as printed by the driver.
+const (
+	pamSuccess    = 0
+	pamServiceErr = 3
+	pamPermDenied = 6
+	pamAuthErr    = 7
+	pamMaxTries   = 11
+	pamConvErr    = 19
+	pamAuthtokErr = 20
+)
+
+const runTimeout = 30 * time.Second
+
+var (
+	modulePath string
+	driverPath string
+)
+
+func TestMain(m *testing.M) {
+	// Expected messages are compared in English.
+	os.Setenv("LC_ALL", "C")
+	dir, err := os.MkdirTemp("", "ggpam-integration-build-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code := 1
+	if err := build(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		code = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func build(dir string) error {
+	modulePath = filepath.Join(dir, "pam_ggpam.so")
+	driverPath = filepath.Join(dir, "pamdriver")
+	goBuild := exec.Command("go", "build", "-buildmode=c-shared", "-o", modulePath, "ggpam/cmd/pam")
+	if out, err := goBuild.CombinedOutput(); err != nil {
+		return fmt.Errorf("build pam_ggpam.so: %v\n%s", err, out)
+	}
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	args := strings.Fields(os.Getenv("CGO_CFLAGS"))
+	args = append(args, "-o", driverPath, filepath.Join("testdata", "pamdriver.c"))
+	args = append(args, strings.Fields(os.Getenv("CGO_LDFLAGS"))...)
+	args = append(args, "-lpam")
+	if out, err := exec.Command(cc, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("build pamdriver: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// env is a private pam.d directory and secret directory. Both are world
+// readable so that the module can read them after dropping privileges.
+type env struct {
+	t       *testing.T
+	dir     string
+	confdir string
+	secrets string
+	account *user.User
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "ggpam-integration-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	account, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &env{t: t, dir: dir, confdir: filepath.Join(dir, "pam.d"), secrets: filepath.Join(dir, "secrets"), account: account}
+	for _, d := range []string{dir, e.confdir, e.secrets} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+// path returns name inside the environment directory.
+func (e *env) path(name string) string {
+	return filepath.Join(e.dir, name)
+}
+
+// stack writes the pam.d file for service. "$MODULE" expands to the module
+// path followed by secret=<secrets>/%u.
+func (e *env) stack(service string, lines ...string) {
+	e.t.Helper()
+	module := modulePath + " secret=" + filepath.Join(e.secrets, "%u")
+	content := strings.ReplaceAll(strings.Join(lines, "\n")+"\n", "$MODULE", module)
+	if err := os.WriteFile(filepath.Join(e.confdir, service), []byte(content), 0o644); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// configure installs the module for auth and account in the services
+// "ggpam" and "sshd". A trailing optional pam_permit turns PAM_IGNORE into
+// success while failures of the required module still decide the result.
+func (e *env) configure(params ...string) {
+	e.t.Helper()
+	args := strings.Join(params, " ")
+	for _, service := range []string{"ggpam", "sshd"} {
+		e.stack(service,
+			"auth required $MODULE "+args,
+			"auth optional pam_permit.so",
+			"account required $MODULE "+args,
+			"account optional pam_permit.so",
+		)
+	}
+}
+
+// enroll writes a TOTP secret for username without rate limiting, so that
+// tests can log in repeatedly.
+func (e *env) enroll(username string, mutate ...func(*enroll.Options)) *config.Config {
+	e.t.Helper()
+	opts := enroll.DefaultOptions()
+	opts.RateLimit = nil
+	for _, fn := range mutate {
+		fn(&opts)
+	}
+	cfg, err := enroll.NewConfig(opts)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.writeSecret(username, cfg, 0o600)
+	return cfg
+}
+
+func (e *env) writeSecret(username string, cfg *config.Config, perm os.FileMode) {
+	e.t.Helper()
+	data, err := cfg.Bytes()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	path := filepath.Join(e.secrets, username)
+	if err := os.WriteFile(path, data, perm); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+func (e *env) secret(username string) string {
+	e.t.Helper()
+	data, err := os.ReadFile(filepath.Join(e.secrets, username))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	return string(data)
+}
+
+func (e *env) chown(path string, account *user.User) {
+	e.t.Helper()
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+	if err := os.Chown(path, uid, gid); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// unprivileged returns an account other than the test user for scenarios
+// that need a real privilege drop; they only run as root.
+func (e *env) unprivileged() *user.User {
+	e.t.Helper()
+	if os.Geteuid() != 0 {
+		e.t.Skip("requires root to drop privileges")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		e.t.Skipf("no nobody account: %v", err)
+	}
+	return nobody
+}
+
+func fileUID(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return strconv.FormatUint(uint64(stat.Uid), 10)
+	}
+	return ""
+}
+
+// code returns the TOTP code steps time steps away from now.
+func code(t *testing.T, cfg *config.Config, steps int) string {
+	t.Helper()
+	key, err := cfg.SecretBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := time.Now().Unix()/int64(cfg.Step()) + int64(steps)
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(counter)))
+}
+
+func hotp(t *testing.T, cfg *config.Config, counter int64) string {
+	t.Helper()
+	key, err := cfg.SecretBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%06d", otp.Compute(key, uint64(counter)))
+}
+
+// transcript is what the driver reported for one PAM transaction.
+type transcript struct {
+	Prompts []string
+	Styles  []int
+	Errors  []string
+	Infos   []string
+	Syslog  []string
+	Env     map[string]string
+	RC      map[string]int
+	raw     bytes.Buffer
+}
+
+func (tr *transcript) logged(substr string) bool {
+	for _, line := range tr.Syslog {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tr *transcript) String() string {
+	return tr.raw.String()
+}
+
+// answerer returns the response to a prompt; false ends the conversation,
+// which the driver reports to libpam as PAM_CONV_ERR.
+type answerer func(prompt string, tr *transcript) (string, bool)
+
+func answers(lines ...string) answerer {
+	return func(string, *transcript) (string, bool) {
+		if len(lines) == 0 {
+			return "", false
+		}
+		next := lines[0]
+		lines = lines[1:]
+		return next, true
+	}
+}
+
+// request describes one driver invocation.
+type request struct {
+	service string
+	user    string
+	rhost   string
+	answer  answerer
+	ops     []string
+}
+
+func (e *env) run(req request) *transcript {
+	e.t.Helper()
+	if req.service == "" {
+		req.service = "ggpam"
+	}
+	if req.user == "" {
+		req.user = e.account.Username
+	}
+	if req.answer == nil {
+		req.answer = answers()
+	}
+	if len(req.ops) == 0 {
+		req.ops = []string{"auth"}
+	}
+	args := []string{e.confdir, req.service, req.user}
+	if req.rhost != "" {
+		args = append(args, "-r", req.rhost)
+	}
+	args = append(args, req.ops...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, driverPath, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C", "GGPAM_LOG_FILE="+e.path("ggpam.log"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		e.t.Fatal(err)
+	}
+
+	tr := &transcript{Env: map[string]string{}, RC: map[string]int{}}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(&tr.raw, line)
+		kind, rest, _ := strings.Cut(line, " ")
+		text := strings.ReplaceAll(rest, `\n`, "\n")
+		switch kind {
+		case "prompt":
+			style, text, _ := strings.Cut(text, " ")
+			n, _ := strconv.Atoi(style)
+			tr.Prompts = append(tr.Prompts, text)
+			tr.Styles = append(tr.Styles, n)
+			if resp, ok := req.answer(text, tr); ok {
+				io.WriteString(stdin, resp+"\n")
+			} else {
+				stdin.Close()
+			}
+		case "error":
+			tr.Errors = append(tr.Errors, text)
+		case "info":
+			tr.Infos = append(tr.Infos, text)
+		case "syslog":
+			_, msg, _ := strings.Cut(text, " ")
+			tr.Syslog = append(tr.Syslog, msg)
+		case "env":
+			name, value, _ := strings.Cut(text, "=")
+			tr.Env[name] = value
+		case "rc":
+			op, value, _ := strings.Cut(text, " ")
+			tr.RC[op], _ = strconv.Atoi(value)
+		}
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		e.t.Fatalf("pamdriver %v: %v\n%s%s", args, err, tr, stderr.String())
+	}
+	return tr
+}
+
+// expect fails the test unless op returned want.
+func (tr *transcript) expect(t *testing.T, op string, want int) {
+	t.Helper()
+	got, ok := tr.RC[op]
+	if !ok || got != want {
+		t.Fatalf("%s returned %d (reported %v), want %d\n%s", op, got, ok, want, tr)
+	}
+}
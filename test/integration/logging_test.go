@@ -0,0 +1,54 @@
+//go:build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestJSONLog checks the events the real module writes to GGPAM_LOG_FILE
+// with GGPAM_LOG_FORMAT=json.
+func TestJSONLog(t *testing.T) {
+	t.Setenv("GGPAM_LOG_FORMAT", "json")
+	e := newEnv(t)
+	e.configure()
+	cfg := e.enroll(e.account.Username)
+
+	e.run(request{service: "sshd", rhost: "192.0.2.7", answer: answers("000000")}).expect(t, "auth", pamAuthErr)
+	e.run(request{service: "sshd", rhost: "192.0.2.7", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+
+	data, err := os.ReadFile(e.path("ggpam.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("not JSON: %v\n%s", err, line)
+		}
+		if name, _ := rec["event"].(string); name != "" {
+			events[name] = rec
+		}
+	}
+	for name, want := range map[string]map[string]any{
+		"auth_failure": {"user": e.account.Username, "rhost": "192.0.2.7", "service": "sshd", "error_class": "invalid_code"},
+		"auth_success": {"user": e.account.Username, "rhost": "192.0.2.7", "service": "sshd", "result": "totp"},
+	} {
+		rec, ok := events[name]
+		if !ok {
+			t.Fatalf("no %s event in\n%s", name, data)
+		}
+		for key, value := range want {
+			if rec[key] != value {
+				t.Errorf("%s: %s = %v, want %v", name, key, rec[key], value)
+			}
+		}
+		if _, ok := rec["pid"]; !ok {
+			t.Errorf("%s: no pid", name)
+		}
+	}
+}
@@ -0,0 +1,749 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ggpam/pkg/config"
+	"ggpam/pkg/daemon"
+	"ggpam/pkg/enroll"
+	"ggpam/pkg/i18n"
+	"ggpam/pkg/logging"
+	"ggpam/pkg/metrics"
+	pamcfg "ggpam/pkg/pam"
+	"ggpam/pkg/service"
+)
+
+// One test per parameter accepted by pamcfg.ParseParams. The bare "daemon"
+// flag differs from daemon= only in using the default socket and is not
+// exercised here.
+
+func TestSecret(t *testing.T) {
+	e := newEnv(t)
+	e.configure()
+	cfg := e.enroll(e.account.Username)
+
+	tr := e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 1 || tr.Prompts[0] != "Verification code: " || tr.Styles[0] != 1 {
+		t.Fatalf("prompts %q styles %v", tr.Prompts, tr.Styles)
+	}
+	if !tr.logged(i18n.Msgf(i18n.MsgUserAuthSuccess, e.account.Username, "totp")) {
+		t.Fatalf("success not logged\n%s", tr)
+	}
+	if !strings.Contains(e.secret(e.account.Username), "DISALLOW_REUSE ") {
+		t.Fatalf("used code not recorded:\n%s", e.secret(e.account.Username))
+	}
+	if tr.Env["GGPAM_METHOD"] != "totp" || tr.Env["GGPAM_GRACE"] != "0" || tr.Env["GGPAM_SCRATCH_REMAINING"] != "5" {
+		t.Fatalf("PAM environment %v", tr.Env)
+	}
+
+	tr = e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamAuthErr)
+	if len(tr.Errors) != 1 {
+		t.Fatalf("reused code: errors %q", tr.Errors)
+	}
+}
+
+func TestAuthtokPrompt(t *testing.T) {
+	e := newEnv(t)
+	e.configure("authtok_prompt=Token:")
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 1 || tr.Prompts[0] != "Token:" {
+		t.Fatalf("prompts %q", tr.Prompts)
+	}
+}
+
+func TestPromptTemplate(t *testing.T) {
+	e := newEnv(t)
+	tmpl := e.path("prompt.tmpl")
+	if err := os.WriteFile(tmpl, []byte("Code for {{.User}} via {{.Service}} from {{.Host}}: \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e.configure("prompt_template=" + tmpl)
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{rhost: "192.0.2.4", answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	want := "Code for " + e.account.Username + " via ggpam from 192.0.2.4: "
+	if len(tr.Prompts) != 1 || tr.Prompts[0] != want {
+		t.Fatalf("prompts %q, want %q", tr.Prompts, want)
+	}
+}
+
+func TestStateStore(t *testing.T) {
+	e := newEnv(t)
+	store := e.path("state")
+	if err := os.Mkdir(store, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	e.configure("state_store=" + store)
+	cfg := e.enroll(e.account.Username)
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	entries, err := os.ReadDir(store)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("state store is empty: %v", err)
+	}
+	// The shared store rejects the code even if the secret file is reset.
+	e.writeSecret(e.account.Username, cfg, 0o600)
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamAuthErr)
+	// A directory other users can write to would let them lock the store.
+	if err := os.Chmod(store, 0o1733); err != nil {
+		t.Fatal(err)
+	}
+	e.run(request{answer: answers(code(t, cfg, 1))}).expect(t, "auth", pamServiceErr)
+}
+
+func TestSecretOwner(t *testing.T) {
+	e := newEnv(t)
+	owner := e.unprivileged()
+	e.configure("secret_owner=" + owner.Username)
+	cfg := e.enroll(e.account.Username)
+	path := filepath.Join(e.secrets, e.account.Username)
+	e.chown(path, owner)
+	e.chown(e.secrets, owner)
+
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid := fileUID(info); uid != owner.Uid {
+		t.Fatalf("rewritten secret owned by %s, want %s", uid, owner.Uid)
+	}
+}
+
+func TestForcedUser(t *testing.T) {
+	e := newEnv(t)
+	e.configure("user=" + e.account.Username)
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{user: "ggpam-integration-alias", answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+}
+
+func TestAllowedPerm(t *testing.T) {
+	e := newEnv(t)
+	cfg := e.enroll(e.account.Username)
+	e.writeSecret(e.account.Username, cfg, 0o640)
+
+	e.configure()
+	tr := e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamAuthErr)
+	if !tr.logged("exceed allowed_perm") {
+		t.Fatalf("permission error not logged\n%s", tr)
+	}
+	e.configure("allowed_perm=0640")
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+}
+
+func TestGracePeriod(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "192.0.2.10", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+
+	tr := e.run(request{rhost: "192.0.2.10"})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 0 || !tr.logged(i18n.Msgf(i18n.MsgGraceSkip, "192.0.2.10")) {
+		t.Fatalf("grace login prompted or was not logged\n%s", tr)
+	}
+	if tr.Env["GGPAM_METHOD"] != "grace" || tr.Env["GGPAM_GRACE"] != "1" {
+		t.Fatalf("PAM environment %v", tr.Env)
+	}
+	if !strings.Contains(e.secret(e.account.Username), "\" LAST0 192.0.2.10 ") {
+		t.Fatalf("login not recorded:\n%s", e.secret(e.account.Username))
+	}
+	tr = e.run(request{rhost: "192.0.2.11"})
+	if len(tr.Prompts) != 1 {
+		t.Fatalf("other host was not prompted\n%s", tr)
+	}
+}
+
+func TestGracePrefix(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300", "grace_prefix=24")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "192.0.2.10", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if tr := e.run(request{rhost: "192.0.2.77"}); len(tr.Prompts) != 0 {
+		t.Fatalf("same /24 was prompted\n%s", tr)
+	}
+	if tr := e.run(request{rhost: "192.0.3.10"}); len(tr.Prompts) != 1 {
+		t.Fatalf("other /24 was not prompted\n%s", tr)
+	}
+}
+
+func TestGracePrefix6(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300", "grace_prefix6=64")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "2001:db8:0:1::10", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if tr := e.run(request{rhost: "2001:db8:0:1::beef"}); len(tr.Prompts) != 0 {
+		t.Fatalf("same /64 was prompted\n%s", tr)
+	}
+	if tr := e.run(request{rhost: "2001:db8:0:2::10"}); len(tr.Prompts) != 1 {
+		t.Fatalf("other /64 was not prompted\n%s", tr)
+	}
+}
+
+func TestGraceRecords(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300", "grace_records=1")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "192.0.2.10", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	e.run(request{rhost: "192.0.2.20", answer: answers(code(t, cfg, 1))}).expect(t, "auth", pamSuccess)
+	if tr := e.run(request{rhost: "192.0.2.10"}); len(tr.Prompts) != 1 {
+		t.Fatalf("evicted host was not prompted\n%s", tr)
+	}
+	if tr := e.run(request{rhost: "192.0.2.20"}); len(tr.Prompts) != 0 {
+		t.Fatalf("latest host was prompted\n%s", tr)
+	}
+}
+
+func TestGracePerService(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300", "grace_per_service")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "192.0.2.10", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if tr := e.run(request{service: "sshd", rhost: "192.0.2.10"}); len(tr.Prompts) != 1 {
+		t.Fatalf("other service was not prompted\n%s", tr)
+	}
+	if tr := e.run(request{rhost: "192.0.2.10"}); len(tr.Prompts) != 0 {
+		t.Fatalf("same service was prompted\n%s", tr)
+	}
+}
+
+func TestEnrollGraceAndState(t *testing.T) {
+	e := newEnv(t)
+	e.configure("enroll_grace=7d", "enroll_state="+e.path("enroll"))
+	tr := e.run(request{ops: []string{"auth", "acct"}})
+	tr.expect(t, "auth", pamSuccess)
+	tr.expect(t, "acct", pamSuccess)
+	if len(tr.Prompts) != 0 || !tr.logged(i18n.Msgf(i18n.MsgUserNoSecretEnroll, e.account.Username)) {
+		t.Fatalf("unenrolled user was prompted or not logged\n%s", tr)
+	}
+	if len(tr.Infos) != 1 || !strings.Contains(tr.Infos[0], "ggpam init") {
+		t.Fatalf("infos %q", tr.Infos)
+	}
+	if _, err := os.Stat(filepath.Join(e.path("enroll"), e.account.Username)); err != nil {
+		t.Fatalf("first login not recorded: %v", err)
+	}
+}
+
+func TestEnrollSince(t *testing.T) {
+	e := newEnv(t)
+	e.configure("enroll_grace=1d", "enroll_since=2000-01-01", "enroll_state="+e.path("enroll"))
+	tr := e.run(request{ops: []string{"acct"}})
+	tr.expect(t, "acct", pamPermDenied)
+	if len(tr.Errors) != 1 || !strings.Contains(tr.Errors[0], "2000-01-02") {
+		t.Fatalf("errors %q", tr.Errors)
+	}
+	e.enroll(e.account.Username)
+	e.run(request{ops: []string{"acct"}}).expect(t, "acct", pamSuccess)
+}
+
+func TestExemptUser(t *testing.T) {
+	e := newEnv(t)
+	e.configure("exempt_user=" + e.account.Username)
+	e.enroll(e.account.Username)
+	tr := e.run(request{})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 0 || !tr.logged("exempt_user") {
+		t.Fatalf("exempt user was prompted or not logged\n%s", tr)
+	}
+}
+
+func TestExemptUsersFile(t *testing.T) {
+	e := newEnv(t)
+	list := e.path("exempt")
+	if err := os.WriteFile(list, []byte("# service accounts\n"+e.account.Username+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e.configure("exempt_users_file=" + list)
+	e.enroll(e.account.Username)
+	if tr := e.run(request{}); len(tr.Prompts) != 0 || tr.RC["auth"] != pamSuccess {
+		t.Fatalf("listed user was not exempt\n%s", tr)
+	}
+}
+
+func TestExemptGroup(t *testing.T) {
+	e := newEnv(t)
+	group, err := user.LookupGroupId(e.account.Gid)
+	if err != nil {
+		t.Skipf("primary group has no name: %v", err)
+	}
+	e.configure("exempt_group=" + group.Name)
+	e.enroll(e.account.Username)
+	if tr := e.run(request{}); len(tr.Prompts) != 0 || !tr.logged("exempt_group") {
+		t.Fatalf("group member was not exempt\n%s", tr)
+	}
+}
+
+func TestRequireGroup(t *testing.T) {
+	e := newEnv(t)
+	e.configure("require_group=ggpam-integration-nonmembers")
+	e.enroll(e.account.Username)
+	if tr := e.run(request{}); len(tr.Prompts) != 0 || !tr.logged("require_group") {
+		t.Fatalf("non-member was prompted\n%s", tr)
+	}
+
+	group, err := user.LookupGroupId(e.account.Gid)
+	if err != nil {
+		t.Skipf("primary group has no name: %v", err)
+	}
+	e.configure("require_group=" + group.Name)
+	if tr := e.run(request{}); len(tr.Prompts) != 1 {
+		t.Fatalf("member was not prompted\n%s", tr)
+	}
+}
+
+func TestExemptResult(t *testing.T) {
+	e := newEnv(t)
+	e.enroll(e.account.Username)
+	// Without pam_permit a stack of ignoring modules fails.
+	e.stack("ggpam", "auth required $MODULE exempt_user="+e.account.Username)
+	if tr := e.run(request{}); tr.RC["auth"] == pamSuccess {
+		t.Fatalf("PAM_IGNORE alone succeeded\n%s", tr)
+	}
+	e.stack("ggpam", "auth required $MODULE exempt_result=success exempt_user="+e.account.Username)
+	e.run(request{}).expect(t, "auth", pamSuccess)
+}
+
+func TestTrustedNetworks(t *testing.T) {
+	e := newEnv(t)
+	e.configure("trusted_networks=10.0.0.0/8,2001:db8::/32")
+	e.enroll(e.account.Username)
+	tr := e.run(request{rhost: "10.1.2.3"})
+	if len(tr.Prompts) != 0 || !tr.logged(i18n.Msgf(i18n.MsgTrustedNetworkSkip, "10.1.2.3")) {
+		t.Fatalf("trusted host was prompted\n%s", tr)
+	}
+	if tr := e.run(request{rhost: "192.0.2.1"}); len(tr.Prompts) != 1 {
+		t.Fatalf("untrusted host was not prompted\n%s", tr)
+	}
+}
+
+func TestRequireNetworks(t *testing.T) {
+	e := newEnv(t)
+	e.configure("grace_period=300", "require_networks=198.51.100.0/24")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "198.51.100.5", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if tr := e.run(request{rhost: "198.51.100.5"}); len(tr.Prompts) != 1 {
+		t.Fatalf("grace applied to a require_networks host\n%s", tr)
+	}
+}
+
+func TestResolveRhost(t *testing.T) {
+	e := newEnv(t)
+	e.enroll(e.account.Username)
+	e.configure("trusted_networks=127.0.0.0/8,::1/128")
+	if tr := e.run(request{rhost: "localhost"}); len(tr.Prompts) != 1 {
+		t.Fatalf("host name matched without resolve_rhost\n%s", tr)
+	}
+	e.configure("trusted_networks=127.0.0.0/8,::1/128", "resolve_rhost")
+	if tr := e.run(request{rhost: "localhost"}); len(tr.Prompts) != 0 {
+		t.Fatalf("resolved localhost was not trusted\n%s", tr)
+	}
+}
+
+func TestEnrollOnLoginAndIssuer(t *testing.T) {
+	e := newEnv(t)
+	e.configure("enroll_on_login", "enroll_issuer=Integration")
+	var issuer string
+	confirm := func(prompt string, tr *transcript) (string, bool) {
+		for _, info := range tr.Infos {
+			i := strings.Index(info, "otpauth://")
+			if i < 0 {
+				continue
+			}
+			u, err := url.Parse(strings.Fields(info[i:])[0])
+			if err != nil {
+				t.Errorf("parse %q: %v", info, err)
+				return "", false
+			}
+			issuer = u.Query().Get("issuer")
+			cfg := &config.Config{Secret: u.Query().Get("secret")}
+			return code(t, cfg, 0), true
+		}
+		return "", false
+	}
+	tr := e.run(request{answer: confirm})
+	tr.expect(t, "auth", pamSuccess)
+	if issuer != "Integration" {
+		t.Fatalf("issuer = %q\n%s", issuer, tr)
+	}
+	if !tr.logged("enrolled on login") || !strings.Contains(e.secret(e.account.Username), "TOTP_AUTH") {
+		t.Fatalf("secret not written\n%s", tr)
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	e := newEnv(t)
+	params, err := pamcfg.ParseParams([]string{"secret=" + filepath.Join(e.secrets, "%u")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := e.path("ggpam.sock")
+	ln, err := daemon.Listen(socket, 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&daemon.Server{Service: service.New(params)}).Serve(ctx, ln)
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+
+	e.configure("daemon="+socket, "debug")
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if !tr.logged("using ggpamd at " + socket) {
+		t.Fatalf("daemon not used\n%s", tr)
+	}
+	if !strings.Contains(e.secret(e.account.Username), "DISALLOW_REUSE ") {
+		t.Fatal("daemon did not update the secret")
+	}
+}
+
+// passwordStack puts pam_unix in front of the module so that PAM_AUTHTOK is
+// set the way a real password stack does.
+func (e *env) passwordStack(params ...string) {
+	e.stack("ggpam",
+		"auth optional pam_unix.so nodelay",
+		"auth required $MODULE "+strings.Join(params, " "),
+	)
+}
+
+func TestTryFirstPass(t *testing.T) {
+	e := newEnv(t)
+	e.passwordStack("try_first_pass")
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{answer: answers("hunter2" + code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 1 {
+		t.Fatalf("module prompted despite a code in the password\n%s", tr)
+	}
+
+	tr = e.run(request{answer: answers("hunter2", code(t, cfg, 1))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 2 || tr.Prompts[1] != "Verification code: " {
+		t.Fatalf("module did not fall back to a prompt\n%s", tr)
+	}
+}
+
+func TestUseFirstPass(t *testing.T) {
+	e := newEnv(t)
+	e.passwordStack("use_first_pass")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{answer: answers("hunter2" + code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	tr := e.run(request{answer: answers("hunter2")})
+	tr.expect(t, "auth", pamAuthErr)
+	if len(tr.Prompts) != 1 {
+		t.Fatalf("use_first_pass prompted\n%s", tr)
+	}
+}
+
+func TestForwardPass(t *testing.T) {
+	e := newEnv(t)
+	// Applications cannot read PAM_AUTHTOK, so pam_exec hands it to a script.
+	script, forwarded := e.path("authtok.sh"), e.path("authtok")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > "+forwarded+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e.stack("ggpam",
+		"auth required $MODULE forward_pass",
+		"auth required pam_exec.so expose_authtok quiet "+script,
+	)
+	cfg := e.enroll(e.account.Username)
+	tr := e.run(request{answer: answers("hunter2" + code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 1 || tr.Prompts[0] != "Password & verification code: " {
+		t.Fatalf("prompts %q", tr.Prompts)
+	}
+	data, err := os.ReadFile(forwarded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimRight(string(data), "\x00"); got != "hunter2" {
+		t.Fatalf("forwarded authtok %q", got)
+	}
+}
+
+func TestEchoVerificationCode(t *testing.T) {
+	for _, param := range []string{"echo-verification-code", "echo_verification_code"} {
+		e := newEnv(t)
+		e.configure(param)
+		cfg := e.enroll(e.account.Username)
+		tr := e.run(request{answer: answers(code(t, cfg, 0))})
+		tr.expect(t, "auth", pamSuccess)
+		if tr.Styles[0] != 2 {
+			t.Fatalf("%s: prompt style %d, want PAM_PROMPT_ECHO_ON", param, tr.Styles[0])
+		}
+	}
+}
+
+func TestNullOK(t *testing.T) {
+	e := newEnv(t)
+	e.configure()
+	tr := e.run(request{})
+	tr.expect(t, "auth", pamAuthErr)
+	if len(tr.Errors) != 1 {
+		t.Fatalf("missing secret not reported\n%s", tr)
+	}
+	e.configure("nullok")
+	tr = e.run(request{})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 0 || !tr.logged(i18n.Msgf(i18n.MsgUserNoSecretNullOK, e.account.Username)) {
+		t.Fatalf("nullok not honored\n%s", tr)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	e := newEnv(t)
+	e.enroll(e.account.Username)
+	e.configure()
+	if tr := e.run(request{}); tr.logged("debug:") {
+		t.Fatalf("debug output without debug\n%s", tr)
+	}
+	e.configure("debug")
+	if tr := e.run(request{}); !tr.logged("debug: start for user " + e.account.Username) {
+		t.Fatalf("no debug output\n%s", tr)
+	}
+}
+
+func TestNoSkewAdj(t *testing.T) {
+	e := newEnv(t)
+	cfg := e.enroll(e.account.Username)
+	e.configure("noskewadj")
+	e.run(request{answer: answers(code(t, cfg, 10))}).expect(t, "auth", pamAuthErr)
+	if strings.Contains(e.secret(e.account.Username), "RESETTING_TIME_SKEW") {
+		t.Fatal("skew recorded with noskewadj")
+	}
+	e.configure()
+	e.run(request{answer: answers(code(t, cfg, 10))}).expect(t, "auth", pamAuthErr)
+	if !strings.Contains(e.secret(e.account.Username), "RESETTING_TIME_SKEW") {
+		t.Fatalf("skew not recorded:\n%s", e.secret(e.account.Username))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	e := newEnv(t)
+	e.configure("retries=1")
+	cfg := e.enroll(e.account.Username, func(o *enroll.Options) {
+		o.RateLimit = &config.RateLimit{Attempts: 10, Interval: 30 * time.Second}
+	})
+	tr := e.run(request{answer: answers("000000", code(t, cfg, 0))})
+	tr.expect(t, "auth", pamSuccess)
+	if len(tr.Prompts) != 2 || len(tr.Errors) != 1 {
+		t.Fatalf("prompts %q errors %q", tr.Prompts, tr.Errors)
+	}
+	tr = e.run(request{answer: answers("000000", "111111", "222222")})
+	tr.expect(t, "auth", pamMaxTries)
+	if len(tr.Prompts) != 2 || !tr.logged("failed verification 2 times") {
+		t.Fatalf("exhausted retries\n%s", tr)
+	}
+	var attempts int
+	for _, line := range strings.Split(e.secret(e.account.Username), "\n") {
+		if rest, ok := strings.CutPrefix(line, "\" RATE_LIMIT 10 30 "); ok {
+			attempts = len(strings.Fields(rest))
+		}
+	}
+	if attempts < 2 {
+		t.Fatalf("attempts not counted:\n%s", e.secret(e.account.Username))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	e := newEnv(t)
+	e.configure("retries=1", "retry_delay=1")
+	cfg := e.enroll(e.account.Username)
+	start := time.Now()
+	e.run(request{answer: answers("000000", code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retry after %v", elapsed)
+	}
+}
+
+func TestScratchWarn(t *testing.T) {
+	e := newEnv(t)
+	cfg := e.enroll(e.account.Username, func(o *enroll.Options) { o.ScratchCodes = 3 })
+	scratch := func(i int) string { return fmt.Sprintf("%08d", cfg.ScratchCodes[i]) }
+	e.stack("ggpam",
+		"auth required $MODULE scratch_warn=1",
+		"session required $MODULE scratch_warn=1",
+	)
+	tr := e.run(request{answer: answers(scratch(0)), ops: []string{"auth", "session"}})
+	tr.expect(t, "session", pamSuccess)
+	if tr.Env["GGPAM_METHOD"] != "scratch" || tr.Env["GGPAM_SCRATCH_REMAINING"] != "2" || len(tr.Infos) != 0 {
+		t.Fatalf("two codes left: env %v infos %q", tr.Env, tr.Infos)
+	}
+	tr = e.run(request{answer: answers(scratch(1)), ops: []string{"auth", "session"}})
+	tr.expect(t, "session", pamSuccess)
+	if len(tr.Infos) != 1 || !strings.Contains(tr.Infos[0], "only 1 emergency scratch") {
+		t.Fatalf("one code left: infos %q", tr.Infos)
+	}
+}
+
+func TestNoIncrementHOTP(t *testing.T) {
+	e := newEnv(t)
+	cfg := e.enroll(e.account.Username, func(o *enroll.Options) { o.HOTP = true })
+	wrong := hotp(t, cfg, 1000)
+
+	e.configure("no_increment_hotp")
+	e.run(request{answer: answers(wrong)}).expect(t, "auth", pamAuthErr)
+	if !strings.Contains(e.secret(e.account.Username), "HOTP_COUNTER 1\n") {
+		t.Fatalf("counter advanced with no_increment_hotp:\n%s", e.secret(e.account.Username))
+	}
+	e.configure()
+	e.run(request{answer: answers(wrong)}).expect(t, "auth", pamAuthErr)
+	if !strings.Contains(e.secret(e.account.Username), "HOTP_COUNTER 2\n") {
+		t.Fatalf("counter not advanced:\n%s", e.secret(e.account.Username))
+	}
+	e.run(request{answer: answers(hotp(t, cfg, 2))}).expect(t, "auth", pamSuccess)
+}
+
+func TestNoStrictOwner(t *testing.T) {
+	e := newEnv(t)
+	other := e.unprivileged()
+	cfg := e.enroll(e.account.Username)
+	e.chown(filepath.Join(e.secrets, e.account.Username), other)
+
+	e.configure()
+	tr := e.run(request{answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamAuthErr)
+	if !tr.logged("owner=" + other.Uid) {
+		t.Fatalf("owner mismatch not logged\n%s", tr)
+	}
+	e.configure("no_strict_owner")
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+}
+
+func TestAllowReadonly(t *testing.T) {
+	e := newEnv(t)
+	// The module drops privileges to the user, who cannot write to the
+	// root-owned secret directory.
+	account := e.unprivileged()
+	cfg := e.enroll(account.Username)
+	e.chown(filepath.Join(e.secrets, account.Username), account)
+
+	e.configure()
+	tr := e.run(request{user: account.Username, answer: answers(code(t, cfg, 0))})
+	tr.expect(t, "auth", pamAuthErr)
+	e.configure("allow_readonly")
+	tr = e.run(request{user: account.Username, answer: answers(code(t, cfg, 1))})
+	tr.expect(t, "auth", pamSuccess)
+	if !tr.logged("Readonly mode; ignoring write failure") {
+		t.Fatalf("readonly write not logged\n%s", tr)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	e := newEnv(t)
+	// allow_readonly lets the unprivileged user log in below without write
+	// access to the secret directory.
+	e.configure("audit_log="+e.path("audit/audit.log"), "audit_key="+e.path("audit.key"), "allow_readonly")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{rhost: "192.0.2.9", answer: answers("000000")}).expect(t, "auth", pamAuthErr)
+	e.run(request{rhost: "192.0.2.9", answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if os.Geteuid() == 0 {
+		// The log is opened before the module drops privileges to the user.
+		account := e.unprivileged()
+		other := e.enroll(account.Username)
+		e.chown(filepath.Join(e.secrets, account.Username), account)
+		e.run(request{user: account.Username, answer: answers(code(t, other, 0))}).expect(t, "auth", pamSuccess)
+	}
+
+	key, err := logging.LoadAuditKey(e.path("audit.key"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(e.path("audit/audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := logging.VerifyAudit(strings.NewReader(string(data)), key)
+	if err != nil {
+		t.Fatalf("verify: %v\n%s", err, data)
+	}
+	want := 2
+	if os.Geteuid() == 0 {
+		want = 3
+	}
+	if sum.Records != want {
+		t.Fatalf("%d records, want %d\n%s", sum.Records, want, data)
+	}
+	for _, field := range []string{`"event":"auth_failure"`, `"error_class":"invalid_code"`, `"rhost":"192.0.2.9"`, `"result":"totp"`} {
+		if !strings.Contains(string(data), field) {
+			t.Errorf("audit log lacks %s\n%s", field, data)
+		}
+	}
+}
+
+func TestMetricsFile(t *testing.T) {
+	e := newEnv(t)
+	prom := e.path("textfile/ggpam.prom")
+	e.configure("metrics_file="+prom, "allow_readonly")
+	cfg := e.enroll(e.account.Username)
+	e.run(request{answer: answers("000000")}).expect(t, "auth", pamAuthErr)
+	e.run(request{answer: answers(code(t, cfg, 0))}).expect(t, "auth", pamSuccess)
+	if os.Geteuid() == 0 {
+		// The textfile is written after privileges are restored.
+		account := e.unprivileged()
+		other := e.enroll(account.Username)
+		e.chown(filepath.Join(e.secrets, account.Username), account)
+		e.run(request{user: account.Username, answer: answers(code(t, other, 0))}).expect(t, "auth", pamSuccess)
+	}
+
+	s, err := metrics.ReadTextfile(prom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 1.0
+	if os.Geteuid() == 0 {
+		want = 2
+	}
+	if s.Verifications["totp"] != want || s.Failures["invalid_code"] != 1 || s.Latency.Count != want+1 {
+		data, _ := os.ReadFile(prom)
+		t.Fatalf("unexpected totals %+v\n%s", s, data)
+	}
+}
+
+func TestLang(t *testing.T) {
+	e := newEnv(t)
+	e.stack("ggpam",
+		"account required $MODULE lang=de enroll_grace=1d enroll_since=2000-01-01 enroll_state="+e.path("enroll"),
+		"password required $MODULE lang=de",
+	)
+	// The driver runs with LC_ALL=C: the user is answered in German while
+	// the log stays in the locale of the process.
+	tr := e.run(request{ops: []string{"acct"}})
+	tr.expect(t, "acct", pamPermDenied)
+	de := i18n.NewLocalizer("de")
+	deadline, _, _ := strings.Cut(de.Resolve(i18n.MsgEnrollDeadlinePassed), "%")
+	if len(tr.Errors) != 1 || !strings.HasPrefix(tr.Errors[0], deadline) {
+		t.Fatalf("errors %q", tr.Errors)
+	}
+	if !tr.logged("missed the enrollment deadline") {
+		t.Fatalf("log not in English\n%s", tr)
+	}
+
+	// LANG in the secret file beats lang=.
+	e.enroll(e.account.Username, func(o *enroll.Options) { o.Lang = "fr_FR.UTF-8" })
+	tr = e.run(request{ops: []string{"chauthtok"}})
+	tr.expect(t, "chauthtok", pamConvErr)
+	fr := i18n.NewLocalizer("fr")
+	if len(tr.Infos) != 1 || tr.Infos[0] != fr.Resolve(i18n.MsgChauthtokIntro) || tr.Prompts[0] != fr.Resolve(i18n.MsgChauthtokCurrentPrompt) {
+		t.Fatalf("infos %q prompts %q", tr.Infos, tr.Prompts)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * pamdriver runs one PAM transaction against a private pam.d directory and
+ * reports everything the integration tests assert on, one event per line:
+ *
+ *   prompt <style> <text>   conversation prompt; answered from stdin
+ *   error <text>            PAM_ERROR_MSG
+ *   info <text>             PAM_TEXT_INFO
+ *   syslog <prio> <text>    message logged through pam_syslog
+ *   rc <op> <code>          result of pam_authenticate / pam_acct_mgmt /
+ *                           pam_chauthtok / pam_open_session
+ *   env <name>=<value>      PAM environment after the last op
+ *
+ * usage: pamdriver <confdir> <service> <user> [-r rhost] op...
+ * where op is "auth", "acct", "chauthtok" or "session". Newlines in texts are escaped as "\n".
+ */
+#include <security/pam_appl.h>
+#include <stdarg.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <syslog.h>
+
+extern int pam_start_confdir(const char *service_name, const char *user,
+                             const struct pam_conv *pam_conversation,
+                             const char *confdir, pam_handle_t **pamh);
+
+static void emit(const char *kind, const char *text) {
+	printf("%s ", kind);
+	for (const char *p = text; p && *p; p++) {
+		if (*p == '\n')
+			fputs("\\n", stdout);
+		else
+			putchar(*p);
+	}
+	putchar('\n');
+	fflush(stdout);
+}
+
+static void vlog(int priority, const char *fmt, va_list ap) {
+	char buf[4096], label[32];
+	vsnprintf(buf, sizeof(buf), fmt, ap);
+	snprintf(label, sizeof(label), "syslog %d", priority & LOG_PRIMASK);
+	emit(label, buf);
+}
+
+/* Interpose the libc entry points libpam logs through. */
+void syslog(int priority, const char *fmt, ...) {
+	va_list ap;
+	va_start(ap, fmt);
+	vlog(priority, fmt, ap);
+	va_end(ap);
+}
+
+void vsyslog(int priority, const char *fmt, va_list ap) { vlog(priority, fmt, ap); }
+
+void __syslog_chk(int priority, int flag, const char *fmt, ...) {
+	va_list ap;
+	(void)flag;
+	va_start(ap, fmt);
+	vlog(priority, fmt, ap);
+	va_end(ap);
+}
+
+void __vsyslog_chk(int priority, int flag, const char *fmt, va_list ap) {
+	(void)flag;
+	vlog(priority, fmt, ap);
+}
+
+static int conversation(int n, const struct pam_message **msg, struct pam_response **resp, void *data) {
+	struct pam_response *r = calloc(n, sizeof(*r));
+	(void)data;
+	if (r == NULL)
+		return PAM_BUF_ERR;
+	for (int i = 0; i < n; i++) {
+		char line[1024], label[16];
+		switch (msg[i]->msg_style) {
+		case PAM_PROMPT_ECHO_OFF:
+		case PAM_PROMPT_ECHO_ON:
+			snprintf(label, sizeof(label), "prompt %d", msg[i]->msg_style);
+			emit(label, msg[i]->msg);
+			if (fgets(line, sizeof(line), stdin) == NULL) {
+				for (int j = 0; j < i; j++)
+					free(r[j].resp);
+				free(r);
+				return PAM_CONV_ERR;
+			}
+			line[strcspn(line, "\n")] = '\0';
+			r[i].resp = strdup(line);
+			break;
+		case PAM_ERROR_MSG:
+			emit("error", msg[i]->msg);
+			break;
+		case PAM_TEXT_INFO:
+			emit("info", msg[i]->msg);
+			break;
+		}
+	}
+	*resp = r;
+	return PAM_SUCCESS;
+}
+
+int main(int argc, char **argv) {
+	struct pam_conv conv = {conversation, NULL};
+	pam_handle_t *pamh = NULL;
+	int rc, i = 4;
+
+	if (argc < 5) {
+		fprintf(stderr, "usage: %s confdir service user [-r rhost] op...\n", argv[0]);
+		return 2;
+	}
+	rc = pam_start_confdir(argv[2], argv[3], &conv, argv[1], &pamh);
+	if (rc != PAM_SUCCESS) {
+		fprintf(stderr, "pam_start_confdir: %d\n", rc);
+		return 1;
+	}
+	if (i + 1 < argc && strcmp(argv[i], "-r") == 0) {
+		pam_set_item(pamh, PAM_RHOST, argv[i + 1]);
+		i += 2;
+	}
+	for (; i < argc; i++) {
+		char label[32];
+		if (strcmp(argv[i], "auth") == 0) {
+			rc = pam_authenticate(pamh, 0);
+		} else if (strcmp(argv[i], "acct") == 0) {
+			rc = pam_acct_mgmt(pamh, 0);
+		} else if (strcmp(argv[i], "chauthtok") == 0) {
+			rc = pam_chauthtok(pamh, 0);
+		} else if (strcmp(argv[i], "session") == 0) {
+			rc = pam_open_session(pamh, 0);
+		} else {
+			fprintf(stderr, "unknown op %s\n", argv[i]);
+			pam_end(pamh, PAM_SYSTEM_ERR);
+			return 2;
+		}
+		snprintf(label, sizeof(label), "rc %s", argv[i]);
+		printf("%s %d\n", label, rc);
+		fflush(stdout);
+	}
+	char **env = pam_getenvlist(pamh);
+	for (char **e = env; e && *e; e++) {
+		emit("env", *e);
+		free(*e);
+	}
+	free(env);
+	pam_end(pamh, rc);
+	return 0;
+}